@@ -0,0 +1,292 @@
+package certmagic_postgres
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/caddyserver/caddy/v2"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	caddy.RegisterModule(adminAPI{})
+}
+
+// adminAPI exposes the postgres storages in use through the Caddy
+// admin API, so operators can inspect them without psql.
+type adminAPI struct{}
+
+// CaddyModule returns the Caddy module information.
+func (adminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.postgres_storage",
+		New: func() caddy.Module { return new(adminAPI) },
+	}
+}
+
+// Routes returns the admin routes:
+//
+//	GET /storage/postgres/keys?prefix=<prefix>&recursive=true
+//	GET /storage/postgres/keys?key=<key>
+//	GET /storage/postgres/locks
+//	DELETE /storage/postgres/locks?key=<key>
+//	GET /storage/postgres/health
+//	GET /storage/postgres/certificates
+//	POST /storage/postgres/clean
+//
+// The first lists keys with their size and modification time, the
+// second returns the metadata of a single key. Values are never
+// returned. The third lists the locks, and the last force-releases
+// one, such as after a node crashed while renewing a certificate.
+// The health route reports the state of the database, responding
+// with 503 Service Unavailable when it is unreachable or its schema
+// is not what the storage expects, for load balancer health checks.
+// The certificates route lists every stored certificate with its
+// names and validity, soonest expiring first. The clean route runs
+// CleanStorage, with the storage's clean options if it cleans on a
+// schedule and DefaultCleanOptions otherwise.
+// When several storages are in use, one is selected with the storage
+// parameter.
+func (a adminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{Pattern: "/storage/postgres/keys", Handler: caddy.AdminHandlerFunc(a.handleKeys)},
+		{Pattern: "/storage/postgres/locks", Handler: caddy.AdminHandlerFunc(a.handleLocks)},
+		{Pattern: "/storage/postgres/health", Handler: caddy.AdminHandlerFunc(a.handleHealth)},
+		{Pattern: "/storage/postgres/certificates", Handler: caddy.AdminHandlerFunc(a.handleCertificates)},
+		{Pattern: "/storage/postgres/clean", Handler: caddy.AdminHandlerFunc(a.handleClean)},
+	}
+}
+
+// adminKeyInfo is the metadata of a key returned by the admin API.
+type adminKeyInfo struct {
+	Key        string     `json:"key"`
+	Size       int64      `json:"size"`
+	Modified   time.Time  `json:"modified"`
+	Created    *time.Time `json:"created,omitempty"`
+	IsTerminal bool       `json:"is_terminal"`
+}
+
+func (a adminAPI) handleKeys(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: fmt.Errorf("method not allowed")}
+	}
+	storage, err := adminStorage(r)
+	if err != nil {
+		return err
+	}
+
+	query := r.URL.Query()
+	if key := query.Get("key"); key != "" {
+		info, err := storage.StatExtended(r.Context(), key)
+		if errors.Is(err, fs.ErrNotExist) {
+			return caddy.APIError{HTTPStatus: http.StatusNotFound, Err: fmt.Errorf("key not found: %s", key)}
+		}
+		if err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusInternalServerError, Err: err}
+		}
+		return writeAdminJSON(w, adminKeyInfo{Key: info.Key, Size: info.Size, Modified: info.Modified, Created: &info.Created, IsTerminal: info.IsTerminal})
+	}
+
+	infos, err := storage.ListWithInfo(r.Context(), query.Get("prefix"), query.Get("recursive") == "true")
+	if err != nil {
+		return caddy.APIError{HTTPStatus: http.StatusInternalServerError, Err: err}
+	}
+	keys := make([]adminKeyInfo, 0, len(infos))
+	for _, info := range infos {
+		keys = append(keys, adminKeyInfo{Key: info.Key, Size: info.Size, Modified: info.Modified, IsTerminal: info.IsTerminal})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Key < keys[j].Key })
+	return writeAdminJSON(w, keys)
+}
+
+// adminLockInfo is a lock returned by the admin API.
+type adminLockInfo struct {
+	Key      string    `json:"key"`
+	Holder   string    `json:"holder"`
+	Acquired time.Time `json:"acquired"`
+	Expires  time.Time `json:"expires"`
+	Expired  bool      `json:"expired"`
+}
+
+func (a adminAPI) handleLocks(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: fmt.Errorf("method not allowed")}
+	}
+	storage, err := adminStorage(r)
+	if err != nil {
+		return err
+	}
+
+	if r.Method == http.MethodDelete {
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("missing key parameter")}
+		}
+		err := storage.ForceUnlock(r.Context(), key)
+		if errors.Is(err, fs.ErrNotExist) {
+			return caddy.APIError{HTTPStatus: http.StatusNotFound, Err: fmt.Errorf("lock not found: %s", key)}
+		}
+		if err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusInternalServerError, Err: err}
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	infos, err := storage.ListLocks(r.Context())
+	if err != nil {
+		return caddy.APIError{HTTPStatus: http.StatusInternalServerError, Err: err}
+	}
+	now := time.Now()
+	locks := make([]adminLockInfo, 0, len(infos))
+	for _, info := range infos {
+		locks = append(locks, adminLockInfo{Key: info.Key, Holder: info.Holder, Acquired: info.Acquired, Expires: info.Expires, Expired: info.Expires.Before(now)})
+	}
+	return writeAdminJSON(w, locks)
+}
+
+// adminHealth is the health of a storage returned by the admin API.
+type adminHealth struct {
+	Healthy               bool     `json:"healthy"`
+	Connected             bool     `json:"connected"`
+	SchemaProblems        []string `json:"schema_problems,omitempty"`
+	Standby               bool     `json:"standby"`
+	ReadReplica           bool     `json:"read_replica"`
+	ReadReplicaConnected  bool     `json:"read_replica_connected"`
+	ReplicationLagSeconds float64  `json:"replication_lag_seconds"`
+	LastError             string   `json:"last_error,omitempty"`
+}
+
+func (a adminAPI) handleHealth(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: fmt.Errorf("method not allowed")}
+	}
+	storage, err := adminStorage(r)
+	if err != nil {
+		return err
+	}
+
+	status := storage.Status(r.Context())
+	health := adminHealth{
+		Healthy:               status.Healthy(),
+		Connected:             status.Connected,
+		SchemaProblems:        status.SchemaProblems,
+		Standby:               status.Standby,
+		ReadReplica:           status.ReadReplica,
+		ReadReplicaConnected:  status.ReadReplicaConnected,
+		ReplicationLagSeconds: status.ReplicationLag.Seconds(),
+	}
+	if status.LastError != nil {
+		health.LastError = status.LastError.Error()
+	}
+	if !health.Healthy {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	return writeAdminJSON(w, health)
+}
+
+// adminCertificateInfo is a certificate returned by the admin API.
+type adminCertificateInfo struct {
+	Key       string    `json:"key"`
+	Issuer    string    `json:"issuer"`
+	Domain    string    `json:"domain"`
+	Names     []string  `json:"names"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	Expired   bool      `json:"expired"`
+}
+
+func (a adminAPI) handleCertificates(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: fmt.Errorf("method not allowed")}
+	}
+	storage, err := adminStorage(r)
+	if err != nil {
+		return err
+	}
+
+	infos, err := storage.Certificates(r.Context())
+	if err != nil {
+		return caddy.APIError{HTTPStatus: http.StatusInternalServerError, Err: err}
+	}
+	now := time.Now()
+	certificates := make([]adminCertificateInfo, 0, len(infos))
+	for _, info := range infos {
+		certificates = append(certificates, adminCertificateInfo{Key: info.Key, Issuer: info.Issuer, Domain: info.Domain, Names: info.Names, NotBefore: info.NotBefore, NotAfter: info.NotAfter, Expired: info.NotAfter.Before(now)})
+	}
+	return writeAdminJSON(w, certificates)
+}
+
+// adminCleanResult is the outcome of cleaning returned by the admin API.
+type adminCleanResult struct {
+	ExpiredCerts int64 `json:"expired_certs"`
+	OCSPStaples  int64 `json:"ocsp_staples"`
+	Orphans      int64 `json:"orphans"`
+}
+
+func (a adminAPI) handleClean(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: fmt.Errorf("method not allowed")}
+	}
+	storage, err := adminStorage(r)
+	if err != nil {
+		return err
+	}
+
+	opts := DefaultCleanOptions
+	if storage.cleanInterval != 0 {
+		opts = storage.cleanOptions
+	}
+	result, err := storage.CleanStorage(r.Context(), opts)
+	if err != nil {
+		return caddy.APIError{HTTPStatus: http.StatusInternalServerError, Err: err}
+	}
+	return writeAdminJSON(w, adminCleanResult{ExpiredCerts: result.ExpiredCerts, OCSPStaples: result.OCSPStaples, Orphans: result.Orphans})
+}
+
+// adminStorage returns the storage in use selected by the request's
+// storage parameter, which may be omitted when only one is in use.
+func adminStorage(r *http.Request) (Storage, error) {
+	selected := r.URL.Query().Get("storage")
+
+	var ids []string
+	storages := make(map[string]Storage)
+	storagePool.Range(func(key, value any) bool {
+		id := key.(string)[:12]
+		ids = append(ids, id)
+		storages[id] = value.(pooledStorage).Storage
+		return true
+	})
+	sort.Strings(ids)
+
+	if selected != "" {
+		storage, ok := storages[selected]
+		if !ok {
+			return Storage{}, caddy.APIError{HTTPStatus: http.StatusNotFound, Err: fmt.Errorf("unknown storage: %s", selected)}
+		}
+		return storage, nil
+	}
+	switch len(ids) {
+	case 0:
+		return Storage{}, caddy.APIError{HTTPStatus: http.StatusNotFound, Err: fmt.Errorf("no postgres storage in use")}
+	case 1:
+		return storages[ids[0]], nil
+	default:
+		return Storage{}, caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("several postgres storages in use, select one with the storage parameter: %s", strings.Join(ids, ", "))}
+	}
+}
+
+func writeAdminJSON(w http.ResponseWriter, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(v)
+}
+
+// Interface guards
+var (
+	_ caddy.AdminRouter = (*adminAPI)(nil)
+)
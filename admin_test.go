@@ -0,0 +1,108 @@
+package certmagic_postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminAPI_HandleKeys_Errors(t *testing.T) {
+	api := adminAPI{}
+	status := func(method, target string) int {
+		err := api.handleKeys(httptest.NewRecorder(), httptest.NewRequest(method, target, nil))
+		var apiErr caddy.APIError
+		if !errors.As(err, &apiErr) {
+			t.Fatalf("expected an API error, got %v", err)
+		}
+		return apiErr.HTTPStatus
+	}
+
+	assert.Equal(t, http.StatusMethodNotAllowed, status(http.MethodPost, "/storage/postgres/keys"))
+	assert.Equal(t, http.StatusNotFound, status(http.MethodGet, "/storage/postgres/keys"))
+}
+
+func TestAdminAPI_HandleLocks_Errors(t *testing.T) {
+	api := adminAPI{}
+	err := api.handleLocks(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/storage/postgres/locks", nil))
+	var apiErr caddy.APIError
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusMethodNotAllowed, apiErr.HTTPStatus)
+
+	storagePool.LoadOrStore(strings.Repeat("a", 64), pooledStorage{})
+	defer storagePool.Delete(strings.Repeat("a", 64))
+
+	err = api.handleLocks(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/storage/postgres/locks", nil))
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.HTTPStatus)
+}
+
+func TestAdminAPI_HandleCertificates_Errors(t *testing.T) {
+	err := adminAPI{}.handleCertificates(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/storage/postgres/certificates", nil))
+	var apiErr caddy.APIError
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusMethodNotAllowed, apiErr.HTTPStatus)
+}
+
+func TestAdminAPI_HandleClean_Errors(t *testing.T) {
+	err := adminAPI{}.handleClean(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/storage/postgres/clean", nil))
+	var apiErr caddy.APIError
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusMethodNotAllowed, apiErr.HTTPStatus)
+}
+
+func TestAdminAPI_HandleHealth_Unreachable(t *testing.T) {
+	// Nothing listens on port 1, so the ping fails
+	db, err := sql.Open("pgx", "postgres://localhost:1/certmagic?connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	storage, err := Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storagePool.LoadOrStore(strings.Repeat("a", 64), pooledStorage{Storage: storage})
+	defer storagePool.Delete(strings.Repeat("a", 64))
+
+	recorder := httptest.NewRecorder()
+	err = adminAPI{}.handleHealth(recorder, httptest.NewRequest(http.MethodGet, "/storage/postgres/health", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+
+	var health adminHealth
+	assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&health))
+	assert.False(t, health.Healthy)
+	assert.False(t, health.Connected)
+	assert.Contains(t, health.LastError, "failed to ping database")
+}
+
+func TestAdminStorage(t *testing.T) {
+	status := func(target string) int {
+		_, err := adminStorage(httptest.NewRequest(http.MethodGet, target, nil))
+		var apiErr caddy.APIError
+		if !errors.As(err, &apiErr) {
+			t.Fatalf("expected an API error, got %v", err)
+		}
+		return apiErr.HTTPStatus
+	}
+
+	first := strings.Repeat("a", 64)
+	second := strings.Repeat("b", 64)
+	storagePool.LoadOrStore(first, pooledStorage{})
+	storagePool.LoadOrStore(second, pooledStorage{})
+	defer storagePool.Delete(first)
+	defer storagePool.Delete(second)
+
+	assert.Equal(t, http.StatusBadRequest, status("/storage/postgres/keys"))
+	assert.Equal(t, http.StatusNotFound, status("/storage/postgres/keys?storage=cccccccccccc"))
+
+	storage, err := adminStorage(httptest.NewRequest(http.MethodGet, "/storage/postgres/keys?storage=aaaaaaaaaaaa", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, Storage{}, storage)
+}
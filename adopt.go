@@ -0,0 +1,90 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// AdoptionMigration returns a migration preparing existing tables
+// named dataTable and locksTable, created by hand or by an earlier
+// storage plugin with the same key, value and modified columns, for
+// the versioned migrations: they are renamed to certmagic_data and
+// certmagic_locks unless those already exist, their primary keys are
+// renamed to match, and the NULLs the versioned schema forbids are
+// replaced, so Migrate can then add the missing columns and backfill
+// created_at without losing data. The migration is only supported on
+// PostgreSQL itself.
+func AdoptionMigration(dataTable, locksTable string) (Migration, error) {
+	return adoptionMigration(dataTable, locksTable, defaultDataTable, defaultLocksTable)
+}
+
+// adoptionMigration is AdoptionMigration renaming the tables to
+// dataName and locksName.
+func adoptionMigration(dataTable, locksTable, dataName, locksName string) (Migration, error) {
+	if dataTable == "" || locksTable == "" {
+		return Migration{}, fmt.Errorf("invalid table names: must not be empty")
+	}
+
+	var b strings.Builder
+	b.WriteString("DO $$\nDECLARE\n  pkey text;\nBEGIN\n")
+	for _, table := range []struct{ legacy, name string }{{dataTable, dataName}, {locksTable, locksName}} {
+		if table.legacy != table.name {
+			fmt.Fprintf(&b, "  IF to_regclass('%[2]s') IS NULL THEN\n    ALTER TABLE %[1]s RENAME TO %[2]s;\n  END IF;\n", quoteIdentifier(table.legacy), table.name)
+		}
+		fmt.Fprintf(&b, "  SELECT conname INTO pkey FROM pg_constraint WHERE conrelid = '%[1]s'::regclass AND contype = 'p';\n", table.name)
+		fmt.Fprintf(&b, "  IF pkey IS NOT NULL AND pkey <> '%[1]s_pkey' THEN\n    EXECUTE format('ALTER TABLE %[1]s RENAME CONSTRAINT %%I TO %[1]s_pkey', pkey);\n  END IF;\n", table.name)
+	}
+	b.WriteString("END\n$$;\n\n")
+	fmt.Fprintf(&b, "UPDATE %s SET modified = CURRENT_TIMESTAMP WHERE modified IS NULL;\n\n", dataName)
+	fmt.Fprintf(&b, "UPDATE %s SET value = '' WHERE value IS NULL;\n\n", dataName)
+	fmt.Fprintf(&b, "UPDATE %s SET expires = CURRENT_TIMESTAMP WHERE expires IS NULL;\n\n", locksName)
+	fmt.Fprintf(&b, "ALTER TABLE %s\n  ALTER COLUMN value SET NOT NULL,\n  ALTER COLUMN modified SET DEFAULT CURRENT_TIMESTAMP,\n  ALTER COLUMN modified SET NOT NULL;\n\n", dataName)
+	fmt.Fprintf(&b, "ALTER TABLE %s\n  ALTER COLUMN expires SET DEFAULT CURRENT_TIMESTAMP,\n  ALTER COLUMN expires SET NOT NULL;", locksName)
+
+	return Migration{
+		Version: "adopt",
+		SQL:     b.String(),
+	}, nil
+}
+
+// AdoptTables applies AdoptionMigration for dataTable and locksTable
+// in a single transaction, then brings the adopted tables up to date
+// with Migrate, returning the migrations it applied. Adopting tables
+// that were adopted already changes nothing.
+func (s Storage) AdoptTables(ctx context.Context, dataTable, locksTable string) ([]Migration, error) {
+	if s.isDistributed() {
+		return nil, fmt.Errorf("adopting tables is not supported on %s", s.dialect)
+	}
+
+	migration, err := adoptionMigration(dataTable, locksTable, s.tableName(defaultDataTable), s.tableName(defaultLocksTable))
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := s.liftStatementTimeout(ctx, tx); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+		return nil, fmt.Errorf("failed to lock migrations: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, migration.SQL); err != nil {
+		return nil, fmt.Errorf("failed to apply migration %s: %w", migration.Version, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit migrations: %w", err)
+	}
+
+	return s.Migrate(ctx)
+}
+
+// quoteIdentifier returns name quoted for use as an SQL identifier.
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
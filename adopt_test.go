@@ -0,0 +1,25 @@
+package certmagic_postgres
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAdoptionMigration(t *testing.T) {
+	migration, err := AdoptionMigration("legacy_data", `legacy "locks"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "adopt", migration.Version)
+	assert.Contains(t, migration.SQL, `ALTER TABLE "legacy_data" RENAME TO certmagic_data;`)
+	assert.Contains(t, migration.SQL, `ALTER TABLE "legacy ""locks""" RENAME TO certmagic_locks;`)
+
+	migration, err = AdoptionMigration("certmagic_data", "certmagic_locks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(t, migration.SQL, "RENAME TO")
+
+	_, err = AdoptionMigration("", "certmagic_locks")
+	assert.NotNil(t, err)
+}
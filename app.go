@@ -0,0 +1,179 @@
+package certmagic_postgres
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"sort"
+)
+
+// App is the "postgres" Caddy app, holding named connections shared
+// by the storage modules referencing them with their connection
+// field, so they use a single pool and set of credentials.
+type App struct {
+	// Connections are the settings of each connection by name, as
+	// those of a storage module, such as the connection string, pool,
+	// credential and TLS settings.
+	Connections map[string]*CaddyStorage `json:"connections,omitempty"`
+}
+
+func init() {
+	caddy.RegisterModule(App{})
+	httpcaddyfile.RegisterGlobalOption("postgres", parseApp)
+}
+
+// CaddyModule returns the Caddy module information.
+func (App) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "postgres",
+		New: func() caddy.Module { return new(App) },
+	}
+}
+
+// Provision connects every connection.
+func (a *App) Provision(ctx caddy.Context) error {
+	for name, connection := range a.Connections {
+		if connection.Connection != "" {
+			return fmt.Errorf("connection %s: cannot reference another connection", name)
+		}
+		if err := connection.Provision(ctx); err != nil {
+			return fmt.Errorf("connection %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Start does nothing, as connections are made by Provision.
+func (a *App) Start() error { return nil }
+
+// Stop does nothing, as connections are released by Cleanup.
+func (a *App) Stop() error { return nil }
+
+// Cleanup releases every connection.
+func (a *App) Cleanup() error {
+	for _, connection := range a.Connections {
+		if err := connection.Cleanup(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storage returns the storage of the connection named name.
+func (a *App) storage(name string) (Storage, error) {
+	connection, ok := a.Connections[name]
+	if !ok {
+		names := make([]string, 0, len(a.Connections))
+		for name := range a.Connections {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return Storage{}, fmt.Errorf("unknown connection: %s, configured connections: %v", name, names)
+	}
+	return connection.storage, nil
+}
+
+// parseApp sets up the app from the "postgres" global option. Syntax:
+//
+//	postgres {
+//		connection <name> [<connection_string>] {
+//			<storage subdirectives>
+//		}
+//	}
+func parseApp(d *caddyfile.Dispenser, _ any) (any, error) {
+	d.Next() // consume option name
+	app := &App{Connections: make(map[string]*CaddyStorage)}
+	for d.NextBlock(0) {
+		if d.Val() != "connection" {
+			return nil, d.Errf("unrecognized subdirective '%s'", d.Val())
+		}
+		if !d.NextArg() {
+			return nil, d.ArgErr()
+		}
+		name := d.Val()
+		if _, ok := app.Connections[name]; ok {
+			return nil, d.Errf("connection %s already defined", name)
+		}
+
+		// Parse the block as that of a storage module, without the name
+		d.Prev()
+		segment := d.NextSegment()
+		segment = append(segment[:1:1], segment[2:]...)
+		connection := new(CaddyStorage)
+		if err := connection.UnmarshalCaddyfile(caddyfile.NewDispenser(segment)); err != nil {
+			return nil, err
+		}
+		app.Connections[name] = connection
+	}
+
+	return httpcaddyfile.App{
+		Name:  "postgres",
+		Value: caddyconfig.JSON(app, nil),
+	}, nil
+}
+
+// storageSettings are the settings of a storage module which can be
+// combined with a connection of the app, as they don't affect
+// connections. Any other setting is rejected rather than ignored.
+var storageSettings = map[string]bool{
+	"connection":          true,
+	"key_prefix":          true,
+	"query_timeout":       true,
+	"read_timeout":        true,
+	"write_timeout":       true,
+	"list_timeout":        true,
+	"lock_timeout":        true,
+	"lock_poll_interval":  true,
+	"lock_max_wait":       true,
+	"lock_refresh":        true,
+	"instance_id":         true,
+	"retry_attempts":      true,
+	"retry_backoff":       true,
+	"max_value_size":      true,
+	"encryption_key":      true,
+	"encryption_key_file": true,
+}
+
+// checkConnectionSettings returns an error naming the settings of s
+// that configure connections, which cannot be combined with using a
+// connection of the app.
+func (s *CaddyStorage) checkConnectionSettings() error {
+	config, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	var settings map[string]any
+	if err := json.Unmarshal(config, &settings); err != nil {
+		return err
+	}
+	empty, err := json.Marshal(CaddyStorage{})
+	if err != nil {
+		return err
+	}
+	var defaults map[string]any
+	if err := json.Unmarshal(empty, &defaults); err != nil {
+		return err
+	}
+
+	var invalid []string
+	for name, value := range settings {
+		if !storageSettings[name] && fmt.Sprint(value) != fmt.Sprint(defaults[name]) {
+			invalid = append(invalid, name)
+		}
+	}
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		return fmt.Errorf("connection %s cannot be combined with: %v", s.Connection, invalid)
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.App          = (*App)(nil)
+	_ caddy.Provisioner  = (*App)(nil)
+	_ caddy.CleanerUpper = (*App)(nil)
+)
@@ -0,0 +1,109 @@
+package certmagic_postgres
+
+import (
+	"encoding/json"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseApp(t *testing.T) {
+	option := `postgres {
+				connection primary postgres://primary/certmagic {
+					max_open_conns 20
+				}
+				connection archive {
+					connection_string postgres://archive/certmagic
+				}
+			}`
+
+	value, err := parseApp(caddyfile.NewTestDispenser(option), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var app App
+	if err := json.Unmarshal(value.(httpcaddyfile.App).Value, &app); err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, app.Connections, 2)
+	assert.Equal(t, "postgres://primary/certmagic", app.Connections["primary"].ConnectionString)
+	assert.Equal(t, 20, app.Connections["primary"].MaxOpenConns)
+	assert.Equal(t, "postgres://archive/certmagic", app.Connections["archive"].ConnectionString)
+
+	for _, option := range []string{
+		`postgres {
+			connection
+		}`,
+		`postgres {
+			connection primary postgres://primary/certmagic
+			connection primary postgres://other/certmagic
+		}`,
+		`postgres {
+			pool primary
+		}`,
+	} {
+		_, err := parseApp(caddyfile.NewTestDispenser(option), nil)
+		assert.NotNil(t, err, option)
+	}
+}
+
+func TestCaddyStorage_UnmarshalCaddyfile_Connection(t *testing.T) {
+	caddyStorage := &CaddyStorage{}
+	err := caddyStorage.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`postgres {
+				connection primary
+				key_prefix staging/
+			}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "primary", caddyStorage.Connection)
+	assert.Equal(t, "staging/", caddyStorage.KeyPrefix)
+}
+
+func TestCaddyStorage_CheckConnectionSettings(t *testing.T) {
+	assert.Nil(t, (&CaddyStorage{Connection: "primary", KeyPrefix: "staging/", QueryTimeout: "3s"}).checkConnectionSettings())
+
+	err := (&CaddyStorage{Connection: "primary", KeyPrefix: "staging/", MaxOpenConns: 20, SSLMode: "require"}).checkConnectionSettings()
+	assert.EqualError(t, err, "connection primary cannot be combined with: [max_open_conns sslmode]")
+
+	// Every other setting is rejected, rather than ignored
+	fields := reflect.TypeOf(CaddyStorage{})
+	for i := 0; i < fields.NumField(); i++ {
+		field := fields.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "connection" {
+			continue
+		}
+		caddyStorage := CaddyStorage{Connection: "primary"}
+		value := reflect.ValueOf(&caddyStorage).Elem().Field(i)
+		switch value.Kind() {
+		case reflect.String:
+			value.SetString("x")
+		case reflect.Int:
+			value.SetInt(1)
+		case reflect.Bool:
+			value.SetBool(true)
+		case reflect.Pointer:
+			value.Set(reflect.New(field.Type.Elem()))
+		default:
+			t.Fatalf("unexpected type of %s: %s", name, field.Type)
+		}
+		err := caddyStorage.checkConnectionSettings()
+		if storageSettings[name] {
+			assert.Nil(t, err, name)
+		} else {
+			assert.EqualError(t, err, "connection primary cannot be combined with: ["+name+"]", name)
+		}
+	}
+}
+
+func TestApp_Storage(t *testing.T) {
+	app := &App{Connections: map[string]*CaddyStorage{"primary": {}, "archive": {}}}
+	_, err := app.storage("primary")
+	assert.Nil(t, err)
+	_, err = app.storage("replica")
+	assert.EqualError(t, err, "unknown connection: replica, configured connections: [archive primary]")
+}
@@ -0,0 +1,32 @@
+package certmagic_postgres
+
+import (
+	"fmt"
+	"github.com/jackc/pgx/v4"
+)
+
+// defaultApplicationName identifies connections in pg_stat_activity,
+// unless the connection string sets another application_name.
+const defaultApplicationName = "caddy-certmagic"
+
+// WithApplicationName sets application_name on every connection opened
+// by Connect, taking precedence over the connection string. Defaults
+// to "caddy-certmagic".
+func WithApplicationName(name string) Option {
+	return func(storage Storage) (Storage, error) {
+		if name == "" {
+			return storage, fmt.Errorf("invalid application name: must not be empty")
+		}
+		storage.applicationName = name
+		return storage, nil
+	}
+}
+
+// configureApplicationName sets application_name in config, unless
+// the connection string set it and no application name was given.
+func (s Storage) configureApplicationName(config *pgx.ConnConfig) {
+	if _, ok := config.RuntimeParams["application_name"]; ok && s.applicationName == defaultApplicationName {
+		return
+	}
+	config.RuntimeParams["application_name"] = s.applicationName
+}
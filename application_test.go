@@ -0,0 +1,35 @@
+package certmagic_postgres
+
+import (
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestStorage_ConfigureApplicationName(t *testing.T) {
+	tt := []struct {
+		name             string
+		connectionString string
+		options          []Option
+		expected         string
+	}{
+		{name: "default", connectionString: "postgres://localhost/certmagic", expected: "caddy-certmagic"},
+		{name: "connection string", connectionString: "postgres://localhost/certmagic?application_name=edge", expected: "edge"},
+		{name: "option", connectionString: "postgres://localhost/certmagic?application_name=edge", options: []Option{WithApplicationName("caddy-edge")}, expected: "caddy-edge"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("PGAPPNAME", "")
+			storage, err := Open(nil, tc.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			config, err := pgx.ParseConfig(tc.connectionString)
+			if err != nil {
+				t.Fatal(err)
+			}
+			storage.configureApplicationName(config)
+			assert.Equal(t, tc.expected, config.RuntimeParams["application_name"])
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"time"
+)
+
+// Operations recorded in the certmagic_audit table.
+const (
+	// AuditStore is recorded for every key written, including by
+	// StoreBatch, Copy and Move.
+	AuditStore = "store"
+
+	// AuditDelete is recorded for every key deleted by Delete,
+	// DeleteBatch or Move.
+	AuditDelete = "delete"
+
+	// AuditDeleteAll is recorded for the prefix deleted by DeleteAll,
+	// if any key was deleted.
+	AuditDeleteAll = "delete_all"
+
+	// AuditPrune is recorded for the prefix pruned by
+	// PruneModifiedBefore or CleanStorage, if any key was deleted.
+	AuditPrune = "prune"
+
+	// AuditLock is recorded for every lock acquired.
+	AuditLock = "lock"
+
+	// AuditUnlock is recorded for every lock released by Unlock.
+	AuditUnlock = "unlock"
+
+	// AuditForceUnlock is recorded for every lock deleted by
+	// ForceUnlock.
+	AuditForceUnlock = "force_unlock"
+)
+
+// auditPruneInterval is how often entries older than the audit
+// retention are deleted when no clean interval is configured.
+const auditPruneInterval = time.Hour
+
+// AuditEntry is a mutation recorded in the certmagic_audit table.
+type AuditEntry struct {
+	Key        string
+	Operation  string
+	InstanceID string
+	RecordedAt time.Time
+}
+
+// WithAuditLog records every write, delete, lock and unlock in the
+// certmagic_audit table, along with the instance ID and time, to
+// track changes to private keys. Entries are recorded once the change
+// has been made; an entry that fails to be recorded fails the
+// operation. Entries older than retention are deleted in the
+// background, on one instance of the cluster at a time; zero keeps
+// them forever.
+func WithAuditLog(retention time.Duration) Option {
+	return func(storage Storage) (Storage, error) {
+		if retention < 0 {
+			return storage, fmt.Errorf("invalid audit retention: %s", retention)
+		}
+		storage.auditLog = true
+		storage.auditRetention = retention
+		return storage, nil
+	}
+}
+
+// recordAudit records operation on each of keys, if the audit log is
+// enabled.
+func (s Storage) recordAudit(ctx context.Context, operation string, keys ...string) error {
+	if !s.auditLog || len(keys) == 0 {
+		return nil
+	}
+
+	recorded := make([]string, len(keys))
+	for i, key := range keys {
+		recorded[i] = s.keyPrefix + key
+	}
+	return s.run(ctx, opWrite, func(ctx context.Context) error {
+		_, err := s.db.ExecContext(ctx, `INSERT INTO certmagic_audit (tenant, key, operation, instance_id) SELECT $1, unnest($2::text[]), $3, $4`, s.tenant, recorded, operation, s.instanceID)
+		if err != nil {
+			return fmt.Errorf("failed to record audit: %w", err)
+		}
+		return nil
+	})
+}
+
+// AuditLog returns the entries of the certmagic_audit table recorded
+// at or after since, oldest first.
+func (s Storage) AuditLog(ctx context.Context, since time.Time) ([]AuditEntry, error) {
+	return runWithResult(ctx, s, opList, func(ctx context.Context) ([]AuditEntry, error) {
+		rows, err := s.readDB.QueryContext(ctx, `SELECT key, operation, instance_id, recorded_at FROM certmagic_audit WHERE recorded_at >= $1 AND tenant = $2 ORDER BY id`, since, s.tenant)
+		if err != nil {
+			return nil, fmt.Errorf("failed query: %w", err)
+		}
+		defer rows.Close()
+
+		var entries []AuditEntry
+		for rows.Next() {
+			var entry AuditEntry
+			if err := rows.Scan(&entry.Key, &entry.Operation, &entry.InstanceID, &entry.RecordedAt); err != nil {
+				return nil, fmt.Errorf("failed scan: %w", err)
+			}
+			entries = append(entries, entry)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed iterating rows: %w", err)
+		}
+		return entries, nil
+	})
+}
+
+// PruneAuditLog deletes the entries of the certmagic_audit table
+// recorded before the given time, returning the number deleted.
+func (s Storage) PruneAuditLog(ctx context.Context, before time.Time) (int64, error) {
+	return runWithResult(ctx, s, opWrite, func(ctx context.Context) (int64, error) {
+		result, err := s.db.ExecContext(ctx, `DELETE FROM certmagic_audit WHERE recorded_at < $1 AND tenant = $2`, before, s.tenant)
+		if err != nil {
+			return 0, fmt.Errorf("failed exec: %w", err)
+		}
+
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to get affected rows: %w", err)
+		}
+		return deleted, nil
+	})
+}
+
+// pruneAudit deletes the entries older than the audit retention.
+func (s Storage) pruneAudit(ctx context.Context) {
+	deleted, err := s.PruneAuditLog(ctx, time.Now().Add(-s.auditRetention))
+	if err != nil {
+		s.logger.Error("failed to prune audit log", zap.Error(err))
+		return
+	}
+	s.logger.Info("pruned audit log", zap.Int64("entries", deleted))
+}
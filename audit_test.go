@@ -0,0 +1,33 @@
+package certmagic_postgres
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestWithAuditLog(t *testing.T) {
+	_, err := Open(nil, WithAuditLog(-time.Hour))
+	assert.NotNil(t, err)
+
+	storage, err := Open(nil, WithAuditLog(time.Hour*24*365))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, storage.auditLog)
+	assert.Equal(t, time.Hour*24*365, storage.auditRetention)
+}
+
+func TestStorage_JanitorInterval(t *testing.T) {
+	storage, err := Open(nil, WithAuditLog(time.Hour*24))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, auditPruneInterval, storage.janitorInterval())
+
+	storage, err = Open(nil, WithAuditLog(time.Hour*24), WithCleanInterval(time.Hour*6, DefaultCleanOptions))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, time.Hour*6, storage.janitorInterval())
+}
@@ -0,0 +1,139 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ssmPrefix marks a secret source as an SSM Parameter Store
+// parameter name rather than a Secrets Manager secret.
+const ssmPrefix = "ssm:"
+
+// WithAWSConnectionString makes Connect fetch the connection string
+// from AWS when given an empty connection string. The source is the
+// name or ARN of a Secrets Manager secret, or the ARN of an SSM
+// parameter or its name prefixed with "ssm:". With a refresh above
+// zero, the secret is fetched again once it is that old, and new
+// connections pick up a rotated password.
+func WithAWSConnectionString(source string, refresh time.Duration) Option {
+	return func(storage Storage) (Storage, error) {
+		fetch, err := awsSecretFunc(source, refresh)
+		if err != nil {
+			return storage, err
+		}
+		storage.connectionStringFunc = fetch
+		storage.passwordFunc = connectionStringPassword(fetch)
+		return storage, nil
+	}
+}
+
+// WithAWSPassword authenticates every new connection with the
+// password fetched from AWS. The source and refresh are as for
+// WithAWSConnectionString.
+func WithAWSPassword(source string, refresh time.Duration) Option {
+	return func(storage Storage) (Storage, error) {
+		fetch, err := awsSecretFunc(source, refresh)
+		if err != nil {
+			return storage, err
+		}
+		storage.passwordFunc = func(ctx context.Context, _ string, _ uint16, _ string) (string, error) {
+			return fetch(ctx)
+		}
+		return storage, nil
+	}
+}
+
+// awsSecretFunc returns a func fetching the secret at source, using
+// the AWS SDK's default configuration, and caching it for refresh, or
+// forever if refresh is zero. The secret is fetched once up front, so
+// a missing secret or missing permissions fail at startup.
+func awsSecretFunc(source string, refresh time.Duration) (func(ctx context.Context) (string, error), error) {
+	if source == "" {
+		return nil, fmt.Errorf("invalid AWS secret: must not be empty")
+	}
+	if refresh < 0 {
+		return nil, fmt.Errorf("invalid AWS secret refresh: %s", refresh)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+
+	fetch := fetchSecretsManagerSecret(cfg, source)
+	if name, ok := ssmParameterName(source); ok {
+		fetch = fetchSSMParameter(cfg, name)
+	}
+
+	value, err := fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var mu sync.Mutex
+	fetched := time.Now()
+
+	return func(ctx context.Context) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if refresh == 0 || time.Since(fetched) < refresh {
+			return value, nil
+		}
+		v, err := fetch(ctx)
+		if err != nil {
+			return "", err
+		}
+		value, fetched = v, time.Now()
+		return value, nil
+	}, nil
+}
+
+// ssmParameterName returns the parameter name of source if it refers
+// to an SSM parameter, either by ARN or by name prefixed with "ssm:".
+// Full ARNs are accepted by GetParameter as the name.
+func ssmParameterName(source string) (string, bool) {
+	if strings.HasPrefix(source, ssmPrefix) {
+		return strings.TrimPrefix(source, ssmPrefix), true
+	}
+	if strings.HasPrefix(source, "arn:") && strings.Contains(source, ":ssm:") {
+		return source, true
+	}
+	return "", false
+}
+
+func fetchSecretsManagerSecret(cfg aws.Config, secretID string) func(ctx context.Context) (string, error) {
+	client := secretsmanager.NewFromConfig(cfg)
+	return func(ctx context.Context) (string, error) {
+		output, err := client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(secretID)})
+		if err != nil {
+			return "", fmt.Errorf("failed to get secret %s: %w", secretID, err)
+		}
+		if output.SecretString == nil {
+			return "", fmt.Errorf("secret %s has no string value", secretID)
+		}
+		return *output.SecretString, nil
+	}
+}
+
+func fetchSSMParameter(cfg aws.Config, name string) func(ctx context.Context) (string, error) {
+	client := ssm.NewFromConfig(cfg)
+	return func(ctx context.Context) (string, error) {
+		output, err := client.GetParameter(ctx, &ssm.GetParameterInput{Name: aws.String(name), WithDecryption: aws.Bool(true)})
+		if err != nil {
+			return "", fmt.Errorf("failed to get parameter %s: %w", name, err)
+		}
+		if output.Parameter == nil || output.Parameter.Value == nil {
+			return "", fmt.Errorf("parameter %s has no value", name)
+		}
+		return *output.Parameter.Value, nil
+	}
+}
@@ -0,0 +1,26 @@
+package certmagic_postgres
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSSMParameterName(t *testing.T) {
+	tt := []struct {
+		source string
+		name   string
+		ssm    bool
+	}{
+		{source: "ssm:/prod/certmagic/password", name: "/prod/certmagic/password", ssm: true},
+		{source: "arn:aws:ssm:eu-west-1:123456789012:parameter/prod/certmagic", name: "arn:aws:ssm:eu-west-1:123456789012:parameter/prod/certmagic", ssm: true},
+		{source: "arn:aws:secretsmanager:eu-west-1:123456789012:secret:prod/certmagic-AbCdEf", ssm: false},
+		{source: "prod/certmagic/dsn", ssm: false},
+	}
+	for _, tc := range tt {
+		t.Run(tc.source, func(t *testing.T) {
+			name, ok := ssmParameterName(tc.source)
+			assert.Equal(t, tc.ssm, ok)
+			assert.Equal(t, tc.name, name)
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// azureDatabaseScope is the scope of Microsoft Entra ID (Azure AD)
+// access tokens for Azure Database for PostgreSQL.
+const azureDatabaseScope = "https://ossrdbms-aad.database.windows.net/.default"
+
+// WithAzureADAuth authenticates to Azure Database for PostgreSQL with
+// Microsoft Entra ID (Azure AD) access tokens instead of a password.
+// Tokens are acquired with the Azure SDK's default credential chain:
+// client credentials or workload identity from the AZURE_*
+// environment variables, or else the managed identity, selected by
+// AZURE_CLIENT_ID when user-assigned. The SDK caches tokens and
+// renews them before they expire. The user in the connection string
+// must be the Entra ID principal's database role.
+func WithAzureADAuth() Option {
+	return func(storage Storage) (Storage, error) {
+		credential, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return storage, fmt.Errorf("failed to load Azure credentials: %w", err)
+		}
+
+		storage.passwordFunc = func(ctx context.Context, host string, port uint16, user string) (string, error) {
+			token, err := credential.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{azureDatabaseScope}})
+			if err != nil {
+				return "", fmt.Errorf("failed to get Azure access token: %w", err)
+			}
+			return token.Token, nil
+		}
+		return storage, nil
+	}
+}
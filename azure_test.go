@@ -0,0 +1,14 @@
+package certmagic_postgres
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestWithAzureADAuth(t *testing.T) {
+	storage, err := Open(nil, WithAzureADAuth())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotNil(t, storage.passwordFunc)
+}
@@ -0,0 +1,193 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// StoreBatch puts every value in values at its key within a single
+// transaction, so either all of them are written or none are.
+func (s Storage) StoreBatch(ctx context.Context, values map[string][]byte) error {
+	encrypted := make(map[string][]byte, len(values))
+	for key, value := range values {
+		if err := s.validateKey(key); err != nil {
+			return err
+		}
+		if err := s.validateValue(key, value); err != nil {
+			return err
+		}
+		var err error
+		if encrypted[key], err = s.encryptValue(key, value); err != nil {
+			return err
+		}
+	}
+	values = encrypted
+
+	// Write in key order, so concurrent batches lock rows in the same order
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	err := s.run(ctx, opWrite, func(ctx context.Context) error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		for _, key := range keys {
+			inline, external := s.inlineValue(values[key])
+			_, err := tx.ExecContext(ctx, s.tables(`INSERT INTO certmagic_data (tenant, key, value, original_key, external) VALUES ($4, $1, $2, $3, $5) ON CONFLICT (tenant, key) DO UPDATE SET VALUE = $2, external = $5, modified = CURRENT_TIMESTAMP`), s.encodeKey(key), inline, s.originalKey(key), s.tenant, external)
+			if err != nil {
+				return fmt.Errorf("failed to store key: %s: %w", key, err)
+			}
+			if err := s.storeBlob(ctx, tx, s.encodeKey(key), values[key], external); err != nil {
+				return err
+			}
+		}
+
+		return tx.Commit()
+	})
+	if err != nil {
+		return err
+	}
+	return s.recordAudit(ctx, AuditStore, keys...)
+}
+
+// DeleteBatch deletes every key in keys within a single transaction.
+// Keys that don't exist are ignored.
+func (s Storage) DeleteBatch(ctx context.Context, keys []string) error {
+	if err := s.validateKeys(keys...); err != nil {
+		return err
+	}
+
+	err := s.run(ctx, opWrite, func(ctx context.Context) error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		sorted := append([]string(nil), keys...)
+		sort.Strings(sorted)
+
+		for _, key := range sorted {
+			if _, err := tx.ExecContext(ctx, s.tables(`DELETE FROM certmagic_data WHERE key = $1 AND tenant = $2`), s.encodeKey(key), s.tenant); err != nil {
+				return fmt.Errorf("failed to delete key: %s: %w", key, err)
+			}
+		}
+
+		return tx.Commit()
+	})
+	if err != nil {
+		return err
+	}
+	return s.recordAudit(ctx, AuditDelete, keys...)
+}
+
+// DeleteAll deletes the key named prefix, along with every key
+// below it when prefix is treated as a directory, in a single
+// statement. It returns the number of keys deleted. An empty
+// prefix is rejected rather than deleting everything.
+func (s Storage) DeleteAll(ctx context.Context, prefix string) (int64, error) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return 0, fmt.Errorf("refusing to delete all keys: prefix must not be empty")
+	}
+
+	deleted, err := runWithResult(ctx, s, opWrite, func(ctx context.Context) (int64, error) {
+		query := fmt.Sprintf(s.tables(`DELETE FROM certmagic_data WHERE (%[1]s = $1 OR %[1]s LIKE $2 ESCAPE '\') AND tenant = $3`), s.keyColumn())
+		result, err := s.db.ExecContext(ctx, query, s.keyPrefix+prefix, escapeLike(s.keyPrefix+directoryPrefix(prefix))+"%", s.tenant)
+		if err != nil {
+			return 0, fmt.Errorf("failed exec: %w", err)
+		}
+
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to get affected rows: %w", err)
+		}
+		return deleted, nil
+	})
+	if err != nil || deleted == 0 {
+		return deleted, err
+	}
+	return deleted, s.recordAudit(ctx, AuditDeleteAll, prefix)
+}
+
+// LoadMany retrieves the values at keys in a single query. Keys that
+// don't exist are left out of the returned map.
+func (s Storage) LoadMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	return s.loadMany(ctx, s.db, keys)
+}
+
+// loadMany is LoadMany reading from db, which is the read replica for
+// reads tolerating staleness.
+func (s Storage) loadMany(ctx context.Context, db *sql.DB, keys []string) (map[string][]byte, error) {
+	if err := s.validateKeys(keys...); err != nil {
+		return nil, err
+	}
+
+	return runWithResult(ctx, s, opRead, func(ctx context.Context) (map[string][]byte, error) {
+		encoded, originals := s.encodeKeys(keys)
+		query := s.tables(fmt.Sprintf(`SELECT key, %s FROM certmagic_data WHERE key = ANY($1) AND tenant = $2`, valueColumn))
+		rows, err := db.QueryContext(ctx, query, encoded, s.tenant)
+		if err != nil {
+			return nil, fmt.Errorf("failed query: %w", err)
+		}
+		defer rows.Close()
+
+		values := make(map[string][]byte, len(keys))
+		for rows.Next() {
+			var key string
+			var value []byte
+			if err := rows.Scan(&key, &value); err != nil {
+				return nil, fmt.Errorf("failed scan: %w", err)
+			}
+			if values[originals[key]], err = s.decryptValue(originals[key], value); err != nil {
+				return nil, err
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed iterating rows: %w", err)
+		}
+		return values, nil
+	})
+}
+
+// ExistsMany reports, for each of keys, whether it exists, using a
+// single query.
+func (s Storage) ExistsMany(ctx context.Context, keys []string) (map[string]bool, error) {
+	if err := s.validateKeys(keys...); err != nil {
+		return nil, err
+	}
+
+	return runWithResult(ctx, s, opRead, func(ctx context.Context) (map[string]bool, error) {
+		encoded, originals := s.encodeKeys(keys)
+		rows, err := s.db.QueryContext(ctx, s.tables(`SELECT key FROM certmagic_data WHERE key = ANY($1) AND tenant = $2`), encoded, s.tenant)
+		if err != nil {
+			return nil, fmt.Errorf("failed query: %w", err)
+		}
+		defer rows.Close()
+
+		exists := make(map[string]bool, len(keys))
+		for _, key := range keys {
+			exists[key] = false
+		}
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				return nil, fmt.Errorf("failed scan: %w", err)
+			}
+			exists[originals[key]] = true
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed iterating rows: %w", err)
+		}
+		return exists, nil
+	})
+}
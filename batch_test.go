@@ -0,0 +1,114 @@
+package certmagic_postgres_test
+
+import (
+	"context"
+	"github.com/fluidgalleries/certmagic-postgres"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestStorage_StoreBatch(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.StoreBatch(context.Background(), map[string][]byte{
+		"site/example.com.crt":  []byte("cert"),
+		"site/example.com.key":  []byte("key"),
+		"site/example.com.json": []byte("meta"),
+	})
+	require.Nil(t, err)
+
+	value, err := storage.Load(context.Background(), "site/example.com.key")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("key"), value)
+
+	keys, err := storage.List(context.Background(), "site", false)
+	require.Nil(t, err)
+	assert.Len(t, keys, 3)
+}
+
+func TestStorage_DeleteBatch(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = storage.Store(context.Background(), "abc", []byte("value"))
+	_ = storage.Store(context.Background(), "xyz", []byte("value"))
+	_ = storage.Store(context.Background(), "keep", []byte("value"))
+
+	err = storage.DeleteBatch(context.Background(), []string{"abc", "xyz", "missing"})
+	require.Nil(t, err)
+
+	assert.False(t, storage.Exists(context.Background(), "abc"))
+	assert.False(t, storage.Exists(context.Background(), "xyz"))
+	assert.True(t, storage.Exists(context.Background(), "keep"))
+}
+
+func TestStorage_DeleteAll(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = storage.Store(context.Background(), "site/a", []byte("value"))
+	_ = storage.Store(context.Background(), "site/b/c", []byte("value"))
+	_ = storage.Store(context.Background(), "site_other/d", []byte("value"))
+	_ = storage.Store(context.Background(), "sites", []byte("value"))
+
+	deleted, err := storage.DeleteAll(context.Background(), "site")
+	require.Nil(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	assert.False(t, storage.Exists(context.Background(), "site/b/c"))
+	assert.True(t, storage.Exists(context.Background(), "site_other/d"))
+	assert.True(t, storage.Exists(context.Background(), "sites"))
+
+	_, err = storage.DeleteAll(context.Background(), "/")
+	assert.NotNil(t, err)
+}
+
+func TestStorage_LoadMany(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = storage.Store(context.Background(), "abc", []byte("first"))
+	_ = storage.Store(context.Background(), "xyz", []byte("second"))
+
+	values, err := storage.LoadMany(context.Background(), []string{"abc", "xyz", "missing"})
+	require.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"abc": []byte("first"), "xyz": []byte("second")}, values)
+}
+
+func TestStorage_ExistsMany(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = storage.Store(context.Background(), "abc", []byte("value"))
+
+	exists, err := storage.ExistsMany(context.Background(), []string{"abc", "missing"})
+	require.Nil(t, err)
+	assert.Equal(t, map[string]bool{"abc": true, "missing": false}, exists)
+}
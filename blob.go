@@ -0,0 +1,55 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"fmt"
+)
+
+// valueColumn is the SQL expression for the value of a row of
+// certmagic_data, which is read from certmagic_blobs for values
+// stored out of line.
+const valueColumn = `CASE WHEN external THEN (SELECT b.value FROM certmagic_blobs b WHERE b.tenant = certmagic_data.tenant AND b.key = certmagic_data.key) ELSE value END`
+
+// WithExternalValues stores values larger than threshold bytes out
+// of line in the certmagic_blobs table, keeping only their metadata
+// in certmagic_data, so that large values such as exported archives
+// don't bloat the main table. Values stored out of line are read
+// regardless of this option.
+func WithExternalValues(threshold int) Option {
+	return func(storage Storage) (Storage, error) {
+		if threshold < 1 {
+			return storage, fmt.Errorf("invalid external value threshold: %d", threshold)
+		}
+		storage.externalThreshold = threshold
+		return storage, nil
+	}
+}
+
+// inlineValue returns the value to store in certmagic_data for value,
+// which is empty if value is to be stored out of line, and whether it is.
+func (s Storage) inlineValue(value []byte) ([]byte, bool) {
+	if s.externalThreshold > 0 && len(value) > s.externalThreshold {
+		return []byte{}, true
+	}
+	return value, false
+}
+
+// storeBlob stores value out of line for the row just written at the
+// encoded key if external, or otherwise removes any out of line value
+// left behind by an earlier write. It must run in the same transaction
+// as the write.
+func (s Storage) storeBlob(ctx context.Context, q querier, encodedKey string, value []byte, external bool) error {
+	if external {
+		if _, err := q.ExecContext(ctx, `INSERT INTO certmagic_blobs (tenant, key, value) VALUES ($3, $1, $2) ON CONFLICT (tenant, key) DO UPDATE SET value = $2`, encodedKey, value, s.tenant); err != nil {
+			return fmt.Errorf("failed to store value out of line: %w", err)
+		}
+		return nil
+	}
+
+	if s.externalThreshold > 0 {
+		if _, err := q.ExecContext(ctx, `DELETE FROM certmagic_blobs WHERE key = $1 AND tenant = $2`, encodedKey, s.tenant); err != nil {
+			return fmt.Errorf("failed exec: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package certmagic_postgres_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/fluidgalleries/certmagic-postgres"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestStorage_ExternalValues(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db, certmagic_postgres.WithExternalValues(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large := bytes.Repeat([]byte("x"), 100)
+	err = storage.Store(context.Background(), "archive", large)
+	require.Nil(t, err)
+
+	var inline int
+	err = db.QueryRow(`SELECT LENGTH (value) FROM certmagic_data WHERE key = 'archive'`).Scan(&inline)
+	require.Nil(t, err)
+	assert.Equal(t, 0, inline)
+
+	value, err := storage.Load(context.Background(), "archive")
+	require.Nil(t, err)
+	assert.Equal(t, large, value)
+
+	info, err := storage.Stat(context.Background(), "archive")
+	require.Nil(t, err)
+	assert.Equal(t, int64(100), info.Size)
+
+	err = storage.Copy(context.Background(), "archive", "copy")
+	require.Nil(t, err)
+	value, err = storage.Load(context.Background(), "copy")
+	require.Nil(t, err)
+	assert.Equal(t, large, value)
+
+	err = storage.Store(context.Background(), "archive", []byte("small"))
+	require.Nil(t, err)
+	value, err = storage.Load(context.Background(), "archive")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("small"), value)
+
+	err = storage.Delete(context.Background(), "copy")
+	require.Nil(t, err)
+
+	var blobs int
+	err = db.QueryRow(`SELECT COUNT(*) FROM certmagic_blobs`).Scan(&blobs)
+	require.Nil(t, err)
+	assert.Equal(t, 0, blobs)
+}
+
+func TestStorage_WithExternalValues_Invalid(t *testing.T) {
+	_, err := certmagic_postgres.Open(nil, certmagic_postgres.WithExternalValues(0))
+	assert.NotNil(t, err)
+}
@@ -1,16 +1,100 @@
 package certmagic_postgres
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyevents"
 	"github.com/caddyserver/certmagic"
+	"go.opentelemetry.io/otel"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type CaddyStorage struct {
-	ConnectionString string `json:"connection_string"`
-	QueryTimeout     string `json:"query_timeout"`
-	LockTimeout      string `json:"lock_timeout"`
-	storage          Storage
+	Connection           string `json:"connection"`
+	ConnectionString     string `json:"connection_string"`
+	ReadConnectionString string `json:"read_connection_string"`
+	Env                  bool   `json:"env"`
+	Driver               string `json:"driver"`
+	Host                 string `json:"host"`
+	Port                 int    `json:"port"`
+	User                 string `json:"user"`
+	Password             string `json:"password"`
+	DBName               string `json:"dbname"`
+	ConnectionStringFile string `json:"connection_string_file"`
+	PasswordFile         string `json:"password_file"`
+	ConnectionStringAWS  string `json:"connection_string_aws"`
+	PasswordAWS          string `json:"password_aws"`
+	AWSSecretRefresh     string `json:"aws_secret_refresh"`
+	StatementTimeout     bool   `json:"statement_timeout"`
+	ApplicationName      string `json:"application_name"`
+	Schema               string `json:"schema"`
+	TableNameData        string `json:"table_name_data"`
+	TableNameLocks       string `json:"table_name_locks"`
+	KeyPrefix            string `json:"key_prefix"`
+	UnixSocket           string `json:"unix_socket"`
+	MaxOpenConns         int    `json:"max_open_conns"`
+	MaxIdleConns         *int   `json:"max_idle_conns,omitempty"`
+	ConnMaxLifetime      string `json:"conn_max_lifetime"`
+	ConnMaxIdleTime      string `json:"conn_max_idle_time"`
+	QueryTimeout         string `json:"query_timeout"`
+	ReadTimeout          string `json:"read_timeout"`
+	WriteTimeout         string `json:"write_timeout"`
+	ListTimeout          string `json:"list_timeout"`
+	LockTimeout          string `json:"lock_timeout"`
+	LockPollInterval     string `json:"lock_poll_interval"`
+	LockMaxWait          string `json:"lock_max_wait"`
+	LockRefresh          string `json:"lock_refresh"`
+	InstanceID           string `json:"instance_id"`
+	LockPoolSize         int    `json:"lock_pool_size"`
+	LockStrategy         string `json:"lock_strategy"`
+	RetryAttempts        int    `json:"retry_attempts"`
+	RetryBackoff         string `json:"retry_backoff"`
+	StartupAttempts      int    `json:"startup_attempts"`
+	StartupBackoff       string `json:"startup_backoff"`
+	StartupMaxWait       string `json:"startup_max_wait"`
+	LazyConnect          bool   `json:"lazy_connect"`
+	HealthInterval       string `json:"health_interval"`
+	HealthFailures       int    `json:"health_failures"`
+	CleanInterval        string `json:"clean_interval"`
+	CleanGracePeriod     string `json:"clean_grace_period"`
+	AuditLog             bool   `json:"audit_log"`
+	AuditRetention       string `json:"audit_retention"`
+	Failover             bool   `json:"failover"`
+	Tracing              bool   `json:"tracing"`
+	MaxValueSize         int    `json:"max_value_size"`
+	Dialect              string `json:"dialect"`
+	AutoMigrate          bool   `json:"auto_migrate"`
+	ValidateSchema       *bool  `json:"validate_schema,omitempty"`
+	UnloggedTables       bool   `json:"unlogged_tables"`
+	ValueStorage         string `json:"value_storage"`
+	ValueCompression     string `json:"value_compression"`
+	SSLMode              string `json:"sslmode"`
+	SSLRootCert          string `json:"sslrootcert"`
+	SSLCert              string `json:"sslcert"`
+	SSLKey               string `json:"sslkey"`
+	RDSIAMAuth           bool   `json:"rds_iam_auth"`
+	RDSRegion            string `json:"rds_region"`
+	CloudSQLInstance     string `json:"cloudsql_instance"`
+	CloudSQLIAMAuthN     bool   `json:"cloudsql_iam_authn"`
+	AzureADAuth          bool   `json:"azure_ad_auth"`
+	EncryptionKey        string `json:"encryption_key"`
+	EncryptionKeyFile    string `json:"encryption_key_file"`
+	storage              Storage
+	poolKey              string
+	ctx                  caddy.Context
+	events               *caddyevents.App
 }
 
 func init() {
@@ -26,24 +110,528 @@ func (CaddyStorage) CaddyModule() caddy.ModuleInfo {
 }
 
 // Provision configures a new Storage instance using config values obtained from Caddy config
-func (s *CaddyStorage) Provision(caddy.Context) error {
-	var options []Option
+func (s *CaddyStorage) Provision(ctx caddy.Context) error {
+	logger := newHandoverCore(ctx.Logger().Core())
+	options := []Option{WithLogger(ctx.Logger().WithOptions(zap.WrapCore(func(zapcore.Core) zapcore.Core { return logger })))}
 	if s.QueryTimeout != "" {
 		options = append(options, WithQueryTimeout(s.QueryTimeout))
 	}
+	if s.ReadTimeout != "" {
+		options = append(options, WithReadTimeout(s.ReadTimeout))
+	}
+	if s.WriteTimeout != "" {
+		options = append(options, WithWriteTimeout(s.WriteTimeout))
+	}
+	if s.ListTimeout != "" {
+		options = append(options, WithListTimeout(s.ListTimeout))
+	}
 	if s.LockTimeout != "" {
 		options = append(options, WithLockTimeout(s.LockTimeout))
 	}
+	if s.LockPollInterval != "" {
+		options = append(options, WithLockPollInterval(s.LockPollInterval))
+	}
+	if s.LockMaxWait != "" {
+		options = append(options, WithMaxLockWait(s.LockMaxWait))
+	}
+	if s.LockRefresh != "" {
+		options = append(options, WithLockRefresh(s.LockRefresh))
+	}
+	if s.InstanceID != "" {
+		options = append(options, WithInstanceID(s.InstanceID))
+	}
+	if s.LockPoolSize != 0 {
+		options = append(options, WithLockPoolSize(s.LockPoolSize))
+	}
+	if s.LockStrategy != "" {
+		options = append(options, WithLockStrategy(s.LockStrategy))
+	}
+	if s.Driver != "" {
+		options = append(options, WithDriver(s.Driver))
+	}
+	if s.RetryAttempts != 0 {
+		policy := RetryPolicy{MaxAttempts: s.RetryAttempts, InitialBackoff: time.Millisecond * 100}
+		if s.RetryBackoff != "" {
+			backoff, err := time.ParseDuration(s.RetryBackoff)
+			if err != nil {
+				return fmt.Errorf("invalid retry backoff: %w", err)
+			}
+			policy.InitialBackoff = backoff
+		}
+		options = append(options, WithRetryPolicy(policy))
+	}
+	if s.StartupAttempts != 0 {
+		policy := RetryPolicy{MaxAttempts: s.StartupAttempts, InitialBackoff: time.Second * 1, MaxBackoff: time.Second * 30}
+		if s.StartupBackoff != "" {
+			backoff, err := time.ParseDuration(s.StartupBackoff)
+			if err != nil {
+				return fmt.Errorf("invalid startup backoff: %w", err)
+			}
+			policy.InitialBackoff = backoff
+		}
+		var maxWait time.Duration
+		if s.StartupMaxWait != "" {
+			var err error
+			maxWait, err = time.ParseDuration(s.StartupMaxWait)
+			if err != nil {
+				return fmt.Errorf("invalid startup max wait: %w", err)
+			}
+		}
+		options = append(options, WithStartupRetry(policy, maxWait))
+	}
+	if s.LazyConnect {
+		options = append(options, WithLazyConnect())
+	}
+	if s.HealthInterval != "" {
+		interval, err := time.ParseDuration(s.HealthInterval)
+		if err != nil {
+			return fmt.Errorf("invalid health interval: %w", err)
+		}
+		failures := s.HealthFailures
+		if failures == 0 {
+			failures = 3
+		}
+		options = append(options, WithHealthMonitor(interval, failures))
+	}
+	if s.CleanInterval != "" {
+		interval, err := time.ParseDuration(s.CleanInterval)
+		if err != nil {
+			return fmt.Errorf("invalid clean interval: %w", err)
+		}
+		opts := DefaultCleanOptions
+		if s.CleanGracePeriod != "" {
+			opts.ExpiredCertGracePeriod, err = time.ParseDuration(s.CleanGracePeriod)
+			if err != nil {
+				return fmt.Errorf("invalid clean grace period: %w", err)
+			}
+		}
+		options = append(options, WithCleanInterval(interval, opts))
+	}
+	if s.AuditRetention != "" && !s.AuditLog {
+		return fmt.Errorf("audit_retention requires audit_log")
+	}
+	if s.AuditLog {
+		var retention time.Duration
+		if s.AuditRetention != "" {
+			var err error
+			retention, err = time.ParseDuration(s.AuditRetention)
+			if err != nil {
+				return fmt.Errorf("invalid audit retention: %w", err)
+			}
+		}
+		options = append(options, WithAuditLog(retention))
+	}
+	if s.Failover {
+		options = append(options, WithFailover())
+	}
+	if s.Tracing {
+		options = append(options, WithTracerProvider(otel.GetTracerProvider()))
+	}
+	if s.StatementTimeout {
+		options = append(options, WithStatementTimeout())
+	}
+	if s.ApplicationName != "" {
+		options = append(options, WithApplicationName(s.ApplicationName))
+	}
+	if s.Schema != "" {
+		options = append(options, WithSchema(s.Schema))
+	}
+	if s.TableNameData != "" || s.TableNameLocks != "" {
+		data, locks := s.TableNameData, s.TableNameLocks
+		if data == "" {
+			data = defaultDataTable
+		}
+		if locks == "" {
+			locks = defaultLocksTable
+		}
+		options = append(options, WithTableNames(data, locks))
+	}
+	if s.KeyPrefix != "" {
+		options = append(options, WithKeyPrefix(s.KeyPrefix))
+	}
+	if s.UnixSocket != "" {
+		options = append(options, WithUnixSocket(s.UnixSocket))
+	}
+	if s.MaxOpenConns != 0 {
+		options = append(options, WithMaxOpenConns(s.MaxOpenConns))
+	}
+	if s.MaxIdleConns != nil {
+		options = append(options, WithMaxIdleConns(*s.MaxIdleConns))
+	}
+	if s.ConnMaxLifetime != "" {
+		options = append(options, WithConnMaxLifetime(s.ConnMaxLifetime))
+	}
+	if s.ConnMaxIdleTime != "" {
+		options = append(options, WithConnMaxIdleTime(s.ConnMaxIdleTime))
+	}
+	if s.MaxValueSize != 0 {
+		options = append(options, WithMaxValueSize(s.MaxValueSize))
+	}
+	if s.Dialect != "" {
+		options = append(options, WithDialect(s.Dialect))
+	}
+	if s.AutoMigrate {
+		options = append(options, WithAutoMigrate())
+	}
+	if s.ValidateSchema != nil && !*s.ValidateSchema {
+		options = append(options, WithoutSchemaValidation())
+	}
+	if s.UnloggedTables {
+		options = append(options, WithUnloggedTables())
+	}
+	if s.ValueStorage != "" {
+		options = append(options, WithValueStorage(s.ValueStorage))
+	}
+	if s.ValueCompression != "" {
+		options = append(options, WithValueCompression(s.ValueCompression))
+	}
+	if s.SSLMode != "" {
+		options = append(options, WithSSLMode(s.SSLMode))
+	}
+	if s.SSLRootCert != "" {
+		options = append(options, WithSSLRootCert(s.SSLRootCert))
+	}
+	if s.SSLCert != "" {
+		options = append(options, WithSSLCert(s.SSLCert))
+	}
+	if s.SSLKey != "" {
+		options = append(options, WithSSLKey(s.SSLKey))
+	}
+	if s.RDSIAMAuth {
+		options = append(options, WithRDSIAMAuth(s.RDSRegion))
+	}
+	if s.CloudSQLInstance != "" {
+		options = append(options, WithCloudSQL(s.CloudSQLInstance, s.CloudSQLIAMAuthN))
+	}
+	if s.AzureADAuth {
+		options = append(options, WithAzureADAuth())
+	}
+	if s.ConnectionStringFile != "" {
+		options = append(options, WithConnectionStringFile(s.ConnectionStringFile))
+	}
+	if s.PasswordFile != "" {
+		options = append(options, WithPasswordFile(s.PasswordFile))
+	}
+	if s.ConnectionStringAWS != "" || s.PasswordAWS != "" {
+		var refresh time.Duration
+		if s.AWSSecretRefresh != "" {
+			var err error
+			refresh, err = time.ParseDuration(s.AWSSecretRefresh)
+			if err != nil {
+				return fmt.Errorf("invalid AWS secret refresh: %w", err)
+			}
+		}
+		if s.ConnectionStringAWS != "" {
+			options = append(options, WithAWSConnectionString(s.ConnectionStringAWS, refresh))
+		}
+		if s.PasswordAWS != "" {
+			options = append(options, WithAWSPassword(s.PasswordAWS, refresh))
+		}
+	}
 
-	var err error
-	s.storage, err = Connect(s.ConnectionString, options...)
-	return err
+	connectionString, err := expandConnectionString(s.ConnectionString)
+	if err != nil {
+		return err
+	}
+	if s.Host != "" {
+		connectionString, err = s.assembleConnectionString()
+		if err != nil {
+			return err
+		}
+	}
+	if s.Env {
+		if s.ConnectionString != "" || s.Host != "" {
+			return fmt.Errorf("env cannot be combined with connection_string or host")
+		}
+		// Resolved here rather than by Connect, so that the pool key
+		// changes along with the environment
+		connectionString, err = ConnectionStringFromEnv()
+		if err != nil {
+			return err
+		}
+	}
+
+	readConnectionString, err := expandConnectionString(s.ReadConnectionString)
+	if err != nil {
+		return err
+	}
+	if readConnectionString != "" {
+		options = append(options, WithReadConnectionString(readConnectionString))
+	}
+
+	encryptionKey, err := s.encryptionKey()
+	if err != nil {
+		return err
+	}
+	if encryptionKey != nil {
+		options = append(options, WithEncryptionKey(encryptionKey))
+	}
+
+	if s.Connection != "" {
+		if err := s.provisionConnection(ctx, options); err != nil {
+			return err
+		}
+		return s.provisionEvents(ctx)
+	}
+	if connectionString == "" && s.ConnectionStringFile == "" && s.ConnectionStringAWS == "" && s.UnixSocket == "" && !s.Env {
+		return fmt.Errorf("missing connection_string, or env to connect with the PG* environment variables")
+	}
+
+	// Reuse the storage of an identical config, such as the one being
+	// replaced by a reload, instead of opening new connections
+	key, err := s.storagePoolKey(connectionString, readConnectionString, encryptionKey)
+	if err != nil {
+		return err
+	}
+	value, loaded, err := storagePool.LoadOrNew(key, func() (caddy.Destructor, error) {
+		storage, err := Connect(connectionString, options...)
+		if err != nil {
+			return nil, err
+		}
+		return pooledStorage{Storage: storage, logger: logger}, nil
+	})
+	if err != nil {
+		return err
+	}
+	pooled := value.(pooledStorage)
+	if loaded {
+		// The storage, along with its health monitor, now logs to this
+		// config's logs, which outlive those of the config it replaces
+		pooled.logger.set(ctx.Logger().Core())
+	}
+	s.storage = pooled.Storage
+	s.poolKey = key
+
+	if err := registerMetrics(); err != nil {
+		return err
+	}
+	return s.provisionEvents(ctx)
+}
+
+// provisionConnection sets up the storage on the connection of the
+// postgres app named by s.Connection, applying options to it.
+func (s *CaddyStorage) provisionConnection(ctx caddy.Context, options []Option) error {
+	if err := s.checkConnectionSettings(); err != nil {
+		return err
+	}
+	app, err := ctx.App("postgres")
+	if err != nil {
+		return fmt.Errorf("getting postgres app: %v", err)
+	}
+	storage, err := app.(*App).storage(s.Connection)
+	if err != nil {
+		return err
+	}
+	for _, option := range options {
+		if storage, err = option(storage); err != nil {
+			return err
+		}
+	}
+	s.storage = storage
+	return registerMetrics()
+}
+
+// provisionEvents sets up emitting events if the config subscribes to
+// any.
+func (s *CaddyStorage) provisionEvents(ctx caddy.Context) error {
+	events, err := ctx.AppIfConfigured("events")
+	if errors.Is(err, caddy.ErrNotConfigured) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("getting events app: %v", err)
+	}
+	s.ctx = ctx
+	s.events = events.(*caddyevents.App)
+	return nil
+}
+
+// storagePool shares storages between Caddy configs with identical
+// settings, across reloads and modules.
+var storagePool = caddy.NewUsagePool()
+
+// pooledStorage is a Storage shut down once no config uses it anymore.
+type pooledStorage struct {
+	Storage
+	logger *handoverCore
+}
+
+func (p pooledStorage) Destruct() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return p.Shutdown(ctx)
+}
+
+// storagePoolKey identifies the storage for the config, the expanded
+// connection strings and the encryption key. It is hashed, so secrets
+// aren't kept.
+func (s *CaddyStorage) storagePoolKey(connectionString, readConnectionString string, encryptionKey []byte) (string, error) {
+	config, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	hash.Write(config)
+	hash.Write([]byte{0})
+	hash.Write([]byte(connectionString))
+	hash.Write([]byte{0})
+	hash.Write([]byte(readConnectionString))
+	hash.Write([]byte{0})
+	hash.Write(encryptionKey)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// assembleConnectionString builds a connection string from the host,
+// port, user, password and dbname fields, expanding placeholders in
+// each and quoting them, so passwords needn't be URL-encoded.
+func (s *CaddyStorage) assembleConnectionString() (string, error) {
+	var port string
+	if s.Port != 0 {
+		port = strconv.Itoa(s.Port)
+	}
+	params := []struct {
+		keyword string
+		value   string
+	}{
+		{"host", s.Host},
+		{"port", port},
+		{"user", s.User},
+		{"password", s.Password},
+		{"dbname", s.DBName},
+	}
+
+	repl := caddy.NewReplacer()
+	var connectionString []string
+	for _, param := range params {
+		if param.value == "" {
+			continue
+		}
+		value, err := repl.ReplaceOrErr(param.value, true, true)
+		if err != nil {
+			return "", fmt.Errorf("failed to expand %s: %w", param.keyword, err)
+		}
+		connectionString = append(connectionString, param.keyword+"="+quoteConnectionValue(strings.TrimSpace(value)))
+	}
+	return strings.Join(connectionString, " "), nil
+}
+
+// expandConnectionString expands placeholders in connectionString,
+// such as {env.DATABASE_URL} or {file./run/secrets/dsn}, so secrets
+// needn't appear in the config. Placeholders expanding to nothing are
+// an error.
+func expandConnectionString(connectionString string) (string, error) {
+	expanded, err := caddy.NewReplacer().ReplaceOrErr(connectionString, true, true)
+	if err != nil {
+		return "", fmt.Errorf("failed to expand connection string: %w", err)
+	}
+	return strings.TrimSpace(expanded), nil
+}
+
+// encryptionKey decodes the base64 encryption key given by
+// encryption_key, expanding placeholders such as {env.ENCRYPTION_KEY},
+// or read from encryption_key_file. It returns nil if neither is set.
+func (s *CaddyStorage) encryptionKey() ([]byte, error) {
+	if s.EncryptionKey != "" && s.EncryptionKeyFile != "" {
+		return nil, fmt.Errorf("encryption_key cannot be combined with encryption_key_file")
+	}
+	repl := caddy.NewReplacer()
+	encoded, err := repl.ReplaceOrErr(s.EncryptionKey, true, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand encryption key: %w", err)
+	}
+	if s.EncryptionKeyFile != "" {
+		path, err := repl.ReplaceOrErr(s.EncryptionKeyFile, true, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand encryption key file: %w", err)
+		}
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key file: %w", err)
+		}
+		encoded = string(contents)
+	}
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	return key, nil
 }
 
 // UnmarshalCaddyfile sets up the Storage from Caddyfile tokens. Syntax:
 //
 // postgres [<connection_string>] {
+//     connection <name>
 //     connection_string <connection_string>
+//     env
+//     read_connection_string <connection_string>
+//     encryption_key <base64_key>
+//     encryption_key_file <path>
+//     driver stdlib|pgxpool
+//     host <host>
+//     port <port>
+//     user <user>
+//     password <password>
+//     dbname <dbname>
+//     connection_string_file <path>
+//     password_file <path>
+//     connection_string_aws <secret>
+//     password_aws <secret>
+//     aws_secret_refresh <duration>
+//     query_timeout <duration>
+//     read_timeout <duration>
+//     write_timeout <duration>
+//     list_timeout <duration>
+//     lock_timeout <duration>
+//     lock_poll_interval <duration>
+//     lock_max_wait <duration>
+//     lock_refresh <duration>
+//     instance_id <id>
+//     lock_pool_size <size>
+//     lock_strategy lease|row
+//     retry_attempts <attempts>
+//     retry_backoff <duration>
+//     startup_attempts <attempts>
+//     startup_backoff <duration>
+//     startup_max_wait <duration>
+//     lazy_connect
+//     health_interval <duration>
+//     health_failures <failures>
+//     clean_interval <duration>
+//     clean_grace_period <duration>
+//     audit_log
+//     audit_retention <duration>
+//     failover
+//     tracing
+//     statement_timeout
+//     application_name <name>
+//     schema <schema>
+//     table_name_data <table>
+//     table_name_locks <table>
+//     key_prefix <prefix>
+//     unix_socket <directory>
+//     max_open_conns <conns>
+//     max_idle_conns <conns>
+//     conn_max_lifetime <duration>
+//     conn_max_idle_time <duration>
+//     max_value_size <bytes>
+//     dialect <dialect>
+//     auto_migrate
+//     validate_schema [on|off]
+//     unlogged_tables
+//     value_storage <storage>
+//     value_compression <compression>
+//     sslmode <sslmode>
+//     sslrootcert <path>
+//     sslcert <path>
+//     sslkey <path>
+//     rds_iam_auth [<region>]
+//     cloudsql_instance <instance>
+//     cloudsql_iam_authn
+//     azure_ad_auth
 // }
 //
 // Expansion of placeholders in the API token is left to the JSON config caddy.Provisioner (above).
@@ -57,6 +645,14 @@ func (s *CaddyStorage) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 		}
 		for nesting := d.Nesting(); d.NextBlock(nesting); {
 			switch d.Val() {
+			case "connection":
+				if s.Connection != "" {
+					return d.Err("Connection already set")
+				}
+				if !d.AllArgs(&s.Connection) {
+					return d.ArgErr()
+				}
+
 			case "connection_string":
 				if s.ConnectionString != "" {
 					return d.Err("ConnectionString already set")
@@ -65,6 +661,135 @@ func (s *CaddyStorage) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 					return d.ArgErr()
 				}
 
+			case "env":
+				if s.Env {
+					return d.Err("Env already set")
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				s.Env = true
+
+			case "read_connection_string":
+				if s.ReadConnectionString != "" {
+					return d.Err("ReadConnectionString already set")
+				}
+				if !d.AllArgs(&s.ReadConnectionString) {
+					return d.ArgErr()
+				}
+
+			case "encryption_key":
+				if s.EncryptionKey != "" {
+					return d.Err("EncryptionKey already set")
+				}
+				if !d.AllArgs(&s.EncryptionKey) {
+					return d.ArgErr()
+				}
+
+			case "encryption_key_file":
+				if s.EncryptionKeyFile != "" {
+					return d.Err("EncryptionKeyFile already set")
+				}
+				if !d.AllArgs(&s.EncryptionKeyFile) {
+					return d.ArgErr()
+				}
+
+			case "driver":
+				if s.Driver != "" {
+					return d.Err("Driver already set")
+				}
+				if !d.AllArgs(&s.Driver) {
+					return d.ArgErr()
+				}
+
+			case "host":
+				if s.Host != "" {
+					return d.Err("Host already set")
+				}
+				if !d.AllArgs(&s.Host) {
+					return d.ArgErr()
+				}
+
+			case "port":
+				if s.Port != 0 {
+					return d.Err("Port already set")
+				}
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				port, err := strconv.Atoi(d.Val())
+				if err != nil || port < 1 || port > 65535 {
+					return d.Errf("invalid port '%s'", d.Val())
+				}
+				s.Port = port
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+
+			case "user":
+				if s.User != "" {
+					return d.Err("User already set")
+				}
+				if !d.AllArgs(&s.User) {
+					return d.ArgErr()
+				}
+
+			case "password":
+				if s.Password != "" {
+					return d.Err("Password already set")
+				}
+				if !d.AllArgs(&s.Password) {
+					return d.ArgErr()
+				}
+
+			case "dbname":
+				if s.DBName != "" {
+					return d.Err("DBName already set")
+				}
+				if !d.AllArgs(&s.DBName) {
+					return d.ArgErr()
+				}
+
+			case "connection_string_file":
+				if s.ConnectionStringFile != "" {
+					return d.Err("ConnectionStringFile already set")
+				}
+				if !d.AllArgs(&s.ConnectionStringFile) {
+					return d.ArgErr()
+				}
+
+			case "password_file":
+				if s.PasswordFile != "" {
+					return d.Err("PasswordFile already set")
+				}
+				if !d.AllArgs(&s.PasswordFile) {
+					return d.ArgErr()
+				}
+
+			case "connection_string_aws":
+				if s.ConnectionStringAWS != "" {
+					return d.Err("ConnectionStringAWS already set")
+				}
+				if !d.AllArgs(&s.ConnectionStringAWS) {
+					return d.ArgErr()
+				}
+
+			case "password_aws":
+				if s.PasswordAWS != "" {
+					return d.Err("PasswordAWS already set")
+				}
+				if !d.AllArgs(&s.PasswordAWS) {
+					return d.ArgErr()
+				}
+
+			case "aws_secret_refresh":
+				if s.AWSSecretRefresh != "" {
+					return d.Err("AWSSecretRefresh already set")
+				}
+				if !d.AllArgs(&s.AWSSecretRefresh) {
+					return d.ArgErr()
+				}
+
 			case "query_timeout":
 				if s.QueryTimeout != "" {
 					return d.Err("QueryTimeout already set")
@@ -73,6 +798,30 @@ func (s *CaddyStorage) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 					return d.ArgErr()
 				}
 
+			case "read_timeout":
+				if s.ReadTimeout != "" {
+					return d.Err("ReadTimeout already set")
+				}
+				if !d.AllArgs(&s.ReadTimeout) {
+					return d.ArgErr()
+				}
+
+			case "write_timeout":
+				if s.WriteTimeout != "" {
+					return d.Err("WriteTimeout already set")
+				}
+				if !d.AllArgs(&s.WriteTimeout) {
+					return d.ArgErr()
+				}
+
+			case "list_timeout":
+				if s.ListTimeout != "" {
+					return d.Err("ListTimeout already set")
+				}
+				if !d.AllArgs(&s.ListTimeout) {
+					return d.ArgErr()
+				}
+
 			case "lock_timeout":
 				if s.LockTimeout != "" {
 					return d.Err("LockTimeout already set")
@@ -81,12 +830,472 @@ func (s *CaddyStorage) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 					return d.ArgErr()
 				}
 
+			case "lock_poll_interval":
+				if s.LockPollInterval != "" {
+					return d.Err("LockPollInterval already set")
+				}
+				if !d.AllArgs(&s.LockPollInterval) {
+					return d.ArgErr()
+				}
+
+			case "lock_max_wait":
+				if s.LockMaxWait != "" {
+					return d.Err("LockMaxWait already set")
+				}
+				if !d.AllArgs(&s.LockMaxWait) {
+					return d.ArgErr()
+				}
+
+			case "lock_refresh":
+				if s.LockRefresh != "" {
+					return d.Err("LockRefresh already set")
+				}
+				if !d.AllArgs(&s.LockRefresh) {
+					return d.ArgErr()
+				}
+
+			case "instance_id":
+				if s.InstanceID != "" {
+					return d.Err("InstanceID already set")
+				}
+				if !d.AllArgs(&s.InstanceID) {
+					return d.ArgErr()
+				}
+
+			case "lock_pool_size":
+				if s.LockPoolSize != 0 {
+					return d.Err("LockPoolSize already set")
+				}
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				size, err := strconv.Atoi(d.Val())
+				if err != nil {
+					return d.Errf("invalid lock_pool_size '%s': %v", d.Val(), err)
+				}
+				s.LockPoolSize = size
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+
+			case "lock_strategy":
+				if s.LockStrategy != "" {
+					return d.Err("LockStrategy already set")
+				}
+				if !d.AllArgs(&s.LockStrategy) {
+					return d.ArgErr()
+				}
+
+			case "retry_attempts":
+				if s.RetryAttempts != 0 {
+					return d.Err("RetryAttempts already set")
+				}
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				attempts, err := strconv.Atoi(d.Val())
+				if err != nil {
+					return d.Errf("invalid retry_attempts '%s': %v", d.Val(), err)
+				}
+				s.RetryAttempts = attempts
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+
+			case "retry_backoff":
+				if s.RetryBackoff != "" {
+					return d.Err("RetryBackoff already set")
+				}
+				if !d.AllArgs(&s.RetryBackoff) {
+					return d.ArgErr()
+				}
+
+			case "startup_attempts":
+				if s.StartupAttempts != 0 {
+					return d.Err("StartupAttempts already set")
+				}
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				attempts, err := strconv.Atoi(d.Val())
+				if err != nil {
+					return d.Errf("invalid startup_attempts '%s': %v", d.Val(), err)
+				}
+				s.StartupAttempts = attempts
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+
+			case "startup_backoff":
+				if s.StartupBackoff != "" {
+					return d.Err("StartupBackoff already set")
+				}
+				if !d.AllArgs(&s.StartupBackoff) {
+					return d.ArgErr()
+				}
+
+			case "startup_max_wait":
+				if s.StartupMaxWait != "" {
+					return d.Err("StartupMaxWait already set")
+				}
+				if !d.AllArgs(&s.StartupMaxWait) {
+					return d.ArgErr()
+				}
+
+			case "lazy_connect":
+				if s.LazyConnect {
+					return d.Err("LazyConnect already set")
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				s.LazyConnect = true
+
+			case "health_interval":
+				if s.HealthInterval != "" {
+					return d.Err("HealthInterval already set")
+				}
+				if !d.AllArgs(&s.HealthInterval) {
+					return d.ArgErr()
+				}
+
+			case "health_failures":
+				if s.HealthFailures != 0 {
+					return d.Err("HealthFailures already set")
+				}
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				failures, err := strconv.Atoi(d.Val())
+				if err != nil {
+					return d.Errf("invalid health_failures '%s': %v", d.Val(), err)
+				}
+				s.HealthFailures = failures
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+
+			case "clean_interval":
+				if s.CleanInterval != "" {
+					return d.Err("CleanInterval already set")
+				}
+				if !d.AllArgs(&s.CleanInterval) {
+					return d.ArgErr()
+				}
+
+			case "clean_grace_period":
+				if s.CleanGracePeriod != "" {
+					return d.Err("CleanGracePeriod already set")
+				}
+				if !d.AllArgs(&s.CleanGracePeriod) {
+					return d.ArgErr()
+				}
+
+			case "audit_log":
+				if s.AuditLog {
+					return d.Err("AuditLog already set")
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				s.AuditLog = true
+
+			case "audit_retention":
+				if s.AuditRetention != "" {
+					return d.Err("AuditRetention already set")
+				}
+				if !d.AllArgs(&s.AuditRetention) {
+					return d.ArgErr()
+				}
+
+			case "failover":
+				if s.Failover {
+					return d.Err("Failover already set")
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				s.Failover = true
+
+			case "tracing":
+				if s.Tracing {
+					return d.Err("Tracing already set")
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				s.Tracing = true
+
+			case "statement_timeout":
+				if s.StatementTimeout {
+					return d.Err("StatementTimeout already set")
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				s.StatementTimeout = true
+
+			case "application_name":
+				if s.ApplicationName != "" {
+					return d.Err("ApplicationName already set")
+				}
+				if !d.AllArgs(&s.ApplicationName) {
+					return d.ArgErr()
+				}
+
+			case "schema":
+				if s.Schema != "" {
+					return d.Err("Schema already set")
+				}
+				if !d.AllArgs(&s.Schema) {
+					return d.ArgErr()
+				}
+
+			case "table_name_data":
+				if s.TableNameData != "" {
+					return d.Err("TableNameData already set")
+				}
+				if !d.AllArgs(&s.TableNameData) {
+					return d.ArgErr()
+				}
+
+			case "table_name_locks":
+				if s.TableNameLocks != "" {
+					return d.Err("TableNameLocks already set")
+				}
+				if !d.AllArgs(&s.TableNameLocks) {
+					return d.ArgErr()
+				}
+
+			case "key_prefix":
+				if s.KeyPrefix != "" {
+					return d.Err("KeyPrefix already set")
+				}
+				if !d.AllArgs(&s.KeyPrefix) {
+					return d.ArgErr()
+				}
+
+			case "unix_socket":
+				if s.UnixSocket != "" {
+					return d.Err("UnixSocket already set")
+				}
+				if !d.AllArgs(&s.UnixSocket) {
+					return d.ArgErr()
+				}
+
+			case "max_open_conns":
+				if s.MaxOpenConns != 0 {
+					return d.Err("MaxOpenConns already set")
+				}
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				conns, err := strconv.Atoi(d.Val())
+				if err != nil || conns < 1 {
+					return d.Errf("invalid max_open_conns '%s': must be a positive integer", d.Val())
+				}
+				s.MaxOpenConns = conns
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+
+			case "max_idle_conns":
+				if s.MaxIdleConns != nil {
+					return d.Err("MaxIdleConns already set")
+				}
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				conns, err := strconv.Atoi(d.Val())
+				if err != nil || conns < 0 {
+					return d.Errf("invalid max_idle_conns '%s': must be a non-negative integer", d.Val())
+				}
+				s.MaxIdleConns = &conns
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+
+			case "conn_max_lifetime":
+				if s.ConnMaxLifetime != "" {
+					return d.Err("ConnMaxLifetime already set")
+				}
+				if !d.AllArgs(&s.ConnMaxLifetime) {
+					return d.ArgErr()
+				}
+				if _, err := time.ParseDuration(s.ConnMaxLifetime); err != nil {
+					return d.Errf("invalid conn_max_lifetime '%s': %v", s.ConnMaxLifetime, err)
+				}
+
+			case "conn_max_idle_time":
+				if s.ConnMaxIdleTime != "" {
+					return d.Err("ConnMaxIdleTime already set")
+				}
+				if !d.AllArgs(&s.ConnMaxIdleTime) {
+					return d.ArgErr()
+				}
+				if _, err := time.ParseDuration(s.ConnMaxIdleTime); err != nil {
+					return d.Errf("invalid conn_max_idle_time '%s': %v", s.ConnMaxIdleTime, err)
+				}
+
+			case "max_value_size":
+				if s.MaxValueSize != 0 {
+					return d.Err("MaxValueSize already set")
+				}
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				size, err := strconv.Atoi(d.Val())
+				if err != nil {
+					return d.Errf("invalid max_value_size '%s': %v", d.Val(), err)
+				}
+				s.MaxValueSize = size
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+
+			case "dialect":
+				if s.Dialect != "" {
+					return d.Err("Dialect already set")
+				}
+				if !d.AllArgs(&s.Dialect) {
+					return d.ArgErr()
+				}
+
+			case "auto_migrate":
+				if s.AutoMigrate {
+					return d.Err("AutoMigrate already set")
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				s.AutoMigrate = true
+
+			case "validate_schema":
+				if s.ValidateSchema != nil {
+					return d.Err("ValidateSchema already set")
+				}
+				validate := true
+				if d.NextArg() {
+					switch d.Val() {
+					case "on":
+					case "off":
+						validate = false
+					default:
+						return d.Errf("invalid validate_schema: %s", d.Val())
+					}
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				s.ValidateSchema = &validate
+
+			case "unlogged_tables":
+				if s.UnloggedTables {
+					return d.Err("UnloggedTables already set")
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				s.UnloggedTables = true
+
+			case "value_storage":
+				if s.ValueStorage != "" {
+					return d.Err("ValueStorage already set")
+				}
+				if !d.AllArgs(&s.ValueStorage) {
+					return d.ArgErr()
+				}
+
+			case "value_compression":
+				if s.ValueCompression != "" {
+					return d.Err("ValueCompression already set")
+				}
+				if !d.AllArgs(&s.ValueCompression) {
+					return d.ArgErr()
+				}
+
+			case "sslmode":
+				if s.SSLMode != "" {
+					return d.Err("SSLMode already set")
+				}
+				if !d.AllArgs(&s.SSLMode) {
+					return d.ArgErr()
+				}
+
+			case "sslrootcert":
+				if s.SSLRootCert != "" {
+					return d.Err("SSLRootCert already set")
+				}
+				if !d.AllArgs(&s.SSLRootCert) {
+					return d.ArgErr()
+				}
+
+			case "sslcert":
+				if s.SSLCert != "" {
+					return d.Err("SSLCert already set")
+				}
+				if !d.AllArgs(&s.SSLCert) {
+					return d.ArgErr()
+				}
+
+			case "sslkey":
+				if s.SSLKey != "" {
+					return d.Err("SSLKey already set")
+				}
+				if !d.AllArgs(&s.SSLKey) {
+					return d.ArgErr()
+				}
+
+			case "rds_iam_auth":
+				if s.RDSIAMAuth {
+					return d.Err("RDSIAMAuth already set")
+				}
+				if d.NextArg() {
+					s.RDSRegion = d.Val()
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				s.RDSIAMAuth = true
+
+			case "cloudsql_instance":
+				if s.CloudSQLInstance != "" {
+					return d.Err("CloudSQLInstance already set")
+				}
+				if !d.AllArgs(&s.CloudSQLInstance) {
+					return d.ArgErr()
+				}
+
+			case "cloudsql_iam_authn":
+				if s.CloudSQLIAMAuthN {
+					return d.Err("CloudSQLIAMAuthN already set")
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				s.CloudSQLIAMAuthN = true
+
+			case "azure_ad_auth":
+				if s.AzureADAuth {
+					return d.Err("AzureADAuth already set")
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				s.AzureADAuth = true
+
 			default:
 				return d.Errf("unrecognized subdirective '%s'", d.Val())
 			}
 		}
 	}
-	if s.ConnectionString == "" {
+	if s.ConnectionString != "" && s.Host != "" {
+		return d.Err("host cannot be combined with ConnectionString")
+	}
+	if s.Env && (s.ConnectionString != "" || s.Host != "") {
+		return d.Err("env cannot be combined with ConnectionString or host")
+	}
+	if s.Connection == "" && s.ConnectionString == "" && s.Host == "" && s.ConnectionStringFile == "" && s.ConnectionStringAWS == "" && s.UnixSocket == "" && !s.Env {
 		return d.Err("missing ConnectionString token")
 	}
 	return nil
@@ -94,11 +1303,20 @@ func (s *CaddyStorage) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 
 // CertMagicStorage objects a Storage instance from a CaddyStorage instance
 func (s *CaddyStorage) CertMagicStorage() (certmagic.Storage, error) {
+	if s.events != nil {
+		return eventStorage{Storage: s.storage, ctx: s.ctx, events: s.events}, nil
+	}
 	return s.storage, nil
 }
 
+// Cleanup releases the storage, which is shut down, giving operations
+// in flight up to shutdownTimeout to finish, once no config uses it.
 func (s *CaddyStorage) Cleanup() error {
-	return s.storage.Close()
+	if s.poolKey == "" {
+		return nil
+	}
+	_, err := storagePool.Delete(s.poolKey)
+	return err
 }
 
 // Interface guards
@@ -0,0 +1,220 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"path"
+	"sync"
+	"time"
+)
+
+// CleanOptions selects what CleanStorage deletes.
+type CleanOptions struct {
+	// ExpiredCertGracePeriod is how long after expiring a certificate
+	// is deleted, along with its private key and metadata. Zero keeps
+	// expired certificates.
+	ExpiredCertGracePeriod time.Duration
+
+	// OCSPMaxAge is how long after being stored an OCSP staple is
+	// deleted. Zero keeps OCSP staples.
+	OCSPMaxAge time.Duration
+
+	// OrphanGracePeriod is how long after being stored a private key or
+	// metadata file without a certificate next to it is deleted. It
+	// should allow for the certificate being stored after its key while
+	// it is issued. Zero keeps orphaned keys.
+	OrphanGracePeriod time.Duration
+}
+
+// DefaultCleanOptions mirror the defaults of certmagic's storage
+// cleaning.
+var DefaultCleanOptions = CleanOptions{
+	ExpiredCertGracePeriod: time.Hour * 24 * 14,
+	OCSPMaxAge:             time.Hour * 24 * 14,
+	OrphanGracePeriod:      time.Hour * 24,
+}
+
+// CleanResult reports the number of keys CleanStorage deleted.
+type CleanResult struct {
+	ExpiredCerts int64
+	OCSPStaples  int64
+	Orphans      int64
+}
+
+// CleanStorage deletes expired certificates along with their keys and
+// metadata, stale OCSP staples, and private keys and metadata left
+// without a certificate, like certmagic's cleaning of file storage.
+// Apart from parsing the certificates to find their expiry, this is
+// done with a few statements, rather than walking every key.
+func (s Storage) CleanStorage(ctx context.Context, opts CleanOptions) (CleanResult, error) {
+	var result CleanResult
+
+	if opts.ExpiredCertGracePeriod > 0 {
+		// A lagging replica could still list a certificate that was
+		// renewed since, so find the expired ones on the primary
+		primary := s
+		primary.readDB = s.db
+		certificates, err := primary.Certificates(ctx)
+		if err != nil {
+			return result, err
+		}
+		expired := time.Now().Add(-opts.ExpiredCertGracePeriod)
+		for _, certificate := range certificates {
+			if !certificate.NotAfter.Before(expired) {
+				// Sorted by expiry, so the rest haven't expired either
+				break
+			}
+			deleted, err := s.DeleteAll(ctx, path.Dir(certificate.Key))
+			if err != nil {
+				return result, err
+			}
+			result.ExpiredCerts += deleted
+		}
+	}
+
+	if opts.OCSPMaxAge > 0 {
+		deleted, err := s.PruneModifiedBefore(ctx, "ocsp", time.Now().Add(-opts.OCSPMaxAge))
+		if err != nil {
+			return result, err
+		}
+		result.OCSPStaples = deleted
+	}
+
+	if opts.OrphanGracePeriod > 0 {
+		deleted, err := s.deleteOrphans(ctx, time.Now().Add(-opts.OrphanGracePeriod))
+		if err != nil {
+			return result, err
+		}
+		result.Orphans = deleted
+	}
+
+	return result, nil
+}
+
+// deleteOrphans deletes the keys below certificates/ modified before
+// the given time, in directories holding no certificate.
+func (s Storage) deleteOrphans(ctx context.Context, before time.Time) (int64, error) {
+	deleted, err := runWithResult(ctx, s, opWrite, func(ctx context.Context) (int64, error) {
+		query := fmt.Sprintf(s.tables(`DELETE FROM certmagic_data d WHERE d.directory LIKE $1 ESCAPE '\' AND d.modified < $2 AND d.tenant = $3 AND NOT EXISTS (SELECT 1 FROM certmagic_data c WHERE c.tenant = d.tenant AND c.directory = d.directory AND %s LIKE '%%.crt')`), s.keyColumn())
+		result, err := s.db.ExecContext(ctx, query, escapeLike(s.keyPrefix+certificatesPrefix)+"%/%", before, s.tenant)
+		if err != nil {
+			return 0, fmt.Errorf("failed exec: %w", err)
+		}
+
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to get affected rows: %w", err)
+		}
+		return deleted, nil
+	})
+	if err != nil || deleted == 0 {
+		return deleted, err
+	}
+	return deleted, s.recordAudit(ctx, AuditPrune, certificatesPrefix)
+}
+
+// cleanLockKey is the lock held while cleaning, so only one instance
+// of a cluster cleans at a time.
+const cleanLockKey = "certmagic_postgres_clean"
+
+// WithCleanInterval runs CleanStorage with opts every interval in the
+// background, on one instance of the cluster at a time, until the
+// storage is closed.
+func WithCleanInterval(interval time.Duration, opts CleanOptions) Option {
+	return func(storage Storage) (Storage, error) {
+		if interval <= 0 {
+			return storage, fmt.Errorf("invalid clean interval: %s", interval)
+		}
+		storage.cleanInterval = interval
+		storage.cleanOptions = opts
+		return storage, nil
+	}
+}
+
+// janitor stops the background cleaning, shared between copies of the
+// Storage.
+type janitor struct {
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func (j *janitor) close() {
+	j.stopOnce.Do(func() {
+		close(j.stop)
+	})
+}
+
+// startJanitor starts cleaning in the background if a clean interval
+// or an audit retention is configured. It is stopped by Close.
+func (s *Storage) startJanitor() {
+	if (s.cleanInterval == 0 && s.auditRetention == 0) || s.db == nil {
+		return
+	}
+	s.janitor = &janitor{stop: make(chan struct{})}
+	go s.runJanitor()
+}
+
+// janitorInterval returns how often the janitor runs: at the clean
+// interval if configured, else at auditPruneInterval.
+func (s Storage) janitorInterval() time.Duration {
+	if s.cleanInterval > 0 {
+		return s.cleanInterval
+	}
+	return auditPruneInterval
+}
+
+func (s Storage) runJanitor() {
+	ticker := time.NewTicker(s.janitorInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.janitor.stop:
+			return
+		case <-ticker.C:
+			s.clean()
+		}
+	}
+}
+
+// clean runs CleanStorage and prunes the audit log, as configured,
+// unless another instance is already cleaning.
+func (s Storage) clean() {
+	ctx := context.Background()
+	locked, err := s.TryLock(ctx, cleanLockKey)
+	if err != nil {
+		s.logger.Warn("failed to lock storage for cleaning", zap.Error(err))
+		return
+	}
+	if !locked {
+		return
+	}
+	defer func() {
+		if err := s.Unlock(ctx, cleanLockKey); err != nil {
+			s.logger.Warn("failed to unlock storage after cleaning", zap.Error(err))
+		}
+	}()
+
+	if s.cleanInterval > 0 {
+		s.cleanStorage(ctx)
+	}
+	if s.auditRetention > 0 {
+		s.pruneAudit(ctx)
+	}
+}
+
+// cleanStorage runs CleanStorage and logs the result.
+func (s Storage) cleanStorage(ctx context.Context) {
+	start := time.Now()
+	result, err := s.CleanStorage(ctx, s.cleanOptions)
+	if err != nil {
+		s.logger.Error("failed to clean storage", zap.Error(err))
+		return
+	}
+	s.logger.Info("cleaned storage",
+		zap.Int64("expired_certs", result.ExpiredCerts),
+		zap.Int64("ocsp_staples", result.OCSPStaples),
+		zap.Int64("orphans", result.Orphans),
+		zap.Duration("duration", time.Since(start)))
+}
@@ -0,0 +1,39 @@
+package certmagic_postgres
+
+import (
+	"cloud.google.com/go/cloudsqlconn"
+	"context"
+	"fmt"
+)
+
+// WithCloudSQL has Connect dial the Google Cloud SQL instance named by
+// its connection name, "project:region:instance", through the Cloud
+// SQL Go connector, which authorizes and encrypts connections using
+// Application Default Credentials, so no Cloud SQL Auth Proxy is
+// needed. The host and TLS settings of the connection string are
+// ignored. With iamAuthN, IAM database authentication is used instead
+// of a password, with the connection string's user being the IAM
+// user, or the service account without ".gserviceaccount.com".
+func WithCloudSQL(instance string, iamAuthN bool) Option {
+	return func(storage Storage) (Storage, error) {
+		if instance == "" {
+			return storage, fmt.Errorf("invalid Cloud SQL instance: must not be empty")
+		}
+		storage.cloudSQLInstance = instance
+		storage.cloudSQLIAMAuthN = iamAuthN
+		return storage, nil
+	}
+}
+
+// newCloudSQLDialer creates the dialer for the Cloud SQL instance.
+func (s Storage) newCloudSQLDialer() (*cloudsqlconn.Dialer, error) {
+	var options []cloudsqlconn.Option
+	if s.cloudSQLIAMAuthN {
+		options = append(options, cloudsqlconn.WithIAMAuthN())
+	}
+	dialer, err := cloudsqlconn.NewDialer(context.Background(), options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Cloud SQL dialer: %w", err)
+	}
+	return dialer, nil
+}
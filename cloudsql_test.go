@@ -0,0 +1,18 @@
+package certmagic_postgres
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestWithCloudSQL(t *testing.T) {
+	storage, err := Open(nil, WithCloudSQL("project:europe-west1:certmagic", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "project:europe-west1:certmagic", storage.cloudSQLInstance)
+	assert.True(t, storage.cloudSQLIAMAuthN)
+
+	_, err = Open(nil, WithCloudSQL("", false))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,94 @@
+package certmagic_postgres
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+)
+
+// KeyCodec maps keys to the values stored in the key column, e.g.
+// to keep very long keys within practical index sizes. Keys the codec
+// changes are stored along with the original key, which List and the
+// other listing operations return.
+type KeyCodec interface {
+	// EncodeKey returns the value stored in place of key. It must
+	// be deterministic, and should return key unchanged whenever
+	// possible so that the table remains readable.
+	EncodeKey(key string) string
+}
+
+// hashLongKeys is the KeyCodec returned by HashLongKeys.
+type hashLongKeys struct {
+	maxLength int
+}
+
+// HashLongKeys returns a KeyCodec replacing keys longer than
+// maxLength bytes with "sha256:" followed by the hex encoded
+// SHA-256 hash of the key. Shorter keys are stored unchanged.
+func HashLongKeys(maxLength int) KeyCodec {
+	return hashLongKeys{maxLength: maxLength}
+}
+
+func (c hashLongKeys) EncodeKey(key string) string {
+	if len(key) <= c.maxLength {
+		return key
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// WithKeyCodec stores data keys encoded by codec. The codec must not
+// be changed once keys have been stored with it, since keys stored
+// with a different encoding can no longer be found.
+func WithKeyCodec(codec KeyCodec) Option {
+	return func(storage Storage) (Storage, error) {
+		if codec == nil {
+			return storage, fmt.Errorf("invalid key codec: nil")
+		}
+		storage.keyCodec = codec
+		return storage, nil
+	}
+}
+
+// encodeKey returns the value stored in the key column for key,
+// after applying the key prefix.
+func (s Storage) encodeKey(key string) string {
+	key = s.keyPrefix + key
+	if s.keyCodec == nil {
+		return key
+	}
+	return s.keyCodec.EncodeKey(key)
+}
+
+// encodeKeys is encodeKey for several keys, also returning
+// the original key for each encoded one.
+func (s Storage) encodeKeys(keys []string) ([]string, map[string]string) {
+	encoded := make([]string, len(keys))
+	originals := make(map[string]string, len(keys))
+	for i, key := range keys {
+		encoded[i] = s.encodeKey(key)
+		originals[encoded[i]] = key
+	}
+	return encoded, originals
+}
+
+// originalKey returns the value stored in the original_key column
+// for key, which is NULL unless the key codec changes key.
+func (s Storage) originalKey(key string) sql.NullString {
+	encoded := s.encodeKey(key)
+	key = s.keyPrefix + key
+	if encoded == key {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: key, Valid: true}
+}
+
+// keyColumn returns the SQL expression for the original key
+// of a row, for use by queries matching or returning keys.
+func (s Storage) keyColumn() string {
+	if s.keyCodec == nil {
+		return "key"
+	}
+	return "COALESCE(original_key, key)"
+}
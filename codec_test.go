@@ -0,0 +1,42 @@
+package certmagic_postgres
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestHashLongKeys(t *testing.T) {
+	codec := HashLongKeys(16)
+	assert.Equal(t, "short", codec.EncodeKey("short"))
+
+	long := strings.Repeat("a", 17)
+	encoded := codec.EncodeKey(long)
+	assert.True(t, strings.HasPrefix(encoded, "sha256:"))
+	assert.Len(t, encoded, len("sha256:")+64)
+	assert.Equal(t, encoded, codec.EncodeKey(long))
+	assert.NotEqual(t, encoded, codec.EncodeKey(long+"a"))
+}
+
+func TestStorage_EncodeKey(t *testing.T) {
+	storage, err := Open(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("a", 17)
+	assert.Equal(t, long, storage.encodeKey(long))
+	assert.Equal(t, sql.NullString{}, storage.originalKey(long))
+	assert.Equal(t, "key", storage.keyColumn())
+
+	storage, err = Open(nil, WithKeyCodec(HashLongKeys(16)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "short", storage.encodeKey("short"))
+	assert.Equal(t, sql.NullString{}, storage.originalKey("short"))
+	assert.Equal(t, sql.NullString{String: long, Valid: true}, storage.originalKey(long))
+
+	_, err = Open(nil, WithKeyCodec(nil))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,73 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// defaultKeyCollation orders keys bytewise, independently of the
+// locale the database was created with.
+const defaultKeyCollation = "C"
+
+// MigrateKeyCollation makes Migrate give the key columns the named
+// collation instead of the bytewise "C" collation applied by the
+// migrations, if they don't have it already. List results are always
+// sorted bytewise, but the order of ListPage, and whether key prefix
+// ranges can be served from indexes, follow the collation.
+func MigrateKeyCollation(collation string) MigrateOption {
+	return func(options *migrateOptions) {
+		options.keyCollation = collation
+	}
+}
+
+// WithKeyCollation makes every call to Migrate, including the one
+// made by WithAutoMigrate, behave as if passed MigrateKeyCollation.
+func WithKeyCollation(collation string) Option {
+	return func(storage Storage) (Storage, error) {
+		if collation == "" {
+			return storage, fmt.Errorf("invalid key collation: must not be empty")
+		}
+		storage.keyCollation = collation
+		return storage, nil
+	}
+}
+
+// keyCollationSQL returns the statements giving the key columns the
+// named collation. The generated directory column depends on the key
+// columns, so it is dropped and added again along with its index.
+func keyCollationSQL(collation string) string {
+	quoted := quoteIdentifier(collation)
+
+	var b strings.Builder
+	b.WriteString("ALTER TABLE certmagic_data DROP COLUMN IF EXISTS directory;\n\n")
+	fmt.Fprintf(&b, "ALTER TABLE IF EXISTS certmagic_blobs\n  ALTER COLUMN key TYPE text COLLATE %s;\n\n", quoted)
+	fmt.Fprintf(&b, "ALTER TABLE certmagic_data\n  ALTER COLUMN key TYPE text COLLATE %[1]s,\n  ALTER COLUMN original_key TYPE text COLLATE %[1]s;\n\n", quoted)
+	fmt.Fprintf(&b, "ALTER TABLE certmagic_data\n  ADD COLUMN directory text COLLATE %s GENERATED ALWAYS AS (\n", quoted)
+	b.WriteString("    CASE WHEN strpos(COALESCE(original_key, key), '/') > 0\n")
+	b.WriteString("      THEN regexp_replace(COALESCE(original_key, key), '/[^/]*$', '')\n")
+	b.WriteString("      ELSE ''\n")
+	b.WriteString("    END\n")
+	b.WriteString("  ) STORED;\n\n")
+	b.WriteString("CREATE INDEX IF NOT EXISTS certmagic_data_directory_idx ON certmagic_data (tenant, directory text_pattern_ops);\n\n")
+	fmt.Fprintf(&b, "ALTER TABLE IF EXISTS certmagic_locks\n  ALTER COLUMN key TYPE text COLLATE %s;", quoted)
+	return b.String()
+}
+
+// applyKeyCollation gives the key columns the named collation
+// unless certmagic_data.key already has it.
+func applyKeyCollation(ctx context.Context, q querier, collation string) error {
+	var current sql.NullString
+	if err := q.QueryRowContext(ctx, `SELECT collation_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = 'certmagic_data' AND column_name = 'key'`).Scan(&current); err != nil {
+		return fmt.Errorf("failed scan: %w", err)
+	}
+	if current.String == collation {
+		return nil
+	}
+
+	if _, err := q.ExecContext(ctx, keyCollationSQL(collation)); err != nil {
+		return fmt.Errorf("failed to apply key collation %s: %w", collation, err)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package certmagic_postgres
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestKeyCollationSQL(t *testing.T) {
+	migration, err := migrations.ReadFile("db/20261017099000_key_collation.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(migration), keyCollationSQL(defaultKeyCollation))
+
+	assert.Contains(t, keyCollationSQL(`en-US-x-"icu"`), `COLLATE "en-US-x-""icu"""`)
+}
+
+func TestWithKeyCollation(t *testing.T) {
+	storage, err := Open(nil, WithKeyCollation("en-US-x-icu"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "en-US-x-icu", storage.keyCollation)
+
+	_, err = Open(nil, WithKeyCollation(""))
+	assert.NotNil(t, err)
+}
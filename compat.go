@@ -0,0 +1,54 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"github.com/caddyserver/certmagic"
+)
+
+// UnlockContext releases the lock for key.
+//
+// Deprecated: Unlock accepts a context now; use it instead.
+func (s Storage) UnlockContext(ctx context.Context, key string) error {
+	return s.Unlock(ctx, key)
+}
+
+// LegacyStorage exposes a Storage through the method signatures used
+// before certmagic passed a context to every storage operation, for
+// callers that haven't been migrated yet. Each call runs with a
+// background context, bounded only by the configured timeouts.
+type LegacyStorage struct {
+	Storage
+}
+
+// Legacy returns s wrapped with the pre-context method signatures.
+func (s Storage) Legacy() LegacyStorage {
+	return LegacyStorage{Storage: s}
+}
+
+func (s LegacyStorage) Unlock(key string) error {
+	return s.Storage.Unlock(context.Background(), key)
+}
+
+func (s LegacyStorage) Store(key string, value []byte) error {
+	return s.Storage.Store(context.Background(), key, value)
+}
+
+func (s LegacyStorage) Load(key string) ([]byte, error) {
+	return s.Storage.Load(context.Background(), key)
+}
+
+func (s LegacyStorage) Delete(key string) error {
+	return s.Storage.Delete(context.Background(), key)
+}
+
+func (s LegacyStorage) Exists(key string) bool {
+	return s.Storage.Exists(context.Background(), key)
+}
+
+func (s LegacyStorage) List(prefix string, recursive bool) ([]string, error) {
+	return s.Storage.List(context.Background(), prefix, recursive)
+}
+
+func (s LegacyStorage) Stat(key string) (certmagic.KeyInfo, error) {
+	return s.Storage.Stat(context.Background(), key)
+}
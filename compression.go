@@ -0,0 +1,100 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// valueTables are the tables whose value column is configured
+// by MigrateValueStorage and MigrateValueCompression.
+var valueTables = []string{"certmagic_data", "certmagic_blobs"}
+
+// MigrateValueStorage makes Migrate set the storage strategy of the
+// value columns to one of "plain", "main", "external" or "extended"
+// (the default). "external" stores large values out of line without
+// compressing them, which speeds up reading parts of them, while
+// "main" prefers compressing values within the row. Only values
+// written afterwards are affected.
+func MigrateValueStorage(strategy string) MigrateOption {
+	return func(options *migrateOptions) {
+		options.valueStorage = strategy
+	}
+}
+
+// MigrateValueCompression makes Migrate set the compression method of
+// the value columns to "pglz" or "lz4", the latter requiring
+// PostgreSQL 14 or later built with lz4 support. PEM encoded
+// certificates and keys compress well, and lz4 does so considerably
+// faster. Only values written afterwards are affected.
+func MigrateValueCompression(method string) MigrateOption {
+	return func(options *migrateOptions) {
+		options.valueCompression = method
+	}
+}
+
+// WithValueStorage makes every call to Migrate, including the one
+// made by WithAutoMigrate, behave as if passed MigrateValueStorage.
+func WithValueStorage(strategy string) Option {
+	return func(storage Storage) (Storage, error) {
+		if err := checkValueStorage(strategy); err != nil {
+			return storage, err
+		}
+		storage.valueStorage = strategy
+		return storage, nil
+	}
+}
+
+// WithValueCompression makes every call to Migrate, including the one
+// made by WithAutoMigrate, behave as if passed MigrateValueCompression.
+func WithValueCompression(method string) Option {
+	return func(storage Storage) (Storage, error) {
+		if err := checkValueCompression(method); err != nil {
+			return storage, err
+		}
+		storage.valueCompression = method
+		return storage, nil
+	}
+}
+
+func checkValueStorage(strategy string) error {
+	switch strings.ToLower(strategy) {
+	case "plain", "main", "external", "extended":
+		return nil
+	}
+	return fmt.Errorf("invalid value storage: %s", strategy)
+}
+
+func checkValueCompression(method string) error {
+	switch strings.ToLower(method) {
+	case "pglz", "lz4":
+		return nil
+	}
+	return fmt.Errorf("invalid value compression: %s", method)
+}
+
+// valueSettingsStatements returns the statements setting the storage
+// strategy and compression method of the value columns, where
+// requested by opts.
+func valueSettingsStatements(opts migrateOptions) []string {
+	var statements []string
+	for _, table := range valueTables {
+		if opts.valueStorage != "" {
+			statements = append(statements, fmt.Sprintf(`ALTER TABLE %s ALTER COLUMN value SET STORAGE %s`, table, strings.ToUpper(opts.valueStorage)))
+		}
+		if opts.valueCompression != "" {
+			statements = append(statements, fmt.Sprintf(`ALTER TABLE %s ALTER COLUMN value SET COMPRESSION %s`, table, strings.ToLower(opts.valueCompression)))
+		}
+	}
+	return statements
+}
+
+// applyValueSettings executes valueSettingsStatements.
+func applyValueSettings(ctx context.Context, q querier, opts migrateOptions) error {
+	for _, statement := range valueSettingsStatements(opts) {
+		if _, err := q.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("failed to apply value settings: %w", err)
+		}
+	}
+	return nil
+}
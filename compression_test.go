@@ -0,0 +1,20 @@
+package certmagic_postgres
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestWithValueStorage(t *testing.T) {
+	storage, err := Open(nil, WithValueStorage("external"), WithValueCompression("lz4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "external", storage.valueStorage)
+	assert.Equal(t, "lz4", storage.valueCompression)
+
+	_, err = Open(nil, WithValueStorage("compressed"))
+	assert.NotNil(t, err)
+	_, err = Open(nil, WithValueCompression("zstd"))
+	assert.NotNil(t, err)
+}
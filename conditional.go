@@ -0,0 +1,112 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+)
+
+// ErrNotModified is returned by LoadIfModifiedSince when the
+// value has not been modified since the given time.
+var ErrNotModified = errors.New("not modified")
+
+// ConflictError is returned by StoreIfUnmodified when the stored
+// value was modified (or created) since the expected time.
+type ConflictError struct {
+	Key      string
+	Expected time.Time
+}
+
+func (e *ConflictError) Error() string {
+	if e.Expected.IsZero() {
+		return fmt.Sprintf("key %s already exists", e.Key)
+	}
+	return fmt.Sprintf("key %s was modified since %s", e.Key, e.Expected.Format(time.RFC3339Nano))
+}
+
+// StoreIfUnmodified puts value at key only if the key's modified
+// time, as reported by Stat, still equals expectedModified. A zero
+// expectedModified requires that the key does not exist yet. If the
+// key was changed in the meantime, a *ConflictError is returned and
+// nothing is written.
+func (s Storage) StoreIfUnmodified(ctx context.Context, key string, value []byte, expectedModified time.Time) error {
+	if err := s.validateKey(key); err != nil {
+		return err
+	}
+
+	if err := s.validateValue(key, value); err != nil {
+		return err
+	}
+	value, err := s.encryptValue(key, value)
+	if err != nil {
+		return err
+	}
+
+	err = s.run(ctx, opWrite, func(ctx context.Context) error {
+		inline, external := s.inlineValue(value)
+		var query string
+		args := []interface{}{s.encodeKey(key), inline, s.tenant, external}
+		if expectedModified.IsZero() {
+			query = s.tables(`INSERT INTO certmagic_data (tenant, key, value, external, original_key) VALUES ($3, $1, $2, $4, $5) ON CONFLICT (tenant, key) DO NOTHING`)
+			args = append(args, s.originalKey(key))
+		} else {
+			query = s.tables(`UPDATE certmagic_data SET value = $2, external = $4, modified = CURRENT_TIMESTAMP WHERE key = $1 AND tenant = $3 AND modified = $5`)
+			args = append(args, expectedModified)
+		}
+
+		return s.withCommitMode(ctx, s.db, false, external, func(q querier) error {
+			result, err := q.ExecContext(ctx, query, args...)
+			if err != nil {
+				return fmt.Errorf("failed exec: %w", err)
+			}
+
+			affected, err := result.RowsAffected()
+			if err != nil {
+				return fmt.Errorf("failed to get affected rows: %w", err)
+			}
+			if affected == 0 {
+				return &ConflictError{Key: key, Expected: expectedModified}
+			}
+
+			return s.storeBlob(ctx, q, s.encodeKey(key), value, external)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return s.recordAudit(ctx, AuditStore, key)
+}
+
+// LoadIfModifiedSince retrieves the value at key only if it was
+// modified after since, returning ErrNotModified otherwise, so that
+// unchanged values aren't transferred again. An error wrapping
+// fs.ErrNotExist is returned if the key does not exist. Like Load, it
+// reads from the primary even with a read replica.
+func (s Storage) LoadIfModifiedSince(ctx context.Context, key string, since time.Time) ([]byte, error) {
+	if err := s.validateKey(key); err != nil {
+		return nil, err
+	}
+
+	return runWithResult(ctx, s, opRead, func(ctx context.Context) ([]byte, error) {
+		// Only return the value if it has changed, to avoid transferring it otherwise
+		var value []byte
+		var modified bool
+		query := s.tables(fmt.Sprintf(`SELECT CASE WHEN modified > $2 THEN %s END, modified > $2 FROM certmagic_data WHERE key = $1 AND tenant = $3`, valueColumn))
+		row := s.db.QueryRowContext(ctx, query, s.encodeKey(key), since, s.tenant)
+		err := row.Scan(&value, &modified)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("key not found: %s: %w", key, fs.ErrNotExist)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to query row: %w", err)
+		}
+		if !modified {
+			return nil, ErrNotModified
+		}
+
+		return s.decryptValue(key, value)
+	})
+}
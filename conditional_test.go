@@ -0,0 +1,69 @@
+package certmagic_postgres_test
+
+import (
+	"context"
+	"errors"
+	"github.com/fluidgalleries/certmagic-postgres"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+func TestStorage_StoreIfUnmodified(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.StoreIfUnmodified(context.Background(), "abc", []byte("first"), time.Time{})
+	require.Nil(t, err)
+
+	err = storage.StoreIfUnmodified(context.Background(), "abc", []byte("again"), time.Time{})
+	var conflict *certmagic_postgres.ConflictError
+	require.True(t, errors.As(err, &conflict))
+	assert.Equal(t, "abc", conflict.Key)
+
+	info, err := storage.Stat(context.Background(), "abc")
+	require.Nil(t, err)
+
+	err = storage.StoreIfUnmodified(context.Background(), "abc", []byte("second"), info.Modified)
+	require.Nil(t, err)
+
+	// The first writer lost the race, as the key was modified since it read it
+	err = storage.StoreIfUnmodified(context.Background(), "abc", []byte("lost"), info.Modified)
+	assert.True(t, errors.As(err, &conflict))
+
+	value, err := storage.Load(context.Background(), "abc")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("second"), value)
+}
+
+func TestStorage_LoadIfModifiedSince(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.Store(context.Background(), "abc", []byte("value"))
+	require.Nil(t, err)
+	info, err := storage.Stat(context.Background(), "abc")
+	require.Nil(t, err)
+
+	value, err := storage.LoadIfModifiedSince(context.Background(), "abc", info.Modified.Add(-time.Second))
+	require.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+
+	_, err = storage.LoadIfModifiedSince(context.Background(), "abc", info.Modified)
+	assert.True(t, errors.Is(err, certmagic_postgres.ErrNotModified))
+
+	_, err = storage.LoadIfModifiedSince(context.Background(), "xyz", info.Modified)
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+}
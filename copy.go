@@ -0,0 +1,151 @@
+package certmagic_postgres
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+)
+
+// Copy copies the value at src to dst on the server, overwriting
+// any value at dst. Encrypted values are decrypted and encrypted
+// again for dst. An error wrapping fs.ErrNotExist is returned if
+// src does not exist.
+func (s Storage) Copy(ctx context.Context, src, dst string) error {
+	if err := s.validateKeys(src, dst); err != nil {
+		return err
+	}
+
+	err := s.run(ctx, opWrite, func(ctx context.Context) error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		result, err := tx.ExecContext(ctx, s.tables(`INSERT INTO certmagic_data (tenant, key, value, external, original_key) SELECT tenant, $2, value, external, $3 FROM certmagic_data WHERE key = $1 AND tenant = $4 ON CONFLICT (tenant, key) DO UPDATE SET value = EXCLUDED.value, external = EXCLUDED.external, modified = CURRENT_TIMESTAMP`), s.encodeKey(src), s.encodeKey(dst), s.originalKey(dst), s.tenant)
+		if err != nil {
+			return fmt.Errorf("failed exec: %w", err)
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get affected rows: %w", err)
+		}
+		if affected == 0 {
+			return fmt.Errorf("key not found: %s: %w", src, fs.ErrNotExist)
+		}
+
+		if err := s.copyBlob(ctx, tx, src, dst); err != nil {
+			return err
+		}
+		if err := s.reencryptValue(ctx, tx, src, dst); err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	})
+	if err != nil {
+		return err
+	}
+	return s.recordAudit(ctx, AuditStore, dst)
+}
+
+// Move renames src to dst in a single transaction, overwriting any
+// value at dst and keeping the modified and created times of src. An error
+// wrapping fs.ErrNotExist is returned if src does not exist.
+func (s Storage) Move(ctx context.Context, src, dst string) error {
+	if err := s.validateKeys(src, dst); err != nil {
+		return err
+	}
+
+	if src == dst {
+		if !s.Exists(ctx, src) {
+			return fmt.Errorf("key not found: %s: %w", src, fs.ErrNotExist)
+		}
+		return nil
+	}
+
+	err := s.run(ctx, opWrite, func(ctx context.Context) error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		result, err := tx.ExecContext(ctx, s.tables(`INSERT INTO certmagic_data (tenant, key, value, external, modified, created_at, original_key) SELECT tenant, $2, value, external, modified, created_at, $3 FROM certmagic_data WHERE key = $1 AND tenant = $4 ON CONFLICT (tenant, key) DO UPDATE SET value = EXCLUDED.value, external = EXCLUDED.external, modified = EXCLUDED.modified, created_at = EXCLUDED.created_at`), s.encodeKey(src), s.encodeKey(dst), s.originalKey(dst), s.tenant)
+		if err != nil {
+			return fmt.Errorf("failed exec: %w", err)
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get affected rows: %w", err)
+		}
+		if affected == 0 {
+			return fmt.Errorf("key not found: %s: %w", src, fs.ErrNotExist)
+		}
+
+		if err := s.copyBlob(ctx, tx, src, dst); err != nil {
+			return err
+		}
+		if err := s.reencryptValue(ctx, tx, src, dst); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, s.tables(`DELETE FROM certmagic_data WHERE key = $1 AND tenant = $2`), s.encodeKey(src), s.tenant); err != nil {
+			return fmt.Errorf("failed exec: %w", err)
+		}
+
+		return tx.Commit()
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.recordAudit(ctx, AuditStore, dst); err != nil {
+		return err
+	}
+	return s.recordAudit(ctx, AuditDelete, src)
+}
+
+// copyBlob copies the value stored out of line for src, if any, to dst.
+// The row at dst must already have been written.
+func (s Storage) copyBlob(ctx context.Context, tx *sql.Tx, src, dst string) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO certmagic_blobs (tenant, key, value) SELECT tenant, $2, value FROM certmagic_blobs WHERE key = $1 AND tenant = $3 ON CONFLICT (tenant, key) DO UPDATE SET value = EXCLUDED.value`, s.encodeKey(src), s.encodeKey(dst), s.tenant)
+	if err != nil {
+		return fmt.Errorf("failed to copy value stored out of line: %w", err)
+	}
+	return nil
+}
+
+// reencryptValue encrypts the value just copied from src to dst again
+// for dst, as encrypted values are bound to their key. Values stored
+// unencrypted are left as they are.
+func (s Storage) reencryptValue(ctx context.Context, tx *sql.Tx, src, dst string) error {
+	if s.aead == nil {
+		return nil
+	}
+
+	var value []byte
+	query := s.tables(fmt.Sprintf(`SELECT %s FROM certmagic_data WHERE key = $1 AND tenant = $2`, valueColumn))
+	if err := tx.QueryRowContext(ctx, query, s.encodeKey(dst), s.tenant).Scan(&value); err != nil {
+		return fmt.Errorf("failed to query row: %w", err)
+	}
+	if !bytes.HasPrefix(value, encryptedPrefix) {
+		return nil
+	}
+	value, err := s.decryptValue(src, value)
+	if err != nil {
+		return err
+	}
+	if value, err = s.encryptValue(dst, value); err != nil {
+		return err
+	}
+
+	inline, external := s.inlineValue(value)
+	if _, err := tx.ExecContext(ctx, s.tables(`UPDATE certmagic_data SET value = $3, external = $4 WHERE key = $1 AND tenant = $2`), s.encodeKey(dst), s.tenant, inline, external); err != nil {
+		return fmt.Errorf("failed exec: %w", err)
+	}
+	return s.storeBlob(ctx, tx, s.encodeKey(dst), value, external)
+}
@@ -0,0 +1,64 @@
+package certmagic_postgres_test
+
+import (
+	"context"
+	"errors"
+	"github.com/fluidgalleries/certmagic-postgres"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/fs"
+	"testing"
+)
+
+func TestStorage_Copy(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = storage.Store(context.Background(), "abc", []byte("value"))
+	_ = storage.Store(context.Background(), "xyz", []byte("old"))
+
+	err = storage.Copy(context.Background(), "abc", "xyz")
+	require.Nil(t, err)
+
+	value, err := storage.Load(context.Background(), "xyz")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+	assert.True(t, storage.Exists(context.Background(), "abc"))
+
+	err = storage.Copy(context.Background(), "missing", "xyz")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+}
+
+func TestStorage_Move(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = storage.Store(context.Background(), "abc", []byte("value"))
+	before, err := storage.Stat(context.Background(), "abc")
+	require.Nil(t, err)
+
+	err = storage.Move(context.Background(), "abc", "xyz")
+	require.Nil(t, err)
+
+	value, err := storage.Load(context.Background(), "xyz")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+	assert.False(t, storage.Exists(context.Background(), "abc"))
+
+	after, err := storage.Stat(context.Background(), "xyz")
+	require.Nil(t, err)
+	assert.True(t, before.Modified.Equal(after.Modified))
+
+	err = storage.Move(context.Background(), "abc", "xyz")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+}
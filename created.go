@@ -0,0 +1,36 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ListCreatedBefore returns the terminal keys below the directory
+// named by prefix that were first stored before the given time, in
+// key order. Unlike the modified time, the creation time is kept when
+// a key is overwritten, so this finds keys by age for retention
+// policies and reports.
+func (s Storage) ListCreatedBefore(ctx context.Context, prefix string, before time.Time) ([]string, error) {
+	return runWithResult(ctx, s, opList, func(ctx context.Context) ([]string, error) {
+		query := fmt.Sprintf(s.tables(`SELECT %[1]s FROM certmagic_data WHERE %[1]s LIKE $1 ESCAPE '\' AND created_at < $2 AND tenant = $3 ORDER BY %[1]s`), s.keyColumn())
+		rows, err := s.db.QueryContext(ctx, query, escapeLike(s.keyPrefix+directoryPrefix(prefix))+"%", before, s.tenant)
+		if err != nil {
+			return nil, fmt.Errorf("failed query: %w", err)
+		}
+		defer rows.Close()
+
+		var keys []string
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				return nil, fmt.Errorf("failed scan: %w", err)
+			}
+			keys = append(keys, s.trimKeyPrefix(key))
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed iterating rows: %w", err)
+		}
+		return keys, nil
+	})
+}
@@ -0,0 +1,60 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"go.uber.org/zap"
+)
+
+// CredentialProvider supplies the credentials for new connections,
+// so they can be rotated without restarting.
+type CredentialProvider interface {
+	// Credentials returns the user and password to authenticate a
+	// new connection with. An empty user keeps the user of the
+	// connection string.
+	Credentials(ctx context.Context) (user, password string, err error)
+
+	// Invalidate is called when the database rejected the last
+	// credentials returned, so the next call to Credentials returns
+	// fresh ones rather than cached ones.
+	Invalidate()
+}
+
+// WithCredentialProvider has Connect authenticate every new
+// connection with the credentials returned by provider, in place of
+// those in the connection string and any password func. When the
+// database rejects them, the provider is invalidated and the
+// operation is retried once with fresh credentials.
+func WithCredentialProvider(provider CredentialProvider) Option {
+	return func(storage Storage) (Storage, error) {
+		if provider == nil {
+			return storage, fmt.Errorf("invalid credential provider: must not be nil")
+		}
+		storage.credentialProvider = provider
+		return storage, nil
+	}
+}
+
+// isAuthError reports whether err is the database rejecting the
+// credentials of a new connection.
+func isAuthError(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == "28P01" || pgErr.Code == "28000" // invalid password, invalid authorization
+}
+
+// withReauthentication calls fn, and if it failed because the
+// credentials were rejected, invalidates them and calls it again.
+func (s Storage) withReauthentication(fn func() error) error {
+	err := fn()
+	if s.credentialProvider == nil || !isAuthError(err) {
+		return err
+	}
+	s.logger.Info("authentication failed, refreshing credentials", zap.Error(err))
+	s.credentialProvider.Invalidate()
+	return fn()
+}
@@ -0,0 +1,55 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type fakeCredentialProvider struct {
+	invalidated int
+}
+
+func (p *fakeCredentialProvider) Credentials(context.Context) (string, string, error) {
+	return "caddy", fmt.Sprintf("password-%d", p.invalidated), nil
+}
+
+func (p *fakeCredentialProvider) Invalidate() {
+	p.invalidated++
+}
+
+func TestStorage_WithReauthentication(t *testing.T) {
+	provider := &fakeCredentialProvider{}
+	storage, err := Open(nil, WithCredentialProvider(provider))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	err = storage.withReauthentication(func() error {
+		calls++
+		if calls == 1 {
+			return fmt.Errorf("failed to connect: %w", &pgconn.PgError{Code: "28P01"})
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, 1, provider.invalidated)
+
+	calls = 0
+	err = storage.withReauthentication(func() error {
+		calls++
+		return &pgconn.PgError{Code: "23505"}
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, 1, provider.invalidated)
+}
+
+func TestWithCredentialProvider(t *testing.T) {
+	_, err := Open(nil, WithCredentialProvider(nil))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,3 @@
+ALTER TABLE IF EXISTS certmagic_locks
+  DROP COLUMN IF EXISTS holder,
+  DROP COLUMN IF EXISTS acquired;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE certmagic_locks
+  ADD COLUMN IF NOT EXISTS holder text NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS acquired timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP;
\ No newline at end of file
@@ -0,0 +1,4 @@
+ALTER TABLE IF EXISTS certmagic_locks
+  DROP COLUMN IF EXISTS fence;
+
+DROP SEQUENCE IF EXISTS certmagic_lock_fence_seq;
\ No newline at end of file
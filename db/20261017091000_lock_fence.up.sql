@@ -0,0 +1,4 @@
+CREATE SEQUENCE IF NOT EXISTS certmagic_lock_fence_seq;
+
+ALTER TABLE certmagic_locks
+  ADD COLUMN IF NOT EXISTS fence bigint NOT NULL DEFAULT 0;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE IF EXISTS certmagic_data
+  DROP COLUMN IF EXISTS created_at;
\ No newline at end of file
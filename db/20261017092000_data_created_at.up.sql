@@ -0,0 +1,8 @@
+ALTER TABLE certmagic_data
+  ADD COLUMN IF NOT EXISTS created_at timestamptz;
+
+UPDATE certmagic_data SET created_at = modified WHERE created_at IS NULL;
+
+ALTER TABLE certmagic_data
+  ALTER COLUMN created_at SET DEFAULT CURRENT_TIMESTAMP,
+  ALTER COLUMN created_at SET NOT NULL;
\ No newline at end of file
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS certmagic_data_original_key_idx;
+
+ALTER TABLE IF EXISTS certmagic_data
+  DROP COLUMN IF EXISTS original_key;
\ No newline at end of file
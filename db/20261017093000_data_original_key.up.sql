@@ -0,0 +1,4 @@
+ALTER TABLE certmagic_data
+  ADD COLUMN IF NOT EXISTS original_key text;
+
+CREATE INDEX IF NOT EXISTS certmagic_data_original_key_idx ON certmagic_data (original_key) WHERE original_key IS NOT NULL;
\ No newline at end of file
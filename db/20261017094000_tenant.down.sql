@@ -0,0 +1,20 @@
+DO $$
+BEGIN
+  IF to_regclass('certmagic_data') IS NOT NULL AND EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = 'certmagic_data' AND column_name = 'tenant') THEN
+    DELETE FROM certmagic_data WHERE tenant <> '';
+  END IF;
+  IF to_regclass('certmagic_locks') IS NOT NULL AND EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = 'certmagic_locks' AND column_name = 'tenant') THEN
+    DELETE FROM certmagic_locks WHERE tenant <> '';
+  END IF;
+END
+$$;
+
+ALTER TABLE IF EXISTS certmagic_data
+  DROP CONSTRAINT IF EXISTS certmagic_data_pkey,
+  DROP COLUMN IF EXISTS tenant,
+  ADD PRIMARY KEY (key);
+
+ALTER TABLE IF EXISTS certmagic_locks
+  DROP CONSTRAINT IF EXISTS certmagic_locks_pkey,
+  DROP COLUMN IF EXISTS tenant,
+  ADD PRIMARY KEY (key);
\ No newline at end of file
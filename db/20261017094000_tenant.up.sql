@@ -0,0 +1,16 @@
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = 'certmagic_data' AND column_name = 'tenant') THEN
+    ALTER TABLE certmagic_data
+      ADD COLUMN tenant text NOT NULL DEFAULT '',
+      DROP CONSTRAINT IF EXISTS certmagic_data_pkey,
+      ADD PRIMARY KEY (tenant, key);
+  END IF;
+  IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = 'certmagic_locks' AND column_name = 'tenant') THEN
+    ALTER TABLE certmagic_locks
+      ADD COLUMN tenant text NOT NULL DEFAULT '',
+      DROP CONSTRAINT IF EXISTS certmagic_locks_pkey,
+      ADD PRIMARY KEY (tenant, key);
+  END IF;
+END
+$$;
\ No newline at end of file
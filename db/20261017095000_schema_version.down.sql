@@ -0,0 +1 @@
+DROP TABLE IF EXISTS certmagic_schema_version;
\ No newline at end of file
@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS certmagic_schema_version (
+  version text PRIMARY KEY,
+  applied_at timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
\ No newline at end of file
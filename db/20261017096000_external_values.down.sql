@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS certmagic_blobs;
+
+ALTER TABLE IF EXISTS certmagic_data
+  DROP COLUMN IF EXISTS external;
\ No newline at end of file
@@ -0,0 +1,10 @@
+ALTER TABLE certmagic_data
+  ADD COLUMN IF NOT EXISTS external boolean NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS certmagic_blobs (
+  tenant text NOT NULL DEFAULT '',
+  key text NOT NULL,
+  value bytea NOT NULL,
+  PRIMARY KEY (tenant, key),
+  CONSTRAINT certmagic_blobs_data_fkey FOREIGN KEY (tenant, key) REFERENCES certmagic_data (tenant, key) ON DELETE CASCADE
+);
\ No newline at end of file
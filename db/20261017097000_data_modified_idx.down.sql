@@ -0,0 +1 @@
+DROP INDEX IF EXISTS certmagic_data_modified_idx;
\ No newline at end of file
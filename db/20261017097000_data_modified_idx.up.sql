@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS certmagic_data_modified_idx ON certmagic_data (tenant, modified);
\ No newline at end of file
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS certmagic_data_directory_idx;
+
+ALTER TABLE IF EXISTS certmagic_data
+  DROP COLUMN IF EXISTS directory;
\ No newline at end of file
@@ -0,0 +1,27 @@
+DO $$
+BEGIN
+  IF to_regclass('certmagic_data') IS NOT NULL THEN
+    ALTER TABLE certmagic_data DROP COLUMN IF EXISTS directory;
+
+    ALTER TABLE IF EXISTS certmagic_blobs
+      ALTER COLUMN key TYPE text COLLATE "default";
+
+    ALTER TABLE certmagic_data
+      ALTER COLUMN key TYPE text COLLATE "default",
+      ALTER COLUMN original_key TYPE text COLLATE "default";
+
+    ALTER TABLE certmagic_data
+      ADD COLUMN directory text COLLATE "default" GENERATED ALWAYS AS (
+        CASE WHEN strpos(COALESCE(original_key, key), '/') > 0
+          THEN regexp_replace(COALESCE(original_key, key), '/[^/]*$', '')
+          ELSE ''
+        END
+      ) STORED;
+
+    CREATE INDEX IF NOT EXISTS certmagic_data_directory_idx ON certmagic_data (tenant, directory text_pattern_ops);
+
+    ALTER TABLE IF EXISTS certmagic_locks
+      ALTER COLUMN key TYPE text COLLATE "default";
+  END IF;
+END
+$$;
\ No newline at end of file
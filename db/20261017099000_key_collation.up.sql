@@ -0,0 +1,21 @@
+ALTER TABLE certmagic_data DROP COLUMN IF EXISTS directory;
+
+ALTER TABLE IF EXISTS certmagic_blobs
+  ALTER COLUMN key TYPE text COLLATE "C";
+
+ALTER TABLE certmagic_data
+  ALTER COLUMN key TYPE text COLLATE "C",
+  ALTER COLUMN original_key TYPE text COLLATE "C";
+
+ALTER TABLE certmagic_data
+  ADD COLUMN directory text COLLATE "C" GENERATED ALWAYS AS (
+    CASE WHEN strpos(COALESCE(original_key, key), '/') > 0
+      THEN regexp_replace(COALESCE(original_key, key), '/[^/]*$', '')
+      ELSE ''
+    END
+  ) STORED;
+
+CREATE INDEX IF NOT EXISTS certmagic_data_directory_idx ON certmagic_data (tenant, directory text_pattern_ops);
+
+ALTER TABLE IF EXISTS certmagic_locks
+  ALTER COLUMN key TYPE text COLLATE "C";
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS certmagic_audit;
\ No newline at end of file
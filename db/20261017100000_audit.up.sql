@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS certmagic_audit (
+  id bigserial PRIMARY KEY,
+  tenant text NOT NULL DEFAULT '',
+  key text NOT NULL,
+  operation text NOT NULL,
+  instance_id text NOT NULL,
+  recorded_at timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS certmagic_audit_recorded_at_idx ON certmagic_audit (recorded_at);
+
+CREATE INDEX IF NOT EXISTS certmagic_audit_key_idx ON certmagic_audit (tenant, key);
\ No newline at end of file
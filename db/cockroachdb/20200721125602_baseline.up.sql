@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS certmagic_locks (
+   key STRING PRIMARY KEY,
+   expires TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS certmagic_data (
+  key STRING PRIMARY KEY,
+  value BYTES NOT NULL,
+  modified TIMESTAMPTZ NOT NULL DEFAULT now()
+);
\ No newline at end of file
@@ -0,0 +1,11 @@
+DELETE FROM certmagic_data WHERE tenant <> '';
+
+ALTER TABLE certmagic_data DROP CONSTRAINT certmagic_data_pkey, ADD CONSTRAINT certmagic_data_pkey PRIMARY KEY (key);
+
+ALTER TABLE certmagic_data DROP COLUMN IF EXISTS tenant;
+
+DELETE FROM certmagic_locks WHERE tenant <> '';
+
+ALTER TABLE certmagic_locks DROP CONSTRAINT certmagic_locks_pkey, ADD CONSTRAINT certmagic_locks_pkey PRIMARY KEY (key);
+
+ALTER TABLE certmagic_locks DROP COLUMN IF EXISTS tenant;
\ No newline at end of file
@@ -0,0 +1,7 @@
+ALTER TABLE certmagic_data ADD COLUMN IF NOT EXISTS tenant STRING NOT NULL DEFAULT '';
+
+ALTER TABLE certmagic_data DROP CONSTRAINT certmagic_data_pkey, ADD CONSTRAINT certmagic_data_pkey PRIMARY KEY (tenant, key);
+
+ALTER TABLE certmagic_locks ADD COLUMN IF NOT EXISTS tenant STRING NOT NULL DEFAULT '';
+
+ALTER TABLE certmagic_locks DROP CONSTRAINT certmagic_locks_pkey, ADD CONSTRAINT certmagic_locks_pkey PRIMARY KEY (tenant, key);
\ No newline at end of file
@@ -0,0 +1,9 @@
+ALTER TABLE certmagic_data
+  ADD COLUMN IF NOT EXISTS directory STRING GENERATED ALWAYS AS (
+    CASE WHEN strpos(COALESCE(original_key, key), '/') > 0
+      THEN regexp_replace(COALESCE(original_key, key), '/[^/]*$', '')
+      ELSE ''
+    END
+  ) STORED;
+
+CREATE INDEX IF NOT EXISTS certmagic_data_directory_idx ON certmagic_data (tenant, directory);
\ No newline at end of file
@@ -0,0 +1,2 @@
+-- CockroachDB compares STRING keys bytewise already
+SELECT 1;
\ No newline at end of file
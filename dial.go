@@ -0,0 +1,26 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// DialFunc opens a network connection to the database at addr,
+// like net.Dialer.DialContext.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// WithDialFunc has Connect open every connection with fn instead of
+// dialing the database directly, such as through an SSH bastion, a
+// SOCKS proxy or a service mesh. Host names are passed to fn as they
+// are rather than resolved locally. TLS, if enabled, is negotiated
+// over the returned connection.
+func WithDialFunc(fn DialFunc) Option {
+	return func(storage Storage) (Storage, error) {
+		if fn == nil {
+			return storage, fmt.Errorf("invalid dial func: must not be nil")
+		}
+		storage.dialFunc = fn
+		return storage, nil
+	}
+}
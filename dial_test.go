@@ -0,0 +1,24 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestConnect_DialFunc(t *testing.T) {
+	var dialed string
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = addr
+		return nil, errors.New("tunnel down")
+	}
+
+	_, err := Connect("postgres://db.internal:5433/certmagic?sslmode=disable", WithDialFunc(dial))
+	assert.ErrorContains(t, err, "tunnel down")
+	assert.Equal(t, "db.internal:5433", dialed)
+
+	_, err = Open(nil, WithDialFunc(nil))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,90 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// DialectPostgres is PostgreSQL itself.
+	DialectPostgres = "postgres"
+
+	// DialectCockroachDB is CockroachDB, which runs every transaction
+	// as SERIALIZABLE and frequently asks clients to retry them.
+	DialectCockroachDB = "cockroachdb"
+
+	// DialectYugabyteDB is YugabyteDB's PostgreSQL compatible YSQL API,
+	// which lacks advisory locks and transactional schema changes.
+	DialectYugabyteDB = "yugabytedb"
+)
+
+// cockroachRetryPolicy is the minimum retry policy applied with
+// DialectCockroachDB and DialectYugabyteDB, where serialization
+// failures are routine.
+var cockroachRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: time.Millisecond * 50,
+	MaxBackoff:     time.Second * 1,
+}
+
+// WithDialect sets the database the storage talks to. Connect
+// detects the dialect when not set, while Open assumes
+// DialectPostgres.
+func WithDialect(dialect string) Option {
+	return func(storage Storage) (Storage, error) {
+		switch dialect {
+		case DialectPostgres, DialectCockroachDB, DialectYugabyteDB:
+			storage.dialect = dialect
+			return storage, nil
+		default:
+			return storage, fmt.Errorf("invalid dialect: %s", dialect)
+		}
+	}
+}
+
+// detectDialect determines the dialect of the database behind db.
+func detectDialect(ctx context.Context, db *sql.DB) (string, error) {
+	var version string
+	if err := db.QueryRowContext(ctx, `SELECT version()`).Scan(&version); err != nil {
+		return "", fmt.Errorf("failed to query database version: %w", err)
+	}
+	if strings.Contains(version, "CockroachDB") {
+		return DialectCockroachDB, nil
+	}
+	if strings.Contains(version, "-YB-") {
+		return DialectYugabyteDB, nil
+	}
+	return DialectPostgres, nil
+}
+
+// checkDialect rejects options the dialect doesn't support. Row locks
+// hold transactions open for as long as a lock is held, which
+// distributed databases handle poorly.
+func (s Storage) checkDialect() error {
+	if s.isDistributed() && s.lockStrategy == LockStrategyRow {
+		return fmt.Errorf("lock strategy %s is not supported on %s", LockStrategyRow, s.dialect)
+	}
+	return nil
+}
+
+// isDistributed reports whether the dialect is a distributed
+// database rather than PostgreSQL itself.
+func (s Storage) isDistributed() bool {
+	return s.dialect == DialectCockroachDB || s.dialect == DialectYugabyteDB
+}
+
+// effectiveRetryPolicy returns the retry policy for operations,
+// making sure retryable errors are retried on distributed databases
+// and across failovers.
+func (s Storage) effectiveRetryPolicy() RetryPolicy {
+	if s.isDistributed() && s.retryPolicy.MaxAttempts < cockroachRetryPolicy.MaxAttempts {
+		return cockroachRetryPolicy
+	}
+	if s.failover != nil && s.retryPolicy.MaxAttempts < failoverRetryPolicy.MaxAttempts {
+		return failoverRetryPolicy
+	}
+	return s.retryPolicy
+}
@@ -0,0 +1,47 @@
+package certmagic_postgres
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestStorage_EffectiveRetryPolicy(t *testing.T) {
+	postgres, err := Open(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, RetryPolicy{}, postgres.effectiveRetryPolicy())
+
+	cockroach, err := Open(nil, WithDialect(DialectCockroachDB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, cockroachRetryPolicy, cockroach.effectiveRetryPolicy())
+
+	patient := RetryPolicy{MaxAttempts: 10}
+	cockroach, err = Open(nil, WithDialect(DialectCockroachDB), WithRetryPolicy(patient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, patient, cockroach.effectiveRetryPolicy())
+
+	yugabyte, err := Open(nil, WithDialect(DialectYugabyteDB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, cockroachRetryPolicy, yugabyte.effectiveRetryPolicy())
+
+	_, err = Open(nil, WithDialect("mysql"))
+	assert.NotNil(t, err)
+}
+
+func TestStorage_CheckDialect(t *testing.T) {
+	_, err := Open(nil, WithDialect(DialectCockroachDB), WithLockStrategy(LockStrategyRow))
+	assert.NotNil(t, err)
+
+	_, err = Open(nil, WithDialect(DialectYugabyteDB), WithLockStrategy(LockStrategyRow))
+	assert.NotNil(t, err)
+
+	_, err = Open(nil, WithDialect(DialectPostgres), WithLockStrategy(LockStrategyRow))
+	assert.Nil(t, err)
+}
@@ -0,0 +1,81 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	// DriverStdlib connects through pgx's database/sql driver, which
+	// pools connections in database/sql.
+	DriverStdlib = "stdlib"
+
+	// DriverPgxpool pools connections in a pgxpool.Pool instead.
+	// Queries still go through database/sql, which takes connections
+	// from the pgxpool.Pool for the duration of an operation rather
+	// than keeping idle ones, so that the pool's limits and health
+	// checks apply.
+	DriverPgxpool = "pgxpool"
+)
+
+// WithDriver selects how Connect connects to the database:
+// DriverStdlib (the default) or DriverPgxpool. With DriverPgxpool,
+// the maximum open connections, connection lifetime and idle time
+// apply to the pgxpool, whose pool_* connection string parameters
+// are also honoured, and the maximum idle connections doesn't apply.
+func WithDriver(driver string) Option {
+	return func(storage Storage) (Storage, error) {
+		switch driver {
+		case DriverStdlib, DriverPgxpool:
+			storage.driver = driver
+			return storage, nil
+		default:
+			return storage, fmt.Errorf("invalid driver: %s", driver)
+		}
+	}
+}
+
+// openPoolDB is openDB for DriverPgxpool, returning a database/sql
+// database taking its connections from a pgxpool.Pool it owns.
+func (s Storage) openPoolDB(connectionString string) (*sql.DB, error) {
+	config, err := pgxpool.ParseConfig(connectionString)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.configureConn(config.ConnConfig); err != nil {
+		return nil, err
+	}
+	// Connect only on first use, as database/sql does, so that startup
+	// retries and lazy connecting behave the same with either driver
+	config.LazyConnect = true
+
+	if s.failover != nil {
+		afterConnect, resetSession := s.failover.configure(config.ConnConfig)
+		config.AfterConnect = afterConnect
+		config.BeforeAcquire = func(ctx context.Context, conn *pgx.Conn) bool {
+			return resetSession(ctx, conn) == nil
+		}
+	}
+	config.BeforeConnect = s.beforeConnect()
+	if s.maxOpenConns > 0 {
+		config.MaxConns = int32(s.maxOpenConns)
+	}
+	if s.connMaxLifetime > 0 {
+		config.MaxConnLifetime = s.connMaxLifetime
+	}
+	if s.connMaxIdleTime > 0 {
+		config.MaxConnIdleTime = s.connMaxIdleTime
+	}
+
+	pool, err := pgxpool.ConnectConfig(context.Background(), config)
+	if err != nil {
+		return nil, err
+	}
+	db := sql.OpenDB(poolConnector{pool: pool, owned: true})
+	// Hand connections back to the pool rather than keeping them idle
+	db.SetMaxIdleConns(0)
+	return db, nil
+}
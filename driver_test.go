@@ -0,0 +1,48 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestWithDriver(t *testing.T) {
+	storage, err := newStorage(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, DriverStdlib, storage.driver)
+
+	storage, err = newStorage(nil, []Option{WithDriver(DriverPgxpool)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, DriverPgxpool, storage.driver)
+
+	_, err = newStorage(nil, []Option{WithDriver("pq")})
+	assert.NotNil(t, err)
+
+	_, err = Open(nil, WithDriver(DriverPgxpool))
+	assert.NotNil(t, err)
+}
+
+func TestStorage_OpenPoolDB(t *testing.T) {
+	storage, err := newStorage(nil, []Option{WithDriver(DriverPgxpool), WithMaxOpenConns(3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing listens on port 1, but connecting is deferred to first use
+	db, err := storage.openDB("postgres://localhost:1/certmagic?connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ok := db.Driver().(poolDriver)
+	assert.True(t, ok, "expected a pool driver, got %T", db.Driver())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NotNil(t, db.PingContext(ctx))
+	assert.Nil(t, db.Close())
+}
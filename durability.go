@@ -0,0 +1,83 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// WithAsynchronousCommit commits writes of keys within any of the
+// directories named by prefixes, such as "ocsp", with
+// synchronous_commit off. Such writes return without waiting for
+// the write-ahead log to be flushed or for synchronous replicas,
+// noticeably reducing latency, at the risk of losing the most recent
+// writes if the server crashes. It suits data certmagic can easily
+// recreate, such as OCSP staples; certificates and keys should stay
+// fully durable.
+func WithAsynchronousCommit(prefixes ...string) Option {
+	return func(storage Storage) (Storage, error) {
+		for _, prefix := range prefixes {
+			if strings.TrimSuffix(prefix, "/") == "" {
+				return storage, fmt.Errorf("invalid asynchronous commit prefix: %q", prefix)
+			}
+		}
+		storage.asyncCommitPrefixes = append([]string(nil), prefixes...)
+		return storage, nil
+	}
+}
+
+// WithAsynchronousLockCommit commits lock acquisitions and releases
+// with synchronous_commit off. A lock acquired just before a server
+// crash may be lost, letting another instance take it.
+func WithAsynchronousLockCommit() Option {
+	return func(storage Storage) (Storage, error) {
+		storage.asyncCommitLocks = true
+		return storage, nil
+	}
+}
+
+// isAsyncKey reports whether writes of key may commit asynchronously.
+func (s Storage) isAsyncKey(key string) bool {
+	for _, prefix := range s.asyncCommitPrefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if key == prefix || strings.HasPrefix(key, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// withCommitMode calls fn with db, or, if atomic or async, with a
+// transaction on db. If async, the transaction is committed with
+// synchronous_commit off. CockroachDB has no such setting, so there
+// writes are always synchronous.
+func (s Storage) withCommitMode(ctx context.Context, db *sql.DB, async, atomic bool, fn func(q querier) error) error {
+	async = async && s.dialect != DialectCockroachDB
+	if !async && !atomic {
+		return fn(db)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if async {
+		if _, err := tx.ExecContext(ctx, `SET LOCAL synchronous_commit = off`); err != nil {
+			return fmt.Errorf("failed exec: %w", err)
+		}
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,20 @@
+package certmagic_postgres
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestStorage_IsAsyncKey(t *testing.T) {
+	storage, err := Open(nil, WithAsynchronousCommit("ocsp/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, storage.isAsyncKey("ocsp"))
+	assert.True(t, storage.isAsyncKey("ocsp/example.com-abc"))
+	assert.False(t, storage.isAsyncKey("ocspx/example.com-abc"))
+	assert.False(t, storage.isAsyncKey("certificates/acme/example.com/example.com.key"))
+
+	_, err = Open(nil, WithAsynchronousCommit(""))
+	assert.NotNil(t, err)
+}
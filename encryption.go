@@ -0,0 +1,81 @@
+package certmagic_postgres
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+)
+
+// encryptedPrefix marks values encrypted by the storage, so that
+// values stored before encryption was enabled are still read as is.
+var encryptedPrefix = []byte("certmagic-postgres:aes-256-gcm:")
+
+// WithEncryptionKey encrypts every value stored from now on with
+// AES-256-GCM under key, which must be 32 bytes long, and decrypts
+// encrypted values when reading them. Values stored unencrypted are
+// still read, so encryption can be enabled on an existing database;
+// they are encrypted once next written. Encrypted values are bound to
+// their tenant and key, so a value copied to another row by anything
+// but Copy or Move fails to decrypt. Sizes reported by Stat and
+// ListWithInfo include the encryption overhead.
+func WithEncryptionKey(key []byte) Option {
+	return func(storage Storage) (Storage, error) {
+		if len(key) != 32 {
+			return storage, fmt.Errorf("invalid encryption key: must be 32 bytes, got %d", len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return storage, fmt.Errorf("invalid encryption key: %w", err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return storage, fmt.Errorf("invalid encryption key: %w", err)
+		}
+		storage.aead = aead
+		return storage, nil
+	}
+}
+
+// encryptValue returns value encrypted for key, or value itself if
+// no encryption key is configured.
+func (s Storage) encryptValue(key string, value []byte) ([]byte, error) {
+	if s.aead == nil {
+		return value, nil
+	}
+	header := len(encryptedPrefix) + s.aead.NonceSize()
+	encrypted := make([]byte, header, header+len(value)+s.aead.Overhead())
+	copy(encrypted, encryptedPrefix)
+	nonce := encrypted[len(encryptedPrefix):]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return s.aead.Seal(encrypted, nonce, value, s.additionalData(key)), nil
+}
+
+// decryptValue returns the value read at key decrypted, or as is if
+// it was stored unencrypted.
+func (s Storage) decryptValue(key string, value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+	if s.aead == nil {
+		return nil, fmt.Errorf("value of %s is encrypted, but no encryption key is configured", key)
+	}
+	sealed := value[len(encryptedPrefix):]
+	if len(sealed) < s.aead.NonceSize() {
+		return nil, fmt.Errorf("failed to decrypt value of %s: too short", key)
+	}
+	plain, err := s.aead.Open(nil, sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():], s.additionalData(key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt value of %s: %w", key, err)
+	}
+	return plain, nil
+}
+
+// additionalData returns the data authenticated along with the value
+// at key, binding it to the row it is stored in.
+func (s Storage) additionalData(key string) []byte {
+	return []byte(s.tenant + "\x00" + s.encodeKey(key))
+}
@@ -0,0 +1,91 @@
+package certmagic_postgres_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/fluidgalleries/certmagic-postgres"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestWithEncryptionKey_Invalid(t *testing.T) {
+	_, err := certmagic_postgres.Open(nil, certmagic_postgres.WithEncryptionKey([]byte("too short")))
+	assert.NotNil(t, err)
+}
+
+func TestStorage_Encryption(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	plain, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage, err := certmagic_postgres.Open(db, certmagic_postgres.WithEncryptionKey(bytes.Repeat([]byte{1}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Values stored before encryption was enabled are still read
+	err = plain.Store(context.Background(), "before", []byte("old"))
+	require.Nil(t, err)
+	value, err := storage.Load(context.Background(), "before")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("old"), value)
+
+	err = storage.Store(context.Background(), "secret", []byte("private key"))
+	require.Nil(t, err)
+	var raw []byte
+	err = db.QueryRow(`SELECT value FROM certmagic_data WHERE key = 'secret'`).Scan(&raw)
+	require.Nil(t, err)
+	assert.False(t, bytes.Contains(raw, []byte("private key")))
+
+	value, err = storage.Load(context.Background(), "secret")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("private key"), value)
+
+	values, err := storage.LoadMany(context.Background(), []string{"before", "secret"})
+	require.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"before": []byte("old"), "secret": []byte("private key")}, values)
+
+	_, err = plain.Load(context.Background(), "secret")
+	assert.NotNil(t, err)
+
+	other, err := certmagic_postgres.Open(db, certmagic_postgres.WithEncryptionKey(bytes.Repeat([]byte{2}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = other.Load(context.Background(), "secret")
+	assert.NotNil(t, err)
+}
+
+func TestStorage_Encryption_BoundToKey(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db, certmagic_postgres.WithEncryptionKey(bytes.Repeat([]byte{1}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	require.Nil(t, storage.Store(ctx, "victim", []byte("victim key")))
+	require.Nil(t, storage.Store(ctx, "attacker", []byte("attacker key")))
+
+	// A value swapped into another row doesn't decrypt
+	_, err = db.Exec(`UPDATE certmagic_data SET value = (SELECT value FROM certmagic_data WHERE key = 'attacker') WHERE key = 'victim'`)
+	require.Nil(t, err)
+	_, err = storage.Load(ctx, "victim")
+	assert.NotNil(t, err)
+
+	// Copy and Move encrypt the value again for its new key
+	require.Nil(t, storage.Copy(ctx, "attacker", "copied"))
+	value, err := storage.Load(ctx, "copied")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("attacker key"), value)
+	require.Nil(t, storage.Move(ctx, "copied", "moved"))
+	value, err = storage.Load(ctx, "moved")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("attacker key"), value)
+}
@@ -0,0 +1,43 @@
+package certmagic_postgres
+
+import (
+	"os"
+	"strings"
+)
+
+// connectionEnv maps the standard libpq environment variables to the
+// connection parameters they set.
+var connectionEnv = []struct {
+	variable string
+	keyword  string
+}{
+	{"PGHOST", "host"},
+	{"PGPORT", "port"},
+	{"PGUSER", "user"},
+	{"PGPASSWORD", "password"},
+	{"PGDATABASE", "dbname"},
+	{"PGSSLMODE", "sslmode"},
+}
+
+// ConnectionStringFromEnv assembles a connection string from the
+// standard PGHOST, PGPORT, PGUSER, PGPASSWORD, PGDATABASE and
+// PGSSLMODE environment variables. Variables that aren't set are left
+// out, so they take the libpq defaults, e.g. a local socket or
+// localhost for the host. Connect uses it when given an empty
+// connection string.
+func ConnectionStringFromEnv() (string, error) {
+	var params []string
+	for _, env := range connectionEnv {
+		if value := os.Getenv(env.variable); value != "" {
+			params = append(params, env.keyword+"="+quoteConnectionValue(value))
+		}
+	}
+	return strings.Join(params, " "), nil
+}
+
+// quoteConnectionValue quotes value for a keyword/value connection string.
+func quoteConnectionValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
@@ -0,0 +1,43 @@
+package certmagic_postgres
+
+import (
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestConnectionStringFromEnv(t *testing.T) {
+	for _, env := range connectionEnv {
+		t.Setenv(env.variable, "")
+	}
+	connectionString, err := ConnectionStringFromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, "", connectionString)
+
+	t.Setenv("PGHOST", "db.example.com")
+	t.Setenv("PGPORT", "6432")
+	t.Setenv("PGUSER", "caddy")
+	t.Setenv("PGPASSWORD", `it's a \secret`)
+	t.Setenv("PGDATABASE", "certmagic")
+	t.Setenv("PGSSLMODE", "disable")
+
+	connectionString, err = ConnectionStringFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, `host='db.example.com' port='6432' user='caddy' password='it\'s a \\secret' dbname='certmagic' sslmode='disable'`, connectionString)
+
+	// Unset the variables, so only the connection string is parsed
+	for _, env := range connectionEnv {
+		t.Setenv(env.variable, "")
+	}
+	config, err := pgx.ParseConfig(connectionString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "db.example.com", config.Host)
+	assert.Equal(t, uint16(6432), config.Port)
+	assert.Equal(t, "caddy", config.User)
+	assert.Equal(t, `it's a \secret`, config.Password)
+	assert.Equal(t, "certmagic", config.Database)
+}
@@ -0,0 +1,77 @@
+package certmagic_postgres
+
+import (
+	"errors"
+	"github.com/jackc/pgconn"
+)
+
+var (
+	// ErrLocked is matched by errors returned when the database
+	// reports that a row or table needed by the operation is locked
+	// by another transaction.
+	ErrLocked = errors.New("locked by another transaction")
+
+	// ErrLockTimeout is matched by errors returned by Lock and its
+	// variants when giving up waiting for a lock held by someone
+	// else, because the context is done or the maximum lock wait
+	// has passed.
+	ErrLockTimeout = errors.New("timed out waiting for lock")
+
+	// ErrTooLarge is matched by errors returned when a value or key
+	// is too large, either for WithMaxValueSize or for the database.
+	ErrTooLarge = errors.New("too large")
+
+	// ErrReadOnly is matched by errors returned when writing to a
+	// database that only allows reads, such as a hot standby.
+	ErrReadOnly = errors.New("database is read-only")
+
+	// ErrUnavailable is matched by errors returned without reaching
+	// the database while the health monitor finds it unreachable.
+	ErrUnavailable = errors.New("database is unavailable")
+
+	// ErrClosed is matched by errors returned by operations started
+	// after Shutdown.
+	ErrClosed = errors.New("storage is shut down")
+)
+
+// classifiedError is a database error matching
+// one of the exported error values.
+type classifiedError struct {
+	kind error
+	err  error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+func (e *classifiedError) Is(target error) bool {
+	return target == e.kind
+}
+
+// classifyError wraps err so that it matches the exported error value
+// corresponding to the underlying PostgreSQL error, if any, while
+// still unwrapping to the original error.
+func classifyError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	var kind error
+	switch pgErr.Code {
+	case "55P03": // lock not available
+		kind = ErrLocked
+	case "54000": // program limit exceeded, e.g. an index row too large
+		kind = ErrTooLarge
+	case "25006": // read only SQL transaction
+		kind = ErrReadOnly
+	default:
+		return err
+	}
+	return &classifiedError{kind: kind, err: err}
+}
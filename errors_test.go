@@ -0,0 +1,35 @@
+package certmagic_postgres
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	tt := []struct {
+		code string
+		kind error
+	}{
+		{code: "55P03", kind: ErrLocked},
+		{code: "54000", kind: ErrTooLarge},
+		{code: "25006", kind: ErrReadOnly},
+	}
+	for _, tc := range tt {
+		t.Run(tc.code, func(t *testing.T) {
+			pgErr := &pgconn.PgError{Code: tc.code}
+			err := classifyError(fmt.Errorf("failed exec: %w", pgErr))
+			assert.True(t, errors.Is(err, tc.kind))
+
+			var unwrapped *pgconn.PgError
+			assert.True(t, errors.As(err, &unwrapped))
+			assert.Equal(t, tc.code, unwrapped.Code)
+		})
+	}
+
+	err := classifyError(&pgconn.PgError{Code: "23505"})
+	assert.False(t, errors.Is(err, ErrLocked) || errors.Is(err, ErrTooLarge) || errors.Is(err, ErrReadOnly))
+	assert.Nil(t, classifyError(nil))
+}
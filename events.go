@@ -0,0 +1,63 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyevents"
+	"strings"
+)
+
+// certificatesPrefix is the directory certmagic stores certificates,
+// their private keys and metadata in.
+const certificatesPrefix = "certificates/"
+
+// eventStorage is a Storage emitting the cert_stored and cert_deleted
+// events through Caddy's events app when a key below certificates/ is
+// stored or deleted, so that renewals can trigger webhooks or scripts.
+type eventStorage struct {
+	Storage
+	ctx    caddy.Context
+	events *caddyevents.App
+}
+
+// Store stores value at key, emitting cert_stored for certificate keys.
+func (s eventStorage) Store(ctx context.Context, key string, value []byte) error {
+	if err := s.Storage.Store(ctx, key, value); err != nil {
+		return err
+	}
+	s.emit("cert_stored", key)
+	return nil
+}
+
+// Delete deletes key, emitting cert_deleted for certificate keys.
+func (s eventStorage) Delete(ctx context.Context, key string) error {
+	if err := s.Storage.Delete(ctx, key); err != nil {
+		return err
+	}
+	s.emit("cert_deleted", key)
+	return nil
+}
+
+func (s eventStorage) emit(name, key string) {
+	data, ok := certificateEventData(key)
+	if !ok {
+		return
+	}
+	s.events.Emit(s.ctx, name, data)
+}
+
+// certificateEventData returns the data of an event about key, which
+// carries the key along with the issuer and domain directories it is
+// stored in, or false if key is not below certificates/.
+func certificateEventData(key string) (map[string]any, bool) {
+	if !strings.HasPrefix(key, certificatesPrefix) {
+		return nil, false
+	}
+	data := map[string]any{"key": key}
+	parts := strings.Split(strings.TrimPrefix(key, certificatesPrefix), "/")
+	if len(parts) >= 2 {
+		data["issuer"] = parts[0]
+		data["domain"] = parts[1]
+	}
+	return data, true
+}
@@ -0,0 +1,32 @@
+package certmagic_postgres
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCertificateEventData(t *testing.T) {
+	tt := []struct {
+		key      string
+		expected map[string]any
+	}{
+		{
+			key: "certificates/acme-v02.api.letsencrypt.org-directory/example.com/example.com.crt",
+			expected: map[string]any{
+				"key":    "certificates/acme-v02.api.letsencrypt.org-directory/example.com/example.com.crt",
+				"issuer": "acme-v02.api.letsencrypt.org-directory",
+				"domain": "example.com",
+			},
+		},
+		{key: "certificates/orphan", expected: map[string]any{"key": "certificates/orphan"}},
+		{key: "ocsp/example.com-abc"},
+		{key: "certificatesx/example.com"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.key, func(t *testing.T) {
+			data, ok := certificateEventData(tc.key)
+			assert.Equal(t, tc.expected != nil, ok)
+			assert.Equal(t, tc.expected, data)
+		})
+	}
+}
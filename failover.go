@@ -0,0 +1,111 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"sync"
+	"time"
+)
+
+// failoverRetryPolicy is the minimum retry policy applied with
+// WithFailover, leaving time for a new primary to be promoted.
+var failoverRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: time.Millisecond * 200,
+	MaxBackoff:     time.Second * 2,
+}
+
+// WithFailover supports connection strings listing several hosts,
+// as managed by Patroni or Stolon. Only hosts accepting writes are
+// connected to, as with target_session_attrs=read-write. When the
+// connected primary becomes unreachable or is demoted to read-only,
+// every existing connection is dropped and the operation is retried
+// against the next writable host. Only supported by Connect.
+func WithFailover() Option {
+	return func(storage Storage) (Storage, error) {
+		storage.failover = newFailover()
+		return storage, nil
+	}
+}
+
+// failover tracks the connections opened since the last failover,
+// shared between copies of the Storage. Connections opened before
+// it are dropped when they are next used.
+type failover struct {
+	mu    sync.Mutex
+	conns map[*pgx.Conn]struct{}
+
+	// isClosed reports whether a tracked connection has been closed
+	// by the pool, so it can be forgotten.
+	isClosed func(*pgx.Conn) bool
+}
+
+func newFailover() *failover {
+	return &failover{
+		conns:    make(map[*pgx.Conn]struct{}),
+		isClosed: (*pgx.Conn).IsClosed,
+	}
+}
+
+// configure makes config only connect to writable hosts, and returns
+// the hooks tracking connections.
+func (f *failover) configure(config *pgx.ConnConfig) (afterConnect, resetSession func(context.Context, *pgx.Conn) error) {
+	if config.ValidateConnect == nil {
+		config.ValidateConnect = pgconn.ValidateConnectTargetSessionAttrsReadWrite
+	}
+	return f.afterConnect, f.resetSession
+}
+
+// afterConnect tracks conn, forgetting the connections
+// closed since, so they don't accumulate.
+func (f *failover) afterConnect(_ context.Context, conn *pgx.Conn) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for tracked := range f.conns {
+		if f.isClosed(tracked) {
+			delete(f.conns, tracked)
+		}
+	}
+	f.conns[conn] = struct{}{}
+	return nil
+}
+
+// resetSession drops conn if it was opened before the last failover.
+func (f *failover) resetSession(_ context.Context, conn *pgx.Conn) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.conns[conn]; ok {
+		return nil
+	}
+	return driver.ErrBadConn
+}
+
+// check forgets every connection if err shows the primary has been
+// demoted, and returns err marked as retryable.
+func (f *failover) check(err error) error {
+	if !errors.Is(classifyError(err), ErrReadOnly) {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.conns = make(map[*pgx.Conn]struct{})
+	return &failoverError{err: err}
+}
+
+// failoverError is an error that should succeed once retried
+// against the new primary.
+type failoverError struct {
+	err error
+}
+
+func (e *failoverError) Error() string {
+	return e.err.Error()
+}
+
+func (e *failoverError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,56 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFailover(t *testing.T) {
+	f := newFailover()
+	config, err := pgx.ParseConfig("postgres://db1,db2/certmagic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	afterConnect, resetSession := f.configure(config)
+	assert.NotNil(t, config.ValidateConnect)
+
+	conn := &pgx.Conn{}
+	assert.Nil(t, afterConnect(context.Background(), conn))
+	assert.Nil(t, resetSession(context.Background(), conn))
+
+	other := fmt.Errorf("failed exec: %w", &pgconn.PgError{Code: "23505"})
+	assert.Equal(t, other, f.check(other))
+	assert.Nil(t, resetSession(context.Background(), conn))
+
+	readOnly := fmt.Errorf("failed exec: %w", &pgconn.PgError{Code: "25006"})
+	err = f.check(readOnly)
+	assert.True(t, isTransientError(err))
+	assert.ErrorIs(t, classifyError(err), ErrReadOnly)
+	assert.Equal(t, driver.ErrBadConn, resetSession(context.Background(), conn))
+}
+
+func TestFailover_PrunesClosedConnections(t *testing.T) {
+	f := newFailover()
+	closed := make(map[*pgx.Conn]bool)
+	f.isClosed = func(conn *pgx.Conn) bool {
+		return closed[conn]
+	}
+
+	first, second, third := &pgx.Conn{}, &pgx.Conn{}, &pgx.Conn{}
+	assert.Nil(t, f.afterConnect(context.Background(), first))
+	assert.Nil(t, f.afterConnect(context.Background(), second))
+	assert.Len(t, f.conns, 2)
+
+	closed[first] = true
+	assert.Nil(t, f.afterConnect(context.Background(), third))
+	assert.Len(t, f.conns, 2)
+	_, ok := f.conns[first]
+	assert.False(t, ok)
+	assert.Nil(t, f.resetSession(context.Background(), second))
+	assert.Nil(t, f.resetSession(context.Background(), third))
+}
@@ -0,0 +1,82 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrStaleFence is returned by FencedStore when the fence token
+// presented no longer matches the current holder of the lock.
+var ErrStaleFence = errors.New("stale fence token")
+
+// LockWithFence acquires the lock for key like Lock, and returns
+// the fence token assigned to this acquisition. Fence tokens are
+// drawn from a database sequence, so every acquisition of any lock
+// receives a token greater than all tokens issued before it.
+//
+// Pass the token to FencedStore to guard writes made while holding
+// the lock: should the lock expire and be taken over (e.g. because
+// this process was paused), the stale token causes the write to be
+// rejected instead of clobbering the new holder's data.
+//
+// Fencing is not available with LockStrategyRow.
+func (s Storage) LockWithFence(ctx context.Context, key string) (int64, error) {
+	if s.lockStrategy == LockStrategyRow {
+		return 0, fmt.Errorf("fencing is not supported by the %s lock strategy", s.lockStrategy)
+	}
+	return s.waitForLock(ctx, key, s.lockTimeout)
+}
+
+// FencedStore puts value at key, but only if lockKey is still held
+// with the given fence token. ErrStaleFence is returned otherwise.
+func (s Storage) FencedStore(ctx context.Context, lockKey string, fence int64, key string, value []byte) error {
+	if err := s.validateKey(key); err != nil {
+		return err
+	}
+
+	if err := s.validateValue(key, value); err != nil {
+		return err
+	}
+	value, err := s.encryptValue(key, value)
+	if err != nil {
+		return err
+	}
+
+	err = s.run(ctx, opWrite, func(ctx context.Context) error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		// Share-lock the lock row so it cannot be taken over until the write commits
+		var currentFence int64
+		err = tx.QueryRowContext(ctx, s.tables(`SELECT fence FROM certmagic_locks WHERE key = $1 AND tenant = $2 FOR SHARE`), s.lockKey(lockKey), s.tenant).Scan(&currentFence)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("lock %s not held: %w", lockKey, ErrStaleFence)
+		}
+		if err != nil {
+			return fmt.Errorf("failed scan: %w", err)
+		}
+		if currentFence != fence {
+			return fmt.Errorf("lock %s now has fence %d, got %d: %w", lockKey, currentFence, fence, ErrStaleFence)
+		}
+
+		inline, external := s.inlineValue(value)
+		_, err = tx.ExecContext(ctx, s.tables(`INSERT INTO certmagic_data (tenant, key, value, original_key, external) VALUES ($4, $1, $2, $3, $5) ON CONFLICT (tenant, key) DO UPDATE SET VALUE = $2, external = $5, modified = CURRENT_TIMESTAMP`), s.encodeKey(key), inline, s.originalKey(key), s.tenant, external)
+		if err != nil {
+			return fmt.Errorf("failed exec: %w", err)
+		}
+		if err := s.storeBlob(ctx, tx, s.encodeKey(key), value, external); err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	})
+	if err != nil {
+		return err
+	}
+	return s.recordAudit(ctx, AuditStore, key)
+}
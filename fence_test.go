@@ -0,0 +1,64 @@
+package certmagic_postgres_test
+
+import (
+	"context"
+	"errors"
+	"github.com/fluidgalleries/certmagic-postgres"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestStorage_LockWithFence(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := storage.LockWithFence(context.Background(), "abc")
+	require.Nil(t, err)
+	require.Nil(t, storage.Unlock(context.Background(), "abc"))
+
+	second, err := storage.LockWithFence(context.Background(), "abc")
+	require.Nil(t, err)
+	assert.Greater(t, second, first)
+}
+
+func TestStorage_FencedStore(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	paused, err := certmagic_postgres.Open(db, certmagic_postgres.WithLockTimeout("50ms"), certmagic_postgres.WithInstanceID("node-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := certmagic_postgres.Open(db, certmagic_postgres.WithInstanceID("node-2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	staleFence, err := paused.LockWithFence(context.Background(), "lock")
+	require.Nil(t, err)
+
+	err = paused.FencedStore(context.Background(), "lock", staleFence, "abc", []byte("first"))
+	require.Nil(t, err)
+
+	// The lock expires while node-1 is paused and node-2 takes it over
+	time.Sleep(time.Millisecond * 100)
+	fence, err := other.LockWithFence(context.Background(), "lock")
+	require.Nil(t, err)
+
+	err = paused.FencedStore(context.Background(), "lock", staleFence, "abc", []byte("stale"))
+	assert.True(t, errors.Is(err, certmagic_postgres.ErrStaleFence))
+
+	err = other.FencedStore(context.Background(), "lock", fence, "abc", []byte("second"))
+	require.Nil(t, err)
+
+	value, err := other.Load(context.Background(), "abc")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("second"), value)
+}
@@ -0,0 +1,149 @@
+package certmagic_postgres
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// healthCheckKeyPrefix prefixes the sentinel keys written by HealthCheck.
+const healthCheckKeyPrefix = ".healthcheck/"
+
+// HealthCheck verifies that the database is reachable, that the
+// required tables exist, and that data can be written, read back
+// and deleted, by round-tripping a sentinel key unique to this
+// instance. It returns nil if the storage is fully usable.
+func (s Storage) HealthCheck(ctx context.Context) error {
+	return s.run(ctx, opDefault, func(ctx context.Context) error {
+		if err := s.db.PingContext(ctx); err != nil {
+			return fmt.Errorf("failed to ping database: %w", err)
+		}
+
+		for _, table := range []string{s.tableName(defaultDataTable), s.tableName(defaultLocksTable)} {
+			var exists bool
+			if err := s.db.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, table).Scan(&exists); err != nil {
+				return fmt.Errorf("failed to check table %s: %w", table, err)
+			}
+			if !exists {
+				return fmt.Errorf("missing table %s", table)
+			}
+		}
+
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		key := s.encodeKey(healthCheckKeyPrefix + s.instanceID)
+		value := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+		if _, err := tx.ExecContext(ctx, s.tables(`INSERT INTO certmagic_data (tenant, key, value, original_key) VALUES ($3, $1, $2, $4) ON CONFLICT (tenant, key) DO UPDATE SET VALUE = $2, modified = CURRENT_TIMESTAMP`), key, value, s.tenant, s.originalKey(healthCheckKeyPrefix+s.instanceID)); err != nil {
+			return fmt.Errorf("failed to write sentinel key: %w", err)
+		}
+
+		var got []byte
+		if err := tx.QueryRowContext(ctx, s.tables(`SELECT value FROM certmagic_data WHERE key = $1 AND tenant = $2`), key, s.tenant).Scan(&got); err != nil {
+			return fmt.Errorf("failed to read sentinel key: %w", err)
+		}
+		if !bytes.Equal(got, value) {
+			return fmt.Errorf("sentinel key read back %q, expected %q", got, value)
+		}
+
+		if _, err := tx.ExecContext(ctx, s.tables(`DELETE FROM certmagic_data WHERE key = $1 AND tenant = $2`), key, s.tenant); err != nil {
+			return fmt.Errorf("failed to delete sentinel key: %w", err)
+		}
+
+		return tx.Commit()
+	})
+}
+
+// HealthStatus describes the state of the database, as reported by
+// Status.
+type HealthStatus struct {
+	// Connected reports whether the database answered a ping.
+	Connected bool
+
+	// SchemaProblems lists the problems ValidateSchema found, unless
+	// schema validation is disabled.
+	SchemaProblems []string
+
+	// Standby reports whether the database is a hot standby, which
+	// can't be written to.
+	Standby bool
+
+	// ReadReplica reports whether reads go to a read replica, and
+	// ReadReplicaConnected whether it answered.
+	ReadReplica          bool
+	ReadReplicaConnected bool
+
+	// ReplicationLag is how long ago the last transaction replayed by
+	// the read replica, or else by the standby, was committed on the
+	// primary.
+	ReplicationLag time.Duration
+
+	// LastError is the first error met while checking, or else the
+	// last failed ping of the health monitor, if any.
+	LastError error
+}
+
+// Healthy reports whether the database, and any read replica, is
+// reachable and its schema is what the storage expects.
+func (h HealthStatus) Healthy() bool {
+	return h.Connected && len(h.SchemaProblems) == 0 && (!h.ReadReplica || h.ReadReplicaConnected)
+}
+
+// Status reports the state of the database without writing to it,
+// for readiness checks. Unlike HealthCheck, it never fails: problems
+// are described by the returned status.
+func (s Storage) Status(ctx context.Context) HealthStatus {
+	var status HealthStatus
+	if s.breaker != nil {
+		status.LastError = s.breaker.lastError()
+	}
+	fail := func(err error) HealthStatus {
+		status.LastError = err
+		return status
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	if err := s.db.PingContext(ctx); err != nil {
+		return fail(fmt.Errorf("failed to ping database: %w", err))
+	}
+	status.Connected = true
+
+	if !s.skipSchemaValidation {
+		var schemaErr *SchemaError
+		if err := s.ValidateSchema(ctx); errors.As(err, &schemaErr) {
+			status.SchemaProblems = schemaErr.Problems
+		} else if err != nil {
+			return fail(err)
+		}
+	}
+
+	if s.isDistributed() {
+		return status
+	}
+	const replicationQuery = `SELECT pg_is_in_recovery(), COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)`
+	var lag float64
+	if err := s.db.QueryRowContext(ctx, replicationQuery).Scan(&status.Standby, &lag); err != nil {
+		return fail(fmt.Errorf("failed scan: %w", err))
+	}
+	status.ReplicationLag = time.Duration(lag * float64(time.Second))
+
+	if s.readDB == nil || s.readDB == s.db {
+		return status
+	}
+	status.ReadReplica = true
+	var standby bool
+	if err := s.readDB.QueryRowContext(ctx, replicationQuery).Scan(&standby, &lag); err != nil {
+		return fail(fmt.Errorf("failed to check read replica: %w", err))
+	}
+	status.ReadReplicaConnected = true
+	status.ReplicationLag = time.Duration(lag * float64(time.Second))
+	return status
+}
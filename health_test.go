@@ -0,0 +1,52 @@
+package certmagic_postgres_test
+
+import (
+	"context"
+	"github.com/fluidgalleries/certmagic-postgres"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestStorage_HealthCheck(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.HealthCheck(context.Background())
+	assert.Nil(t, err)
+
+	keys, err := storage.List(context.Background(), "", true)
+	assert.Nil(t, err)
+	assert.Empty(t, keys)
+
+	migrateDown(t, db)
+	err = storage.HealthCheck(context.Background())
+	assert.NotNil(t, err)
+}
+
+func TestStorage_Status(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := storage.Status(context.Background())
+	assert.True(t, status.Healthy())
+	assert.True(t, status.Connected)
+	assert.Empty(t, status.SchemaProblems)
+	assert.False(t, status.Standby)
+	assert.Nil(t, status.LastError)
+
+	migrateDown(t, db)
+	status = storage.Status(context.Background())
+	assert.False(t, status.Healthy())
+	assert.True(t, status.Connected)
+	assert.NotEmpty(t, status.SchemaProblems)
+}
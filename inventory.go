@@ -0,0 +1,88 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CertificateInfo describes a certificate stored by certmagic.
+type CertificateInfo struct {
+	// Key is the storage key of the certificate.
+	Key string
+
+	// Issuer and Domain are the directories the certificate is stored
+	// in, such as acme-v02.api.letsencrypt.org-directory and
+	// example.com.
+	Issuer string
+	Domain string
+
+	// Names are the DNS names and IP addresses the certificate is for.
+	Names []string
+
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// Certificates returns every certificate stored below certificates/,
+// sorted by expiry, parsing the leaf certificate of each. Values that
+// aren't PEM-encoded certificates are left out. They are read from
+// the read replica, if any.
+func (s Storage) Certificates(ctx context.Context) ([]CertificateInfo, error) {
+	keys, err := s.ListMatch(ctx, certificatesPrefix+"*/*/*.crt")
+	if err != nil {
+		return nil, err
+	}
+
+	var certificates []CertificateInfo
+	for start := 0; start < len(keys); start += listPageSize {
+		page := keys[start:min(start+listPageSize, len(keys))]
+		values, err := s.loadMany(ctx, s.readDB, page)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range page {
+			value, ok := values[key]
+			if !ok {
+				continue
+			}
+			certificate, ok := parseCertificateInfo(key, value)
+			if !ok {
+				continue
+			}
+			certificates = append(certificates, certificate)
+		}
+	}
+	sort.SliceStable(certificates, func(i, j int) bool { return certificates[i].NotAfter.Before(certificates[j].NotAfter) })
+	return certificates, nil
+}
+
+// parseCertificateInfo parses the first certificate of the PEM chain
+// stored at key.
+func parseCertificateInfo(key string, value []byte) (CertificateInfo, bool) {
+	block, _ := pem.Decode(value)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return CertificateInfo{}, false
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return CertificateInfo{}, false
+	}
+
+	info := CertificateInfo{
+		Key:       key,
+		Names:     append([]string(nil), certificate.DNSNames...),
+		NotBefore: certificate.NotBefore,
+		NotAfter:  certificate.NotAfter,
+	}
+	for _, ip := range certificate.IPAddresses {
+		info.Names = append(info.Names, ip.String())
+	}
+	if parts := strings.Split(strings.TrimPrefix(key, certificatesPrefix), "/"); len(parts) >= 2 {
+		info.Issuer, info.Domain = parts[0], parts[1]
+	}
+	return info, true
+}
@@ -0,0 +1,22 @@
+package certmagic_postgres
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParseCertificateInfo(t *testing.T) {
+	certPEM, _ := selfSignedCert(t)
+	key := "certificates/acme-v02.api.letsencrypt.org-directory/example.com/example.com.crt"
+
+	info, ok := parseCertificateInfo(key, certPEM)
+	assert.True(t, ok)
+	assert.Equal(t, key, info.Key)
+	assert.Equal(t, "acme-v02.api.letsencrypt.org-directory", info.Issuer)
+	assert.Equal(t, "example.com", info.Domain)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), info.NotAfter, time.Minute)
+
+	_, ok = parseCertificateInfo(key, []byte("not a certificate"))
+	assert.False(t, ok)
+}
@@ -0,0 +1,72 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// WithLazyConnect makes Connect return without reaching the database,
+// which is then reached, migrated if auto migration is enabled, and
+// validated by the first operation. Until that succeeds, every
+// operation tries again. Useful when Caddy may start before the
+// database. The dialect is not detected, so anything other than
+// PostgreSQL must be set with WithDialect.
+func WithLazyConnect() Option {
+	return func(storage Storage) (Storage, error) {
+		storage.lazyConnect = true
+		return storage, nil
+	}
+}
+
+// lazyInit records whether a lazily connected Storage has been
+// prepared, shared between copies of the Storage.
+type lazyInit struct {
+	mu   sync.Mutex
+	done bool
+}
+
+// ensureInitialized prepares a lazily connected Storage the first
+// time it is called successfully.
+func (s Storage) ensureInitialized(ctx context.Context) error {
+	if s.lazyInit == nil {
+		return nil
+	}
+
+	s.lazyInit.mu.Lock()
+	defer s.lazyInit.mu.Unlock()
+	if s.lazyInit.done {
+		return nil
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	if err := s.db.PingContext(pingCtx); err != nil {
+		return fmt.Errorf("failed to ping database: %w", err)
+	}
+	if err := s.prepare(); err != nil {
+		return err
+	}
+
+	s.lazyInit.done = true
+	return nil
+}
+
+// prepare runs the automatic migrations, if enabled, and validates
+// the schema of a connected Storage.
+func (s Storage) prepare() error {
+	if s.autoMigrate {
+		ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+		defer cancel()
+		if _, err := s.Migrate(ctx); err != nil {
+			return err
+		}
+	}
+	if s.skipSchemaValidation {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.queryTimeout)
+	defer cancel()
+	return s.ValidateSchema(ctx)
+}
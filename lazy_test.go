@@ -0,0 +1,21 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestConnect_Lazy(t *testing.T) {
+	// Nothing listens on port 1, so the database is unreachable
+	storage, err := Connect("postgres://localhost:1/certmagic?connect_timeout=1", WithLazyConnect())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	assert.Equal(t, DialectPostgres, storage.dialect)
+
+	_, err = storage.Load(context.Background(), "key")
+	assert.NotNil(t, err)
+	assert.False(t, storage.lazyInit.done)
+}
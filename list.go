@@ -0,0 +1,298 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/caddyserver/certmagic"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// listDirectory returns the entries of the directory named by prefix,
+// given keys that all start with prefix. Keys are paths separated by
+// "/": unless recursive, keys below an immediate child directory are
+// collapsed into that directory's entry. When recursive, every
+// directory and key below prefix is returned.
+func listDirectory(prefix string, keys []string, recursive bool) []string {
+	infos := make([]certmagic.KeyInfo, len(keys))
+	for i, key := range keys {
+		infos[i] = certmagic.KeyInfo{Key: key, IsTerminal: true}
+	}
+
+	var entries []string
+	for _, info := range listDirectoryInfo(prefix, infos, recursive) {
+		entries = append(entries, info.Key)
+	}
+	return entries
+}
+
+// listDirectoryInfo is listDirectory for KeyInfo. Directory entries
+// are not terminal, have no size, and take the latest modified time
+// of the keys below them.
+func listDirectoryInfo(prefix string, infos []certmagic.KeyInfo, recursive bool) []certmagic.KeyInfo {
+	base := directoryPrefix(prefix)
+
+	index := make(map[string]int)
+	var entries []certmagic.KeyInfo
+	add := func(entry certmagic.KeyInfo) {
+		i, ok := index[entry.Key]
+		if !ok {
+			index[entry.Key] = len(entries)
+			entries = append(entries, entry)
+			return
+		}
+		if entry.Modified.After(entries[i].Modified) {
+			entries[i].Modified = entry.Modified
+		}
+	}
+	addDirectory := func(name string, info certmagic.KeyInfo) {
+		add(certmagic.KeyInfo{Key: name, Modified: info.Modified, IsTerminal: false})
+	}
+
+	for _, info := range infos {
+		key := info.Key
+		if !strings.HasPrefix(key, base) || len(key) == len(base) {
+			continue
+		}
+		rest := key[len(base):]
+
+		if !recursive {
+			if i := strings.Index(rest, "/"); i >= 0 {
+				addDirectory(base+rest[:i], info)
+				continue
+			}
+			add(info)
+			continue
+		}
+
+		for i, c := range rest {
+			if c == '/' {
+				addDirectory(base+rest[:i], info)
+			}
+		}
+		add(info)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return entries
+}
+
+// ListWithInfo returns the entries List would, along with their
+// size and modified time, using a single query instead of calling
+// Stat for every key. Directory entries are not terminal and carry
+// the latest modified time of the keys below them.
+func (s Storage) ListWithInfo(ctx context.Context, prefix string, recursive bool) ([]certmagic.KeyInfo, error) {
+	return runWithResult(ctx, s, opList, func(ctx context.Context) ([]certmagic.KeyInfo, error) {
+		query := `SELECT %[1]s, LENGTH (%[2]s), modified FROM certmagic_data WHERE directory = $1 AND %[1]s LIKE $3 ESCAPE '\' AND tenant = $4 UNION ALL SELECT directory || '/', 0, max(modified) FROM certmagic_data WHERE directory LIKE $2 ESCAPE '\' AND tenant = $4 GROUP BY directory`
+		if recursive {
+			query = `SELECT %[1]s, LENGTH (%[2]s), modified FROM certmagic_data WHERE (directory = $1 OR directory LIKE $2 ESCAPE '\') AND %[1]s LIKE $3 ESCAPE '\' AND tenant = $4`
+		}
+		directory, below := s.directoryArgs(prefix)
+		rows, err := s.readDB.QueryContext(ctx, s.tables(fmt.Sprintf(query, s.keyColumn(), valueColumn)), directory, below, escapeLike(s.keyPrefix+directoryPrefix(prefix))+"%", s.tenant)
+		if err != nil {
+			return nil, fmt.Errorf("failed query: %w", err)
+		}
+		defer rows.Close()
+
+		var infos []certmagic.KeyInfo
+		for rows.Next() {
+			info := certmagic.KeyInfo{IsTerminal: true}
+			if err := rows.Scan(&info.Key, &info.Size, &info.Modified); err != nil {
+				return nil, fmt.Errorf("failed scan: %w", err)
+			}
+			info.Key = s.trimKeyPrefix(info.Key)
+			infos = append(infos, info)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed iterating rows: %w", err)
+		}
+		return listDirectoryInfo(prefix, infos, recursive), nil
+	})
+}
+
+// likeEscaper escapes the LIKE pattern metacharacters, using
+// backslash as the escape character.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike returns s escaped for literal use in a LIKE
+// pattern with ESCAPE '\'.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// directoryPrefix returns the prefix shared by every key
+// within the directory named by prefix.
+func directoryPrefix(prefix string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+// directoryArgs returns the values of the directory column matching
+// the directory named by prefix: the directory of its immediate
+// children, and a LIKE pattern for the directories further below it.
+// Both include the key prefix.
+func (s Storage) directoryArgs(prefix string) (string, string) {
+	base := s.keyPrefix + directoryPrefix(prefix)
+	directory := ""
+	if i := strings.LastIndex(base, "/"); i >= 0 {
+		directory = base[:i]
+	}
+	return directory, escapeLike(base) + "_%"
+}
+
+// ListPage returns up to limit terminal keys below the directory
+// named by prefix, in key order, starting after the key after. Pass
+// an empty after to start from the beginning, and the last key of
+// the previous page to continue; a page shorter than limit is the
+// last one. Unlike List, this never holds more than a page of keys
+// in memory, so it suits iterating over very large stores.
+func (s Storage) ListPage(ctx context.Context, prefix, after string, limit int) ([]string, error) {
+	if limit < 1 {
+		return nil, fmt.Errorf("invalid page limit: %d", limit)
+	}
+
+	return runWithResult(ctx, s, opList, func(ctx context.Context) ([]string, error) {
+		query := fmt.Sprintf(s.tables(`SELECT %[1]s FROM certmagic_data WHERE %[1]s LIKE $1 ESCAPE '\' AND %[1]s > $2 AND tenant = $4 ORDER BY %[1]s LIMIT $3`), s.keyColumn())
+		rows, err := s.readDB.QueryContext(ctx, query, escapeLike(s.keyPrefix+directoryPrefix(prefix))+"%", s.keyPrefix+after, limit, s.tenant)
+		if err != nil {
+			return nil, fmt.Errorf("failed query: %w", err)
+		}
+		defer rows.Close()
+
+		keys := make([]string, 0, limit)
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				return nil, fmt.Errorf("failed scan: %w", err)
+			}
+			keys = append(keys, s.trimKeyPrefix(key))
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed iterating rows: %w", err)
+		}
+		return keys, nil
+	})
+}
+
+// listPageSize is the number of keys read per query when listing
+// recursively.
+const listPageSize = 1000
+
+// listRecursive returns every directory and key below the directory
+// named by prefix, reading the keys a page at a time so that listing
+// a large store, as `caddy storage export` does, runs as a series of
+// short queries instead of one holding a connection throughout.
+func (s Storage) listRecursive(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	after := ""
+	for {
+		page, err := s.ListPage(ctx, prefix, after, listPageSize)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, page...)
+		if len(page) < listPageSize {
+			return listDirectory(prefix, keys, true), nil
+		}
+		after = page[len(page)-1]
+	}
+}
+
+// statDirectory returns information about the directory named by
+// key, as listed by List, with the latest modified time of the keys
+// below it. An error wrapping fs.ErrNotExist is returned if there is
+// no key below it.
+func (s Storage) statDirectory(ctx context.Context, key string) (certmagic.KeyInfo, error) {
+	var modified sql.NullTime
+	directory, below := s.directoryArgs(key)
+	err := s.db.QueryRowContext(ctx, s.tables(`SELECT max(modified) FROM certmagic_data WHERE (directory = $1 OR directory LIKE $2 ESCAPE '\') AND tenant = $3`), directory, below, s.tenant).Scan(&modified)
+	if err != nil {
+		return certmagic.KeyInfo{}, fmt.Errorf("failed scan: %w", err)
+	}
+	if !modified.Valid {
+		return certmagic.KeyInfo{}, fmt.Errorf("key not found: %s: %w", key, fs.ErrNotExist)
+	}
+	return certmagic.KeyInfo{Key: key, Modified: modified.Time, IsTerminal: false}, nil
+}
+
+// ListMatch returns every terminal key matching the glob pattern,
+// in key order. Within the pattern, "*" matches any run of characters
+// within a single path segment, "**" matches across segments, and
+// "?" matches one character other than "/". For example,
+// "certificates/*/example.com/*" finds example.com's assets from
+// every issuer.
+func (s Storage) ListMatch(ctx context.Context, pattern string) ([]string, error) {
+	return runWithResult(ctx, s, opList, func(ctx context.Context) ([]string, error) {
+		// The LIKE on the literal prefix narrows down the rows the regular expression is applied to
+		query := fmt.Sprintf(s.tables(`SELECT %[1]s FROM certmagic_data WHERE %[1]s LIKE $1 ESCAPE '\' AND %[1]s ~ $2 AND tenant = $3 ORDER BY %[1]s`), s.keyColumn())
+		rows, err := s.readDB.QueryContext(ctx, query, escapeLike(s.keyPrefix+globPrefix(pattern))+"%", "^"+quoteRegexp(s.keyPrefix)+strings.TrimPrefix(globToRegexp(pattern), "^"), s.tenant)
+		if err != nil {
+			return nil, fmt.Errorf("failed query: %w", err)
+		}
+		defer rows.Close()
+
+		var keys []string
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				return nil, fmt.Errorf("failed scan: %w", err)
+			}
+			keys = append(keys, s.trimKeyPrefix(key))
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed iterating rows: %w", err)
+		}
+		return keys, nil
+	})
+}
+
+// globPrefix returns the literal part of pattern before its first wildcard.
+func globPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, "*?"); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// globToRegexp translates a glob pattern, as accepted by ListMatch,
+// into an anchored PostgreSQL regular expression.
+func globToRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '*' && i+1 < len(pattern) && pattern[i+1] == '*':
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(quoteRegexp(pattern[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// quoteRegexp escapes s for literal use in a PostgreSQL regular expression.
+func quoteRegexp(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '/' || c >= 0x80) {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
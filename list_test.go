@@ -0,0 +1,136 @@
+package certmagic_postgres
+
+import (
+	"github.com/caddyserver/certmagic"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestListDirectory(t *testing.T) {
+	keys := []string{
+		"certificates/acme/example.com/example.com.crt",
+		"certificates/acme/example.com/example.com.key",
+		"certificates/acme/example.org/example.org.crt",
+		"certificates/zerossl/example.com/example.com.crt",
+		"certificates.txt",
+	}
+	tt := []struct {
+		name      string
+		prefix    string
+		recursive bool
+		expected  []string
+	}{
+		{
+			name:     "immediate children",
+			prefix:   "certificates",
+			expected: []string{"certificates/acme", "certificates/zerossl"},
+		},
+		{
+			name:     "trailing slash",
+			prefix:   "certificates/acme/",
+			expected: []string{"certificates/acme/example.com", "certificates/acme/example.org"},
+		},
+		{
+			name:     "terminal keys",
+			prefix:   "certificates/acme/example.com",
+			expected: []string{"certificates/acme/example.com/example.com.crt", "certificates/acme/example.com/example.com.key"},
+		},
+		{
+			name:      "recursive",
+			prefix:    "certificates/acme",
+			recursive: true,
+			expected: []string{
+				"certificates/acme/example.com",
+				"certificates/acme/example.com/example.com.crt",
+				"certificates/acme/example.com/example.com.key",
+				"certificates/acme/example.org",
+				"certificates/acme/example.org/example.org.crt",
+			},
+		},
+		{
+			name:     "root",
+			prefix:   "",
+			expected: []string{"certificates", "certificates.txt"},
+		},
+		{
+			name:     "missing",
+			prefix:   "certificates/letsencrypt",
+			expected: nil,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, listDirectory(tc.prefix, keys, tc.recursive))
+		})
+	}
+}
+
+func TestListDirectoryInfo(t *testing.T) {
+	older := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	infos := []certmagic.KeyInfo{
+		{Key: "certificates/acme/example.com/example.com.crt", Size: 10, Modified: older, IsTerminal: true},
+		{Key: "certificates/acme/example.com/example.com.key", Size: 20, Modified: newer, IsTerminal: true},
+		{Key: "certificates/acme/README", Size: 30, Modified: older, IsTerminal: true},
+	}
+
+	entries := listDirectoryInfo("certificates/acme", infos, false)
+	assert.Equal(t, []certmagic.KeyInfo{
+		{Key: "certificates/acme/README", Size: 30, Modified: older, IsTerminal: true},
+		{Key: "certificates/acme/example.com", Modified: newer, IsTerminal: false},
+	}, entries)
+}
+
+func TestEscapeLike(t *testing.T) {
+	assert.Equal(t, "certificates/acme", escapeLike("certificates/acme"))
+	assert.Equal(t, `100\%\_done`, escapeLike("100%_done"))
+	assert.Equal(t, `back\\slash`, escapeLike(`back\slash`))
+	assert.Equal(t, "it's", escapeLike("it's"))
+}
+
+func TestDirectoryArgs(t *testing.T) {
+	tt := []struct {
+		keyPrefix string
+		prefix    string
+		directory string
+		below     string
+	}{
+		{prefix: "", directory: "", below: "_%"},
+		{prefix: "certificates/acme/", directory: "certificates/acme", below: "certificates/acme/_%"},
+		{keyPrefix: "prod/", prefix: "", directory: "prod", below: "prod/_%"},
+		{keyPrefix: "env-", prefix: "ocsp", directory: "env-ocsp", below: "env-ocsp/_%"},
+		{keyPrefix: "env_", prefix: "", directory: "", below: `env\__%`},
+	}
+	for _, tc := range tt {
+		t.Run(tc.keyPrefix+tc.prefix, func(t *testing.T) {
+			directory, below := Storage{keyPrefix: tc.keyPrefix}.directoryArgs(tc.prefix)
+			assert.Equal(t, tc.directory, directory)
+			assert.Equal(t, tc.below, below)
+		})
+	}
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	tt := []struct {
+		pattern string
+		regexp  string
+		prefix  string
+	}{
+		{pattern: "certificates/*/example.com/*", regexp: `^certificates/[^/]*/example\.com/[^/]*$`, prefix: "certificates/"},
+		{pattern: "certificates/**.crt", regexp: `^certificates/.*\.crt$`, prefix: "certificates/"},
+		{pattern: "ocsp/example.co?", regexp: `^ocsp/example\.co[^/]$`, prefix: "ocsp/example.co"},
+		{pattern: "a+b(c)", regexp: `^a\+b\(c\)$`, prefix: "a+b(c)"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.pattern, func(t *testing.T) {
+			assert.Equal(t, tc.regexp, globToRegexp(tc.pattern))
+			assert.Equal(t, tc.prefix, globPrefix(tc.pattern))
+		})
+	}
+}
+
+func TestQuoteRegexp(t *testing.T) {
+	assert.Equal(t, "prod/", quoteRegexp("prod/"))
+	assert.Equal(t, `env\.prod\*/`, quoteRegexp("env.prod*/"))
+}
@@ -0,0 +1,135 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WithLockRefresh keeps held locks alive by extending their expiry
+// to a full lock timeout (or the ttl given to LockWithTTL) every
+// interval, until Unlock is called, so a critical section outlasting
+// the lock timeout doesn't lose its lock to another instance. Locks
+// of crashed instances still expire, as nothing refreshes them. The
+// interval should be well below the lock timeout. Refreshing has no
+// effect with LockStrategyRow.
+func WithLockRefresh(interval string) Option {
+	return func(storage Storage) (Storage, error) {
+		lockRefresh, err := time.ParseDuration(interval)
+		if err != nil {
+			return storage, fmt.Errorf("invalid lock refresh: %w", err)
+		}
+		if lockRefresh <= 0 {
+			return storage, fmt.Errorf("invalid lock refresh: %s", interval)
+		}
+		storage.lockRefresh = lockRefresh
+		return storage, nil
+	}
+}
+
+// lockRefreshers tracks the refresh loops of held locks, so they can
+// be stopped when the lock is released.
+type lockRefreshers struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+func newLockRefreshers() *lockRefreshers {
+	return &lockRefreshers{cancels: make(map[string]context.CancelFunc)}
+}
+
+// startLockRefresh refreshes the lock for key, acquired with the
+// given ttl and fence token, in the background until stopLockRefresh.
+func (s Storage) startLockRefresh(key string, ttl time.Duration, fence int64) {
+	if s.lockRefresh == 0 || s.lockStrategy == LockStrategyRow {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.lockRefreshers.mu.Lock()
+	if previous, ok := s.lockRefreshers.cancels[key]; ok {
+		previous()
+	}
+	s.lockRefreshers.cancels[key] = cancel
+	s.lockRefreshers.mu.Unlock()
+
+	go s.refreshLock(ctx, key, ttl, fence)
+}
+
+// stopLockRefresh stops refreshing the lock for key, if it is.
+func (s Storage) stopLockRefresh(key string) {
+	if s.lockRefreshers == nil {
+		return
+	}
+	s.lockRefreshers.mu.Lock()
+	defer s.lockRefreshers.mu.Unlock()
+	if cancel, ok := s.lockRefreshers.cancels[key]; ok {
+		cancel()
+		delete(s.lockRefreshers.cancels, key)
+	}
+}
+
+// stopAllLockRefreshes stops refreshing every lock.
+func (s Storage) stopAllLockRefreshes() {
+	s.lockRefreshers.mu.Lock()
+	defer s.lockRefreshers.mu.Unlock()
+	for key, cancel := range s.lockRefreshers.cancels {
+		cancel()
+		delete(s.lockRefreshers.cancels, key)
+	}
+}
+
+// errLockLost is returned when refreshing a lock that has been taken
+// over or deleted in the meantime.
+var errLockLost = errors.New("lock lost")
+
+// refreshLock extends the expiry of the lock for key every refresh
+// interval until ctx is done or the lock is lost. A failed refresh is
+// retried at the next interval.
+func (s Storage) refreshLock(ctx context.Context, key string, ttl time.Duration, fence int64) {
+	ticker := time.NewTicker(s.lockRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := s.run(ctx, opDefault, func(ctx context.Context) error {
+			result, err := s.lockDB.ExecContext(ctx, s.tables(`UPDATE certmagic_locks SET expires = $4 WHERE key = $1 AND fence = $2 AND tenant = $3`), s.lockKey(key), fence, s.tenant, time.Now().Add(ttl))
+			if err != nil {
+				return fmt.Errorf("failed to refresh lock: %s: %w", key, err)
+			}
+			affected, err := result.RowsAffected()
+			if err != nil {
+				return fmt.Errorf("failed to get affected rows: %w", err)
+			}
+			if affected == 0 {
+				return fmt.Errorf("failed to refresh lock: %s: %w", key, errLockLost)
+			}
+			return nil
+		})
+		switch {
+		case err == nil || ctx.Err() != nil:
+		case errors.Is(err, errLockLost), errors.Is(err, ErrClosed), isClosedDB(err):
+			s.logger.Warn("stopped refreshing lock", zap.String("key", key), zap.Error(err))
+			s.stopLockRefresh(key)
+			return
+		default:
+			s.logger.Warn("failed to refresh lock, retrying at the next interval", zap.String("key", key), zap.Error(err))
+		}
+	}
+}
+
+// isClosedDB reports whether err comes from a connection or database
+// that has been closed, which no later refresh can recover from.
+func isClosedDB(err error) bool {
+	// database/sql doesn't export the error for a closed database
+	return errors.Is(err, sql.ErrConnDone) || strings.Contains(err.Error(), "sql: database is closed")
+}
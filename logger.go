@@ -0,0 +1,78 @@
+package certmagic_postgres
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"sync/atomic"
+	"time"
+)
+
+// WithLogger logs connection failures, retried operations, slow
+// operations, failovers and health monitor state changes to logger.
+// Nothing is logged by default.
+func WithLogger(logger *zap.Logger) Option {
+	return func(storage Storage) (Storage, error) {
+		if logger == nil {
+			return storage, fmt.Errorf("invalid logger: must not be nil")
+		}
+		storage.logger = logger
+		return storage, nil
+	}
+}
+
+// logSlowAttempt logs an attempt of an operation of the given class
+// that took more than half its timeout, as a warning that the timeout
+// may soon be reached.
+func (s Storage) logSlowAttempt(class opClass, elapsed, timeout time.Duration) {
+	if elapsed <= timeout/2 {
+		return
+	}
+	s.logger.Warn("slow storage operation",
+		zap.Stringer("class", class),
+		zap.Duration("duration", elapsed),
+		zap.Duration("timeout", timeout))
+}
+
+// handoverCore is a zapcore.Core writing to another core that can be
+// replaced, so that a storage shared across Caddy config reloads logs
+// to the logs of the config most recently using it. Cores derived
+// with With keep writing to the core current at the time.
+type handoverCore struct {
+	core atomic.Pointer[zapcore.Core]
+}
+
+func newHandoverCore(core zapcore.Core) *handoverCore {
+	c := &handoverCore{}
+	c.set(core)
+	return c
+}
+
+// set replaces the core written to.
+func (c *handoverCore) set(core zapcore.Core) {
+	c.core.Store(&core)
+}
+
+func (c *handoverCore) current() zapcore.Core {
+	return *c.core.Load()
+}
+
+func (c *handoverCore) Enabled(level zapcore.Level) bool {
+	return c.current().Enabled(level)
+}
+
+func (c *handoverCore) With(fields []zapcore.Field) zapcore.Core {
+	return c.current().With(fields)
+}
+
+func (c *handoverCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return c.current().Check(entry, checked)
+}
+
+func (c *handoverCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	return c.current().Write(entry, fields)
+}
+
+func (c *handoverCore) Sync() error {
+	return c.current().Sync()
+}
@@ -0,0 +1,86 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestWithLogger(t *testing.T) {
+	_, err := Open(nil, WithLogger(nil))
+	assert.NotNil(t, err)
+}
+
+func TestStorage_LogSlowAttempt(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	storage, err := Open(nil, WithLogger(zap.New(core)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage.logSlowAttempt(opRead, time.Second, 3*time.Second)
+	assert.Equal(t, 0, logs.Len())
+
+	storage.logSlowAttempt(opList, 2*time.Second, 3*time.Second)
+	entries := logs.All()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "slow storage operation", entries[0].Message)
+		assert.Equal(t, "list", entries[0].ContextMap()["class"])
+	}
+}
+
+func TestRetryTransient_Logs(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+	attempts := 0
+	err := retryTransient(context.Background(), policy, zap.New(core), func() error {
+		attempts++
+		return io.ErrUnexpectedEOF
+	})
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, 2, logs.FilterMessage("retrying storage operation after transient error").Len())
+}
+
+func TestHandoverCore(t *testing.T) {
+	first, firstLogs := observer.New(zapcore.InfoLevel)
+	second, secondLogs := observer.New(zapcore.WarnLevel)
+
+	core := newHandoverCore(first)
+	logger := zap.New(core)
+	logger.Info("before")
+
+	core.set(second)
+	logger.Info("filtered")
+	logger.Warn("after")
+
+	assert.Equal(t, 1, firstLogs.Len())
+	assert.Equal(t, 1, secondLogs.FilterMessage("after").Len())
+	assert.Equal(t, 1, secondLogs.Len())
+}
+
+func TestStorage_LogMigrated(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	storage, err := Open(nil, WithLogger(zap.New(core)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage.logMigrated(nil)
+	assert.Equal(t, 0, logs.Len())
+
+	storage.logMigrated([]Migration{{Version: "20200721125602_baseline"}, {Version: "20201016000000_expires"}})
+	entries := logs.All()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "migrated storage schema", entries[0].Message)
+		assert.Equal(t, int64(2), entries[0].ContextMap()["migrations"])
+		assert.Equal(t, "20201016000000_expires", entries[0].ContextMap()["version"])
+	}
+}
@@ -0,0 +1,65 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ListModifiedBefore returns the terminal keys below the directory
+// named by prefix that were last modified before the given time, in
+// key order, such as OCSP staples or certificates that haven't been
+// renewed in a while.
+func (s Storage) ListModifiedBefore(ctx context.Context, prefix string, before time.Time) ([]string, error) {
+	return runWithResult(ctx, s, opList, func(ctx context.Context) ([]string, error) {
+		query := fmt.Sprintf(s.tables(`SELECT %[1]s FROM certmagic_data WHERE modified < $2 AND %[1]s LIKE $1 ESCAPE '\' AND tenant = $3 ORDER BY %[1]s`), s.keyColumn())
+		rows, err := s.db.QueryContext(ctx, query, escapeLike(s.keyPrefix+directoryPrefix(prefix))+"%", before, s.tenant)
+		if err != nil {
+			return nil, fmt.Errorf("failed query: %w", err)
+		}
+		defer rows.Close()
+
+		var keys []string
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				return nil, fmt.Errorf("failed scan: %w", err)
+			}
+			keys = append(keys, s.trimKeyPrefix(key))
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed iterating rows: %w", err)
+		}
+		return keys, nil
+	})
+}
+
+// PruneModifiedBefore deletes the keys below the directory named by
+// prefix that were last modified before the given time, in a single
+// statement, and returns the number of keys deleted. Like DeleteAll,
+// an empty prefix is rejected rather than pruning every directory.
+func (s Storage) PruneModifiedBefore(ctx context.Context, prefix string, before time.Time) (int64, error) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return 0, fmt.Errorf("refusing to prune all keys: prefix must not be empty")
+	}
+
+	deleted, err := runWithResult(ctx, s, opWrite, func(ctx context.Context) (int64, error) {
+		query := fmt.Sprintf(s.tables(`DELETE FROM certmagic_data WHERE modified < $2 AND %[1]s LIKE $1 ESCAPE '\' AND tenant = $3`), s.keyColumn())
+		result, err := s.db.ExecContext(ctx, query, escapeLike(s.keyPrefix+directoryPrefix(prefix))+"%", before, s.tenant)
+		if err != nil {
+			return 0, fmt.Errorf("failed exec: %w", err)
+		}
+
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to get affected rows: %w", err)
+		}
+		return deleted, nil
+	})
+	if err != nil || deleted == 0 {
+		return deleted, err
+	}
+	return deleted, s.recordAudit(ctx, AuditPrune, prefix)
+}
@@ -0,0 +1,42 @@
+package certmagic_postgres_test
+
+import (
+	"context"
+	"github.com/fluidgalleries/certmagic-postgres"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestStorage_PruneModifiedBefore(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cutoff := time.Now().Add(-time.Hour)
+	err = storage.StoreWithModTime(context.Background(), "ocsp/stale", []byte("value"), cutoff.Add(-time.Hour))
+	require.Nil(t, err)
+	err = storage.StoreWithModTime(context.Background(), "certificates/stale", []byte("value"), cutoff.Add(-time.Hour))
+	require.Nil(t, err)
+	err = storage.Store(context.Background(), "ocsp/fresh", []byte("value"))
+	require.Nil(t, err)
+
+	keys, err := storage.ListModifiedBefore(context.Background(), "", cutoff)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"certificates/stale", "ocsp/stale"}, keys)
+
+	deleted, err := storage.PruneModifiedBefore(context.Background(), "ocsp", cutoff)
+	require.Nil(t, err)
+	assert.Equal(t, int64(1), deleted)
+	assert.False(t, storage.Exists(context.Background(), "ocsp/stale"))
+	assert.True(t, storage.Exists(context.Background(), "ocsp/fresh"))
+	assert.True(t, storage.Exists(context.Background(), "certificates/stale"))
+
+	_, err = storage.PruneModifiedBefore(context.Background(), "", cutoff)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,125 @@
+package certmagic_postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// metrics are shared by every storage in the process, and exposed
+// once registered by registerMetrics.
+var metrics = struct {
+	operationDuration *prometheus.HistogramVec
+	lockContentions   prometheus.Counter
+	lockWaitDuration  prometheus.Histogram
+}{
+	operationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "caddy",
+		Subsystem: "storage_postgres",
+		Name:      "operation_duration_seconds",
+		Help:      "Duration of storage operations, including retries, by class and result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"class", "result"}),
+	lockContentions: prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "caddy",
+		Subsystem: "storage_postgres",
+		Name:      "lock_contentions_total",
+		Help:      "Number of times a lock was held by someone else and had to be waited for.",
+	}),
+	lockWaitDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "caddy",
+		Subsystem: "storage_postgres",
+		Name:      "lock_wait_seconds",
+		Help:      "Time spent waiting to acquire locks.",
+		Buckets:   prometheus.DefBuckets,
+	}),
+}
+
+var (
+	registerMetricsOnce sync.Once
+	registerMetricsErr  error
+)
+
+// registerMetrics registers the metrics, along with the connection
+// pool statistics of the storages in use by Caddy, with the default
+// Prometheus registry served by Caddy's metrics endpoints.
+func registerMetrics() error {
+	registerMetricsOnce.Do(func() {
+		for _, collector := range []prometheus.Collector{metrics.operationDuration, metrics.lockContentions, metrics.lockWaitDuration, poolCollector{}} {
+			if err := prometheus.DefaultRegisterer.Register(collector); err != nil {
+				registerMetricsErr = fmt.Errorf("failed to register metrics: %w", err)
+				return
+			}
+		}
+	})
+	return registerMetricsErr
+}
+
+// observeOperation records the duration of an operation of the given
+// class, which ended with err.
+func observeOperation(class opClass, duration time.Duration, err error) {
+	result := "ok"
+	if errors.Is(err, fs.ErrNotExist) {
+		result = "not_found"
+	} else if err != nil {
+		result = "error"
+	}
+	metrics.operationDuration.WithLabelValues(class.String(), result).Observe(duration.Seconds())
+}
+
+var (
+	poolOpenDesc = prometheus.NewDesc("caddy_storage_postgres_pool_open_connections",
+		"Number of open connections, both in use and idle.", []string{"storage", "pool"}, nil)
+	poolInUseDesc = prometheus.NewDesc("caddy_storage_postgres_pool_in_use_connections",
+		"Number of connections in use.", []string{"storage", "pool"}, nil)
+	poolIdleDesc = prometheus.NewDesc("caddy_storage_postgres_pool_idle_connections",
+		"Number of idle connections.", []string{"storage", "pool"}, nil)
+	poolWaitCountDesc = prometheus.NewDesc("caddy_storage_postgres_pool_waits_total",
+		"Number of times a connection had to be waited for.", []string{"storage", "pool"}, nil)
+	poolWaitDurationDesc = prometheus.NewDesc("caddy_storage_postgres_pool_wait_seconds_total",
+		"Time spent waiting for a connection.", []string{"storage", "pool"}, nil)
+)
+
+// poolCollector collects the connection pool statistics of the
+// storages in use by Caddy, labelled with the storage ID also used by
+// the admin API, and with the pool: primary, lock or read.
+type poolCollector struct{}
+
+func (poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolOpenDesc
+	ch <- poolInUseDesc
+	ch <- poolIdleDesc
+	ch <- poolWaitCountDesc
+	ch <- poolWaitDurationDesc
+}
+
+func (poolCollector) Collect(ch chan<- prometheus.Metric) {
+	storagePool.Range(func(key, value any) bool {
+		id := key.(string)[:12]
+		storage := value.(pooledStorage).Storage
+		if storage.db == nil {
+			return true
+		}
+		collectPool(ch, id, "primary", storage.db)
+		if storage.lockDB != nil && storage.lockDB != storage.db {
+			collectPool(ch, id, "lock", storage.lockDB)
+		}
+		if storage.readDB != nil && storage.readDB != storage.db {
+			collectPool(ch, id, "read", storage.readDB)
+		}
+		return true
+	})
+}
+
+func collectPool(ch chan<- prometheus.Metric, id, pool string, db *sql.DB) {
+	stats := db.Stats()
+	ch <- prometheus.MustNewConstMetric(poolOpenDesc, prometheus.GaugeValue, float64(stats.OpenConnections), id, pool)
+	ch <- prometheus.MustNewConstMetric(poolInUseDesc, prometheus.GaugeValue, float64(stats.InUse), id, pool)
+	ch <- prometheus.MustNewConstMetric(poolIdleDesc, prometheus.GaugeValue, float64(stats.Idle), id, pool)
+	ch <- prometheus.MustNewConstMetric(poolWaitCountDesc, prometheus.CounterValue, float64(stats.WaitCount), id, pool)
+	ch <- prometheus.MustNewConstMetric(poolWaitDurationDesc, prometheus.CounterValue, stats.WaitDuration.Seconds(), id, pool)
+}
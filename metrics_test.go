@@ -0,0 +1,54 @@
+package certmagic_postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestObserveOperation(t *testing.T) {
+	count := func(class, result string) uint64 {
+		var metric dto.Metric
+		if err := metrics.operationDuration.WithLabelValues(class, result).(prometheus.Histogram).Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		return metric.GetHistogram().GetSampleCount()
+	}
+	notFound, ok, failed := count("read", "not_found"), count("write", "ok"), count("list", "error")
+
+	observeOperation(opRead, time.Millisecond, fmt.Errorf("failed to load: %w", fs.ErrNotExist))
+	observeOperation(opWrite, time.Millisecond, nil)
+	observeOperation(opList, time.Millisecond, fmt.Errorf("failed query"))
+
+	assert.Equal(t, notFound+1, count("read", "not_found"))
+	assert.Equal(t, ok+1, count("write", "ok"))
+	assert.Equal(t, failed+1, count("list", "error"))
+}
+
+func TestPoolCollector(t *testing.T) {
+	db, err := sql.Open("pgx", "postgres://localhost:1/certmagic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	storage, err := Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storagePool.LoadOrStore(strings.Repeat("a", 64), pooledStorage{Storage: storage})
+	defer storagePool.Delete(strings.Repeat("a", 64))
+
+	assert.Nil(t, testutil.CollectAndCompare(poolCollector{}, strings.NewReader(`
+# HELP caddy_storage_postgres_pool_open_connections Number of open connections, both in use and idle.
+# TYPE caddy_storage_postgres_pool_open_connections gauge
+caddy_storage_postgres_pool_open_connections{pool="primary",storage="aaaaaaaaaaaa"} 0
+`), "caddy_storage_postgres_pool_open_connections"))
+	assert.Equal(t, 5, testutil.CollectAndCount(poolCollector{}))
+}
@@ -0,0 +1,444 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"go.uber.org/zap"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// migrations holds the SQL files under db, which create and
+// update the tables used by Storage.
+//
+//go:embed db/*.sql db/cockroachdb/*.sql
+var migrations embed.FS
+
+// migrationLockID is the advisory lock serializing
+// migrations run by concurrently starting instances.
+const migrationLockID = 7350164212738291
+
+// migrateTimeout bounds running all migrations on startup.
+const migrateTimeout = time.Minute * 1
+
+// Migration is a schema change applied by Migrate.
+type Migration struct {
+	// Version identifies the migration, e.g. "20200721125602_baseline".
+	// Migrations are applied in version order.
+	Version string
+
+	// SQL is the migration's SQL.
+	SQL string
+}
+
+// MigrateOption configures a call to Migrate.
+type MigrateOption func(*migrateOptions)
+
+type migrateOptions struct {
+	dryRun           bool
+	unlogged         bool
+	keyCollation     string
+	valueStorage     string
+	valueCompression string
+	rowLevelSecurity bool
+}
+
+// unloggedTables are the tables made unlogged by MigrateUnlogged,
+// referencing tables first.
+var unloggedTables = []string{"certmagic_blobs", "certmagic_locks", "certmagic_data"}
+
+// MigrateDryRun makes Migrate return the migrations it would
+// apply without applying them, e.g. to have them reviewed first.
+// Pass them to MigrationScript for the SQL to execute.
+func MigrateDryRun() MigrateOption {
+	return func(options *migrateOptions) {
+		options.dryRun = true
+	}
+}
+
+// MigrateUnlogged makes Migrate turn the tables used by Storage into
+// UNLOGGED tables once migrations are applied. Writes to unlogged
+// tables skip the write-ahead log, so they are considerably faster,
+// but the tables are emptied after a crash and aren't replicated.
+// Only use it for CI, staging and other throwaway environments.
+// Unlogged tables are only supported on PostgreSQL itself and can't
+// be combined with PartitionData.
+func MigrateUnlogged() MigrateOption {
+	return func(options *migrateOptions) {
+		options.unlogged = true
+	}
+}
+
+// WithUnloggedTables makes every call to Migrate, including the one
+// made by WithAutoMigrate, behave as if passed MigrateUnlogged.
+func WithUnloggedTables() Option {
+	return func(storage Storage) (Storage, error) {
+		storage.unloggedTables = true
+		return storage, nil
+	}
+}
+
+// WithAutoMigrate applies pending migrations with Migrate when
+// connecting, so the tables used by Storage don't need to be created
+// or updated by hand beforehand.
+func WithAutoMigrate() Option {
+	return func(storage Storage) (Storage, error) {
+		storage.autoMigrate = true
+		return storage, nil
+	}
+}
+
+// migrateDefaults returns the options Migrate starts from,
+// as configured on the storage.
+func (s Storage) migrateDefaults() migrateOptions {
+	return migrateOptions{
+		unlogged:         s.unloggedTables,
+		keyCollation:     s.keyCollation,
+		valueStorage:     s.valueStorage,
+		valueCompression: s.valueCompression,
+		rowLevelSecurity: s.rowLevelSecurity,
+	}
+}
+
+// loadMigrations returns the embedded up migrations for dialect in
+// version order. Migrations under db/cockroachdb replace those of the
+// same version for DialectCockroachDB.
+func loadMigrations(dialect string) ([]Migration, error) {
+	return readMigrations(dialect, ".up.sql")
+}
+
+// loadDownMigrations is loadMigrations for the down migrations,
+// which revert the up migrations of the same version.
+func loadDownMigrations(dialect string) ([]Migration, error) {
+	return readMigrations(dialect, ".down.sql")
+}
+
+// readMigrations returns the embedded migrations for dialect whose
+// file names end in suffix, in version order.
+func readMigrations(dialect, suffix string) ([]Migration, error) {
+	names, err := fs.Glob(migrations, "db/*"+suffix)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	var all []Migration
+	for _, name := range names {
+		if dialect == DialectCockroachDB {
+			override := path.Join("db", "cockroachdb", path.Base(name))
+			if _, err := fs.Stat(migrations, override); err == nil {
+				name = override
+			}
+		}
+
+		query, err := migrations.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", name, err)
+		}
+		all = append(all, Migration{
+			Version: strings.TrimSuffix(path.Base(name), suffix),
+			SQL:     string(query),
+		})
+	}
+	return all, nil
+}
+
+// Migrate applies, in a single transaction and in version order, every
+// embedded migration not yet recorded in the certmagic_schema_version
+// table, and returns the migrations applied. Instances migrating
+// concurrently wait for each other. Databases set up before versions
+// were recorded are brought up to date as well, since every migration
+// is safe to apply to a schema that already has its changes.
+//
+// With MigrateDryRun, the pending migrations are returned without
+// changing the database.
+func (s Storage) Migrate(ctx context.Context, options ...MigrateOption) ([]Migration, error) {
+	opts := s.migrateDefaults()
+	for _, option := range options {
+		option(&opts)
+	}
+	if opts.valueStorage != "" {
+		if err := checkValueStorage(opts.valueStorage); err != nil {
+			return nil, err
+		}
+	}
+	if opts.valueCompression != "" {
+		if err := checkValueCompression(opts.valueCompression); err != nil {
+			return nil, err
+		}
+	}
+	if opts.unlogged && s.isDistributed() {
+		return nil, fmt.Errorf("unlogged tables are not supported on %s", s.dialect)
+	}
+	if opts.keyCollation != "" && s.isDistributed() {
+		return nil, fmt.Errorf("key collations are not supported on %s", s.dialect)
+	}
+	if (opts.valueStorage != "" || opts.valueCompression != "") && s.isDistributed() {
+		return nil, fmt.Errorf("value storage settings are not supported on %s", s.dialect)
+	}
+	if opts.rowLevelSecurity && s.isDistributed() {
+		return nil, fmt.Errorf("row level security is not supported on %s", s.dialect)
+	}
+
+	all, err := loadMigrations(s.dialect)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+	all = s.migrationTables(all)
+
+	applied, err := s.applyMigrations(ctx, all, opts)
+	if isInsufficientPrivilege(err) {
+		return nil, s.privilegeError(ctx, all, opts, err)
+	}
+	return applied, err
+}
+
+// applyMigrations applies the pending migrations of all along with
+// the settings in opts, returning the migrations it applied.
+func (s Storage) applyMigrations(ctx context.Context, all []Migration, opts migrateOptions) ([]Migration, error) {
+	if s.isDistributed() {
+		return s.migrateStatements(ctx, all, opts)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := s.liftStatementTimeout(ctx, tx); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+		return nil, fmt.Errorf("failed to lock migrations: %w", err)
+	}
+	if s.schema != "" && !opts.dryRun {
+		if _, err := tx.ExecContext(ctx, `CREATE SCHEMA IF NOT EXISTS `+quoteIdentifier(s.schema)); err != nil {
+			return nil, fmt.Errorf("failed to create schema: %w", err)
+		}
+	}
+
+	applied, err := appliedVersions(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkTableNames(ctx, tx, applied); err != nil {
+		return nil, err
+	}
+	pending := pendingMigrations(all, applied)
+
+	if opts.dryRun {
+		return pending, nil
+	}
+
+	for _, migration := range pending {
+		if _, err := tx.ExecContext(ctx, migration.SQL); err != nil {
+			return nil, fmt.Errorf("failed to apply migration %s: %w", migration.Version, err)
+		}
+		s.logger.Info("applied migration", zap.String("version", migration.Version))
+	}
+
+	if opts.keyCollation != "" {
+		if err := applyKeyCollation(ctx, s.withTables(tx), opts.keyCollation); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyValueSettings(ctx, s.withTables(tx), opts); err != nil {
+		return nil, err
+	}
+
+	if opts.rowLevelSecurity {
+		if err := applyRowLevelSecurity(ctx, s.withTables(tx)); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.unlogged {
+		// Tables already unlogged are left as they are
+		for _, statement := range unloggedStatements() {
+			if _, err := tx.ExecContext(ctx, s.tables(statement)); err != nil {
+				return nil, fmt.Errorf("failed to make tables unlogged: %w", err)
+			}
+		}
+	}
+
+	// Record versions last, as the version table is itself created by a migration
+	for _, migration := range pending {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO certmagic_schema_version (version) VALUES ($1)`, migration.Version); err != nil {
+			return nil, fmt.Errorf("failed to record migration %s: %w", migration.Version, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit migrations: %w", err)
+	}
+	s.logMigrated(pending)
+	return pending, nil
+}
+
+// migrateStatements is Migrate for CockroachDB and YugabyteDB, which
+// can neither reliably change schemas within a larger transaction nor
+// take advisory locks. Every statement runs in its own transaction
+// instead, and versions are recorded once all pending migrations have
+// been applied.
+func (s Storage) migrateStatements(ctx context.Context, all []Migration, opts migrateOptions) ([]Migration, error) {
+	applied, err := appliedVersions(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkTableNames(ctx, s.db, applied); err != nil {
+		return nil, err
+	}
+	pending := pendingMigrations(all, applied)
+
+	if opts.dryRun {
+		return pending, nil
+	}
+
+	for _, migration := range pending {
+		for _, statement := range splitStatements(migration.SQL) {
+			if _, err := s.db.ExecContext(ctx, statement); err != nil {
+				return nil, fmt.Errorf("failed to apply migration %s: %w", migration.Version, err)
+			}
+		}
+		s.logger.Info("applied migration", zap.String("version", migration.Version))
+	}
+
+	for _, migration := range pending {
+		// Another instance may have applied the same migrations concurrently
+		if _, err := s.db.ExecContext(ctx, `INSERT INTO certmagic_schema_version (version) VALUES ($1) ON CONFLICT (version) DO NOTHING`, migration.Version); err != nil {
+			return nil, fmt.Errorf("failed to record migration %s: %w", migration.Version, err)
+		}
+	}
+	s.logMigrated(pending)
+	return pending, nil
+}
+
+// logMigrated logs the migrations applied, if any.
+func (s Storage) logMigrated(applied []Migration) {
+	if len(applied) == 0 {
+		return
+	}
+	s.logger.Info("migrated storage schema",
+		zap.Int("migrations", len(applied)),
+		zap.String("version", applied[len(applied)-1].Version))
+}
+
+// appliedVersions returns the versions recorded in the
+// certmagic_schema_version table, if it exists.
+func appliedVersions(ctx context.Context, q querier) (map[string]bool, error) {
+	applied := make(map[string]bool)
+	var hasVersions bool
+	if err := q.QueryRowContext(ctx, `SELECT to_regclass('certmagic_schema_version') IS NOT NULL`).Scan(&hasVersions); err != nil {
+		return nil, fmt.Errorf("failed scan: %w", err)
+	}
+	if !hasVersions {
+		return applied, nil
+	}
+
+	rows, err := q.QueryContext(ctx, `SELECT version FROM certmagic_schema_version`)
+	if err != nil {
+		return nil, fmt.Errorf("failed query: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, fmt.Errorf("failed scan: %w", err)
+		}
+		applied[version] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed iterating rows: %w", err)
+	}
+	return applied, nil
+}
+
+// pendingMigrations returns the migrations in all whose versions aren't in applied.
+func pendingMigrations(all []Migration, applied map[string]bool) []Migration {
+	var pending []Migration
+	for _, migration := range all {
+		if !applied[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	return pending
+}
+
+// splitStatements splits a migration into its statements, which are
+// terminated by a semicolon at the end of a line. Semicolons within
+// dollar quoted bodies, such as those of DO blocks, are left alone.
+func splitStatements(query string) []string {
+	var statements []string
+	var current strings.Builder
+	quoted := false
+	for _, line := range strings.SplitAfter(query, "\n") {
+		current.WriteString(line)
+		if strings.Count(line, "$$")%2 == 1 {
+			quoted = !quoted
+		}
+		if !quoted && strings.HasSuffix(strings.TrimSpace(line), ";") {
+			statements = appendStatement(statements, current.String())
+			current.Reset()
+		}
+	}
+	return appendStatement(statements, current.String())
+}
+
+// appendStatement appends statement to statements without
+// its terminating semicolon, unless it is empty.
+func appendStatement(statements []string, statement string) []string {
+	statement = strings.TrimSuffix(strings.TrimSpace(statement), ";")
+	if statement == "" {
+		return statements
+	}
+	return append(statements, statement)
+}
+
+// unloggedStatements returns the statements making the
+// tables used by Storage unlogged.
+func unloggedStatements() []string {
+	var statements []string
+	for _, table := range unloggedTables {
+		statements = append(statements, fmt.Sprintf(`ALTER TABLE %s SET UNLOGGED`, table))
+	}
+	return statements
+}
+
+// MigrationScript returns a single SQL script applying migrations
+// and recording them in the certmagic_schema_version table within
+// one transaction, as Migrate would, for review by or hand-off to a
+// database administrator.
+func MigrationScript(migrations []Migration) string {
+	return migrationScript(migrations, nil)
+}
+
+// migrationScript is MigrationScript, also executing statements
+// after the migrations.
+func migrationScript(migrations []Migration, statements []string) string {
+	var b strings.Builder
+	b.WriteString("BEGIN;\n")
+	for _, migration := range migrations {
+		fmt.Fprintf(&b, "\n-- %s\n%s\n", migration.Version, strings.TrimRight(migration.SQL, ";\n")+";")
+	}
+	if len(statements) > 0 {
+		b.WriteString("\n")
+	}
+	for _, statement := range statements {
+		fmt.Fprintf(&b, "%s;\n", statement)
+	}
+	if len(migrations) > 0 {
+		b.WriteString("\n")
+	}
+	for _, migration := range migrations {
+		fmt.Fprintf(&b, "INSERT INTO certmagic_schema_version (version) VALUES ('%s');\n", strings.ReplaceAll(migration.Version, "'", "''"))
+	}
+	b.WriteString("\nCOMMIT;\n")
+	return b.String()
+}
@@ -0,0 +1,125 @@
+package certmagic_postgres
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	all, err := loadMigrations(DialectPostgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err := filepath.Glob("db/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	assert.Len(t, all, len(paths))
+	for i, migration := range all {
+		assert.Equal(t, strings.TrimSuffix(filepath.Base(paths[i]), ".up.sql"), migration.Version)
+		assert.NotEmpty(t, migration.SQL)
+	}
+	assert.Equal(t, "20200721125602_baseline", all[0].Version)
+}
+
+func TestMigrationScript(t *testing.T) {
+	script := MigrationScript([]Migration{
+		{Version: "1_a", SQL: "CREATE TABLE a (x int);"},
+		{Version: "2_b", SQL: "CREATE TABLE b (x int)"},
+	})
+	assert.Equal(t, `BEGIN;
+
+-- 1_a
+CREATE TABLE a (x int);
+
+-- 2_b
+CREATE TABLE b (x int);
+
+INSERT INTO certmagic_schema_version (version) VALUES ('1_a');
+INSERT INTO certmagic_schema_version (version) VALUES ('2_b');
+
+COMMIT;
+`, script)
+}
+
+func TestLoadMigrations_CockroachDB(t *testing.T) {
+	postgres, err := loadMigrations(DialectPostgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cockroach, err := loadMigrations(DialectCockroachDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, cockroach, len(postgres))
+	for i, migration := range cockroach {
+		assert.Equal(t, postgres[i].Version, migration.Version)
+		assert.NotContains(t, migration.SQL, "DO $$")
+		assert.NotContains(t, migration.SQL, "text_pattern_ops")
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	statements := splitStatements("ALTER TABLE a\n  ADD COLUMN b text;\n\nUPDATE a SET b = '';\n\nALTER TABLE a ALTER COLUMN b SET NOT NULL;")
+	assert.Equal(t, []string{
+		"ALTER TABLE a\n  ADD COLUMN b text",
+		"UPDATE a SET b = ''",
+		"ALTER TABLE a ALTER COLUMN b SET NOT NULL",
+	}, statements)
+
+	statements = splitStatements("DO $$\nBEGIN\n  UPDATE a SET b = '';\n  UPDATE a SET c = '';\nEND\n$$;\n\nDROP TABLE b;")
+	assert.Equal(t, []string{
+		"DO $$\nBEGIN\n  UPDATE a SET b = '';\n  UPDATE a SET c = '';\nEND\n$$",
+		"DROP TABLE b",
+	}, statements)
+}
+
+func TestLoadDownMigrations(t *testing.T) {
+	for _, dialect := range []string{DialectPostgres, DialectCockroachDB} {
+		up, err := loadMigrations(dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		down, err := loadDownMigrations(dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Len(t, down, len(up))
+		for i, migration := range down {
+			assert.Equal(t, up[i].Version, migration.Version)
+		}
+	}
+}
+
+func TestRevertedMigrations(t *testing.T) {
+	all := []Migration{{Version: "1_a"}, {Version: "2_b"}, {Version: "3_c"}, {Version: "4_d"}}
+	applied := map[string]bool{"1_a": true, "2_b": true, "3_c": true}
+
+	reverted := revertedMigrations(all, applied, "1_a")
+	assert.Equal(t, []Migration{{Version: "3_c"}, {Version: "2_b"}}, reverted)
+	assert.Empty(t, revertedMigrations(all, applied, "3_c"))
+}
+
+func TestMigrations_CurrentSchema(t *testing.T) {
+	up, err := loadMigrations(DialectPostgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	down, err := loadDownMigrations(DialectPostgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Catalog lookups must not find the tables of other schemas
+	for _, migration := range append(up, down...) {
+		lookups := strings.Count(migration.SQL, "FROM information_schema.columns WHERE")
+		assert.Equal(t, lookups, strings.Count(migration.SQL, "FROM information_schema.columns WHERE table_schema = current_schema()"), migration.Version)
+	}
+}
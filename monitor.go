@@ -0,0 +1,117 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// WithHealthMonitor pings the database every interval in the
+// background. Once threshold pings in a row have failed, every
+// operation fails immediately with an error matching ErrUnavailable,
+// instead of waiting for its timeout, until a ping succeeds again.
+func WithHealthMonitor(interval time.Duration, threshold int) Option {
+	return func(storage Storage) (Storage, error) {
+		if interval <= 0 {
+			return storage, fmt.Errorf("invalid health monitor interval: %s", interval)
+		}
+		if threshold < 1 {
+			return storage, fmt.Errorf("invalid health monitor threshold: %d", threshold)
+		}
+		storage.healthInterval = interval
+		storage.healthThreshold = threshold
+		return storage, nil
+	}
+}
+
+// circuitBreaker tracks consecutive failed pings, shared between
+// copies of the Storage.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	failures  int
+	lastErr   error
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+func newCircuitBreaker(threshold int) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, stop: make(chan struct{})}
+}
+
+// record counts the outcome of a ping, returning whether the breaker
+// was open before and is open now.
+func (b *circuitBreaker) record(err error) (bool, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasOpen := b.failures >= b.threshold
+	if err == nil {
+		b.failures = 0
+		b.lastErr = nil
+		return wasOpen, false
+	}
+	b.failures++
+	b.lastErr = err
+	return wasOpen, b.failures >= b.threshold
+}
+
+// check returns an error matching ErrUnavailable while the breaker is open.
+func (b *circuitBreaker) check() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return nil
+	}
+	return fmt.Errorf("%w: %d failed pings: %w", ErrUnavailable, b.failures, b.lastErr)
+}
+
+// lastError returns the error of the last ping if it failed.
+func (b *circuitBreaker) lastError() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lastErr
+}
+
+// close stops the monitor.
+func (b *circuitBreaker) close() {
+	b.stopOnce.Do(func() {
+		close(b.stop)
+	})
+}
+
+// startHealthMonitor starts pinging the database in the background
+// if a health monitor is configured. It is stopped by Close.
+func (s *Storage) startHealthMonitor() {
+	if s.healthInterval == 0 || s.db == nil {
+		return
+	}
+	s.breaker = newCircuitBreaker(s.healthThreshold)
+	go s.monitorHealth()
+}
+
+func (s Storage) monitorHealth() {
+	ticker := time.NewTicker(s.healthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.breaker.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), s.queryTimeout)
+			err := s.db.PingContext(ctx)
+			cancel()
+			wasOpen, isOpen := s.breaker.record(err)
+			switch {
+			case isOpen && !wasOpen:
+				s.logger.Error("database unavailable, failing storage operations until it recovers", zap.Error(err))
+			case wasOpen && !isOpen:
+				s.logger.Info("database available again")
+			case err != nil:
+				s.logger.Warn("database health check failed", zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	breaker := newCircuitBreaker(2)
+	assert.Nil(t, breaker.check())
+
+	wasOpen, isOpen := breaker.record(errors.New("boom"))
+	assert.False(t, wasOpen)
+	assert.False(t, isOpen)
+	assert.Nil(t, breaker.check())
+
+	wasOpen, isOpen = breaker.record(errors.New("boom"))
+	assert.False(t, wasOpen)
+	assert.True(t, isOpen)
+	assert.ErrorIs(t, breaker.check(), ErrUnavailable)
+
+	wasOpen, isOpen = breaker.record(nil)
+	assert.True(t, wasOpen)
+	assert.False(t, isOpen)
+	assert.Nil(t, breaker.check())
+}
+
+func TestWithHealthMonitor(t *testing.T) {
+	_, err := Open(nil, WithHealthMonitor(0, 3))
+	assert.NotNil(t, err)
+
+	_, err = Open(nil, WithHealthMonitor(time.Second, 0))
+	assert.NotNil(t, err)
+}
+
+func TestStorage_HealthMonitor(t *testing.T) {
+	// Nothing listens on port 1, so every ping fails
+	db, err := sql.Open("pgx", "postgres://localhost:1/certmagic?connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err := Open(db, WithHealthMonitor(time.Millisecond*10, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	assert.Eventually(t, func() bool {
+		_, err := storage.Load(context.Background(), "key")
+		return errors.Is(err, ErrUnavailable)
+	}, time.Second*5, time.Millisecond*10)
+}
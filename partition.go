@@ -0,0 +1,86 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// HashPartitionMigration returns a migration converting certmagic_data
+// into a table hash partitioned by key into the given number of
+// partitions, copying over existing data. Lookups by key, which is
+// what certmagic mostly does, are pruned to a single partition, so
+// very large installations keep small indexes per partition.
+//
+// The primary key and every conflict target must contain the
+// partition key, so partitioning by key prefix is not offered.
+func HashPartitionMigration(partitions int) (Migration, error) {
+	if partitions < 2 {
+		return Migration{}, fmt.Errorf("invalid partition count: %d", partitions)
+	}
+
+	var b strings.Builder
+	b.WriteString("ALTER TABLE certmagic_blobs DROP CONSTRAINT certmagic_blobs_data_fkey;\n\n")
+	b.WriteString("ALTER TABLE certmagic_data RENAME TO certmagic_data_unpartitioned;\n\n")
+	b.WriteString("ALTER TABLE certmagic_data_unpartitioned RENAME CONSTRAINT certmagic_data_pkey TO certmagic_data_unpartitioned_pkey;\n\n")
+	b.WriteString("ALTER INDEX IF EXISTS certmagic_data_original_key_idx RENAME TO certmagic_data_unpartitioned_original_key_idx;\n\n")
+	b.WriteString("ALTER INDEX IF EXISTS certmagic_data_modified_idx RENAME TO certmagic_data_unpartitioned_modified_idx;\n\n")
+	b.WriteString("ALTER INDEX IF EXISTS certmagic_data_directory_idx RENAME TO certmagic_data_unpartitioned_directory_idx;\n\n")
+	b.WriteString("CREATE TABLE certmagic_data (\n  LIKE certmagic_data_unpartitioned INCLUDING DEFAULTS INCLUDING CONSTRAINTS INCLUDING GENERATED,\n  PRIMARY KEY (tenant, key)\n) PARTITION BY HASH (key);\n\n")
+	for i := 0; i < partitions; i++ {
+		fmt.Fprintf(&b, "CREATE TABLE certmagic_data_p%d PARTITION OF certmagic_data FOR VALUES WITH (MODULUS %d, REMAINDER %d);\n", i, partitions, i)
+	}
+	b.WriteString("\nCREATE INDEX certmagic_data_original_key_idx ON certmagic_data (original_key) WHERE original_key IS NOT NULL;\n\n")
+	b.WriteString("CREATE INDEX certmagic_data_modified_idx ON certmagic_data (tenant, modified);\n\n")
+	b.WriteString("CREATE INDEX certmagic_data_directory_idx ON certmagic_data (tenant, directory text_pattern_ops);\n\n")
+	b.WriteString("INSERT INTO certmagic_data (tenant, key, value, modified, created_at, original_key, external) SELECT tenant, key, value, modified, created_at, original_key, external FROM certmagic_data_unpartitioned;\n\n")
+	b.WriteString("DROP TABLE certmagic_data_unpartitioned;\n\n")
+	b.WriteString("ALTER TABLE certmagic_blobs ADD CONSTRAINT certmagic_blobs_data_fkey FOREIGN KEY (tenant, key) REFERENCES certmagic_data (tenant, key) ON DELETE CASCADE;")
+
+	return Migration{
+		Version: fmt.Sprintf("partition_hash_%d", partitions),
+		SQL:     b.String(),
+	}, nil
+}
+
+// PartitionData applies HashPartitionMigration with the given number
+// of partitions in a single transaction, unless certmagic_data is
+// already partitioned. Writes are blocked while data is copied.
+// Partitioning is only supported on PostgreSQL itself.
+func (s Storage) PartitionData(ctx context.Context, partitions int) error {
+	if s.isDistributed() {
+		return fmt.Errorf("partitioning is not supported on %s", s.dialect)
+	}
+
+	migration, err := HashPartitionMigration(partitions)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := s.liftStatementTimeout(ctx, tx); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("failed to lock migrations: %w", err)
+	}
+
+	var partitioned bool
+	if err := tx.QueryRowContext(ctx, s.tables(`SELECT EXISTS (SELECT 1 FROM pg_partitioned_table WHERE partrelid = to_regclass('certmagic_data'))`)).Scan(&partitioned); err != nil {
+		return fmt.Errorf("failed scan: %w", err)
+	}
+	if partitioned {
+		return fmt.Errorf("%s is already partitioned", s.tableName(defaultDataTable))
+	}
+
+	if _, err := tx.ExecContext(ctx, s.tables(migration.SQL)); err != nil {
+		return fmt.Errorf("failed to apply migration %s: %w", migration.Version, err)
+	}
+
+	return tx.Commit()
+}
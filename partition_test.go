@@ -0,0 +1,24 @@
+package certmagic_postgres
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestHashPartitionMigration(t *testing.T) {
+	migration, err := HashPartitionMigration(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "partition_hash_4", migration.Version)
+	assert.Contains(t, migration.SQL, "PARTITION BY HASH (key)")
+	assert.Contains(t, migration.SQL, "FOR VALUES WITH (MODULUS 4, REMAINDER 3)")
+	assert.NotContains(t, migration.SQL, "REMAINDER 4")
+	assert.True(t, strings.HasPrefix(migration.SQL, "ALTER TABLE certmagic_blobs DROP CONSTRAINT certmagic_blobs_data_fkey;"))
+	assert.Contains(t, migration.SQL, "DROP TABLE certmagic_data_unpartitioned;")
+	assert.Contains(t, migration.SQL, "CREATE INDEX certmagic_data_modified_idx ON certmagic_data (tenant, modified);")
+
+	_, err = HashPartitionMigration(1)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,55 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PasswordFunc returns the password to authenticate a new connection
+// to the database at host and port as user, such as a short-lived
+// token issued by a cloud provider.
+type PasswordFunc func(ctx context.Context, host string, port uint16, user string) (string, error)
+
+// WithPasswordFunc has Connect authenticate every new connection with
+// the password returned by fn, in place of any password in the
+// connection string. Connections opened later, e.g. after the pool
+// replaced a broken one, get a fresh password.
+func WithPasswordFunc(fn PasswordFunc) Option {
+	return func(storage Storage) (Storage, error) {
+		if fn == nil {
+			return storage, fmt.Errorf("invalid password func: must not be nil")
+		}
+		storage.passwordFunc = fn
+		return storage, nil
+	}
+}
+
+// cachedPasswordFunc returns a PasswordFunc reusing the passwords
+// returned by fn for ttl, so tokens are only requested again shortly
+// before they expire rather than for every connection.
+func cachedPasswordFunc(fn PasswordFunc, ttl time.Duration) PasswordFunc {
+	type cached struct {
+		password string
+		expires  time.Time
+	}
+	var mu sync.Mutex
+	cache := make(map[string]cached)
+
+	return func(ctx context.Context, host string, port uint16, user string) (string, error) {
+		key := fmt.Sprintf("%s:%d/%s", host, port, user)
+		mu.Lock()
+		defer mu.Unlock()
+
+		if c, ok := cache[key]; ok && time.Now().Before(c.expires) {
+			return c.password, nil
+		}
+		password, err := fn(ctx, host, port, user)
+		if err != nil {
+			return "", err
+		}
+		cache[key] = cached{password: password, expires: time.Now().Add(ttl)}
+		return password, nil
+	}
+}
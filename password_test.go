@@ -0,0 +1,41 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCachedPasswordFunc(t *testing.T) {
+	calls := 0
+	fn := cachedPasswordFunc(func(ctx context.Context, host string, port uint16, user string) (string, error) {
+		calls++
+		return fmt.Sprintf("%s-%d", user, calls), nil
+	}, time.Hour)
+
+	password, err := fn(context.Background(), "db.example.com", 5432, "caddy")
+	assert.Nil(t, err)
+	assert.Equal(t, "caddy-1", password)
+	password, err = fn(context.Background(), "db.example.com", 5432, "caddy")
+	assert.Nil(t, err)
+	assert.Equal(t, "caddy-1", password)
+
+	password, err = fn(context.Background(), "db.example.com", 5432, "admin")
+	assert.Nil(t, err)
+	assert.Equal(t, "admin-2", password)
+
+	expiring := cachedPasswordFunc(func(ctx context.Context, host string, port uint16, user string) (string, error) {
+		calls++
+		return fmt.Sprintf("%s-%d", user, calls), nil
+	}, 0)
+	first, _ := expiring(context.Background(), "db.example.com", 5432, "caddy")
+	second, _ := expiring(context.Background(), "db.example.com", 5432, "caddy")
+	assert.NotEqual(t, first, second)
+}
+
+func TestWithPasswordFunc(t *testing.T) {
+	_, err := Open(nil, WithPasswordFunc(nil))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,235 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"io"
+)
+
+// OpenPool creates a Storage sharing pool, which remains owned by the
+// caller: closing the Storage doesn't close it. Connections are only
+// taken from the pool for the duration of an operation, or while a
+// row lock is held. As with Open, connection options don't apply.
+func OpenPool(pool *pgxpool.Pool, options ...Option) (Storage, error) {
+	if pool == nil {
+		return Storage{}, fmt.Errorf("invalid pool: must not be nil")
+	}
+
+	db := sql.OpenDB(poolConnector{pool: pool})
+	// Hand connections back to the pool rather than keeping them idle
+	db.SetMaxIdleConns(0)
+
+	storage, err := Open(db, options...)
+	if err != nil {
+		db.Close()
+		return Storage{}, err
+	}
+	return storage, nil
+}
+
+// poolConnector is a driver.Connector acquiring connections from a
+// pgxpool.Pool, which is closed along with the database if owned.
+type poolConnector struct {
+	pool  *pgxpool.Pool
+	owned bool
+}
+
+func (c poolConnector) Close() error {
+	if c.owned {
+		c.pool.Close()
+	}
+	return nil
+}
+
+func (c poolConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &poolConn{conn: conn}, nil
+}
+
+func (c poolConnector) Driver() driver.Driver {
+	return poolDriver{}
+}
+
+// poolDriver only exists to satisfy driver.Connector.
+type poolDriver struct{}
+
+func (poolDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("pool connections can only be opened through OpenPool")
+}
+
+// poolConn is a database/sql connection over a connection acquired
+// from a pgxpool.Pool, released back to the pool when closed.
+type poolConn struct {
+	conn *pgxpool.Conn
+}
+
+func (c *poolConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported on pool connections")
+}
+
+func (c *poolConn) Close() error {
+	c.conn.Release()
+	return nil
+}
+
+func (c *poolConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *poolConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var txOptions pgx.TxOptions
+	switch sql.IsolationLevel(opts.Isolation) {
+	case sql.LevelDefault:
+	case sql.LevelReadCommitted:
+		txOptions.IsoLevel = pgx.ReadCommitted
+	case sql.LevelRepeatableRead, sql.LevelSnapshot:
+		txOptions.IsoLevel = pgx.RepeatableRead
+	case sql.LevelSerializable:
+		txOptions.IsoLevel = pgx.Serializable
+	default:
+		return nil, fmt.Errorf("unsupported isolation: %v", opts.Isolation)
+	}
+	if opts.ReadOnly {
+		txOptions.AccessMode = pgx.ReadOnly
+	}
+
+	tx, err := c.conn.BeginTx(ctx, txOptions)
+	if err != nil {
+		return nil, err
+	}
+	return poolTx{tx: tx}, nil
+}
+
+func (c *poolConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	tag, err := c.conn.Exec(ctx, query, namedValueArgs(args)...)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(tag.RowsAffected()), nil
+}
+
+func (c *poolConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := c.conn.Query(ctx, query, namedValueArgs(args)...)
+	if err != nil {
+		return nil, err
+	}
+	return &poolRows{rows: rows}, nil
+}
+
+func (c *poolConn) Ping(ctx context.Context) error {
+	return c.conn.Ping(ctx)
+}
+
+// CheckNamedValue passes every argument through to pgx unconverted,
+// like the pgx database/sql driver.
+func (c *poolConn) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+func (c *poolConn) ResetSession(context.Context) error {
+	if c.conn.Conn().IsClosed() {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+func namedValueArgs(args []driver.NamedValue) []interface{} {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
+
+type poolTx struct {
+	tx pgx.Tx
+}
+
+func (t poolTx) Commit() error {
+	return t.tx.Commit(context.Background())
+}
+
+func (t poolTx) Rollback() error {
+	return t.tx.Rollback(context.Background())
+}
+
+// poolRows adapts pgx.Rows to driver.Rows.
+type poolRows struct {
+	rows    pgx.Rows
+	columns []string
+}
+
+func (r *poolRows) Columns() []string {
+	if r.columns == nil {
+		fields := r.rows.FieldDescriptions()
+		r.columns = make([]string, len(fields))
+		for i, field := range fields {
+			r.columns[i] = string(field.Name)
+		}
+	}
+	return r.columns
+}
+
+func (r *poolRows) Close() error {
+	r.rows.Close()
+	return r.rows.Err()
+}
+
+func (r *poolRows) Next(dest []driver.Value) error {
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+
+	values, err := r.rows.Values()
+	if err != nil {
+		return err
+	}
+	for i, value := range values {
+		if dest[i], err = poolValue(value); err != nil {
+			return fmt.Errorf("column %s: %w", r.Columns()[i], err)
+		}
+	}
+	return nil
+}
+
+// poolValue converts a value decoded by pgx to a driver.Value, as
+// database/sql only scans int64, float64, bool, []byte, string and
+// time.Time. pgtype values, such as the pgtype.Numeric of numeric
+// columns, are converted through their driver.Valuer.
+func poolValue(value interface{}) (driver.Value, error) {
+	switch v := value.(type) {
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case float32:
+		return float64(v), nil
+	}
+	if value == nil || driver.IsValue(value) {
+		return value, nil
+	}
+	if valuer, ok := value.(driver.Valuer); ok {
+		converted, err := valuer.Value()
+		if err != nil {
+			return nil, err
+		}
+		if converted != nil && !driver.IsValue(converted) {
+			return nil, fmt.Errorf("unsupported value type: %T", converted)
+		}
+		return converted, nil
+	}
+	return nil, fmt.Errorf("unsupported value type: %T", value)
+}
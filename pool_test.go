@@ -0,0 +1,47 @@
+package certmagic_postgres
+
+import (
+	"database/sql"
+	"github.com/jackc/pgtype"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestPoolValue(t *testing.T) {
+	now := time.Now()
+	for _, test := range []struct {
+		value    interface{}
+		expected interface{}
+	}{
+		{nil, nil},
+		{int16(1), int64(1)},
+		{int32(2), int64(2)},
+		{uint32(3), int64(3)},
+		{int64(4), int64(4)},
+		{float32(0.5), float64(0.5)},
+		{1.5, 1.5},
+		{true, true},
+		{"text", "text"},
+		{[]byte("bytes"), []byte("bytes")},
+		{now, now},
+		{pgtype.Numeric{Int: big.NewInt(1250), Exp: -3, Status: pgtype.Present}, "1250e-3"},
+		{&pgtype.Numeric{Int: big.NewInt(15), Exp: -1, Status: pgtype.Present}, "15e-1"},
+		{pgtype.Numeric{Status: pgtype.Null}, nil},
+	} {
+		value, err := poolValue(test.value)
+		assert.Nil(t, err, "%#v", test.value)
+		assert.Equal(t, test.expected, value, "%#v", test.value)
+	}
+
+	// Converted numerics scan into floats, as EXTRACT results do in Status
+	value, err := poolValue(pgtype.Numeric{Int: big.NewInt(25), Exp: -1, Status: pgtype.Present})
+	assert.Nil(t, err)
+	var lag sql.NullFloat64
+	assert.Nil(t, lag.Scan(value))
+	assert.Equal(t, 2.5, lag.Float64)
+
+	_, err = poolValue([16]byte{})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,77 @@
+package certmagic_postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// WithMaxOpenConns limits the connections Connect opens to at most n.
+// By default the number of connections is unlimited.
+func WithMaxOpenConns(n int) Option {
+	return func(storage Storage) (Storage, error) {
+		if n < 1 {
+			return storage, fmt.Errorf("invalid max open connections: %d", n)
+		}
+		storage.maxOpenConns = n
+		return storage, nil
+	}
+}
+
+// WithMaxIdleConns keeps at most n idle connections open for reuse.
+// Zero closes connections as soon as they are idle. Defaults to 2.
+func WithMaxIdleConns(n int) Option {
+	return func(storage Storage) (Storage, error) {
+		if n < 0 {
+			return storage, fmt.Errorf("invalid max idle connections: %d", n)
+		}
+		storage.maxIdleConns = &n
+		return storage, nil
+	}
+}
+
+// WithConnMaxLifetime closes connections once they are that old, so
+// that they are spread over new servers behind a load balancer.
+func WithConnMaxLifetime(lifetime string) Option {
+	return func(storage Storage) (Storage, error) {
+		connMaxLifetime, err := time.ParseDuration(lifetime)
+		if err != nil {
+			return storage, fmt.Errorf("invalid connection max lifetime: %w", err)
+		}
+		storage.connMaxLifetime = connMaxLifetime
+		return storage, nil
+	}
+}
+
+// WithConnMaxIdleTime closes connections once they have been idle
+// that long.
+func WithConnMaxIdleTime(idleTime string) Option {
+	return func(storage Storage) (Storage, error) {
+		connMaxIdleTime, err := time.ParseDuration(idleTime)
+		if err != nil {
+			return storage, fmt.Errorf("invalid connection max idle time: %w", err)
+		}
+		storage.connMaxIdleTime = connMaxIdleTime
+		return storage, nil
+	}
+}
+
+// configurePool applies the pool settings to db, unless they were
+// applied to the pgxpool it takes its connections from.
+func (s Storage) configurePool(db *sql.DB) {
+	if s.driver == DriverPgxpool {
+		return
+	}
+	if s.maxOpenConns > 0 {
+		db.SetMaxOpenConns(s.maxOpenConns)
+	}
+	if s.maxIdleConns != nil {
+		db.SetMaxIdleConns(*s.maxIdleConns)
+	}
+	if s.connMaxLifetime > 0 {
+		db.SetConnMaxLifetime(s.connMaxLifetime)
+	}
+	if s.connMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(s.connMaxIdleTime)
+	}
+}
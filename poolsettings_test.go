@@ -0,0 +1,27 @@
+package certmagic_postgres
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestStorage_ConfigurePool(t *testing.T) {
+	storage, err := Open(nil, WithMaxOpenConns(20), WithMaxIdleConns(0), WithConnMaxLifetime("30m"), WithConnMaxIdleTime("5m"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("pgx", "postgres://localhost/certmagic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	storage.configurePool(db)
+	assert.Equal(t, 20, db.Stats().MaxOpenConnections)
+
+	for _, option := range []Option{WithMaxOpenConns(0), WithMaxIdleConns(-1), WithConnMaxLifetime("forever"), WithConnMaxIdleTime("5")} {
+		_, err := Open(nil, option)
+		assert.NotNil(t, err)
+	}
+}
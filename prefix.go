@@ -0,0 +1,28 @@
+package certmagic_postgres
+
+import (
+	"strings"
+)
+
+// WithKeyPrefix transparently prepends prefix, such as "prod/", to
+// every key written and locked, and strips it from keys read and
+// listed, so several environments or applications can share the
+// certmagic_data and certmagic_locks tables without seeing each
+// other's keys or contending for each other's locks.
+func WithKeyPrefix(prefix string) Option {
+	return func(storage Storage) (Storage, error) {
+		storage.keyPrefix = prefix
+		return storage, nil
+	}
+}
+
+// lockKey returns the key of the lock for key in the database.
+func (s Storage) lockKey(key string) string {
+	return s.keyPrefix + key
+}
+
+// trimKeyPrefix returns the key read from the database
+// as seen by callers, without the key prefix.
+func (s Storage) trimKeyPrefix(key string) string {
+	return strings.TrimPrefix(key, s.keyPrefix)
+}
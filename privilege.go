@@ -0,0 +1,44 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+)
+
+// PrivilegeError is returned by Migrate when the role lacks the
+// privileges to change the schema, such as when the storage connects
+// as an application role without DDL grants. SQL is the script
+// applying the pending migrations, for a database administrator to
+// run instead.
+type PrivilegeError struct {
+	Err error
+	SQL string
+}
+
+func (e *PrivilegeError) Error() string {
+	return fmt.Sprintf("insufficient privileges to migrate: %v; have a database administrator run the following SQL instead, or grant the role the privileges to change the schema:\n\n%s", e.Err, e.SQL)
+}
+
+func (e *PrivilegeError) Unwrap() error {
+	return e.Err
+}
+
+// isInsufficientPrivilege reports whether err is PostgreSQL's
+// insufficient_privilege error.
+func isInsufficientPrivilege(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "42501"
+}
+
+// privilegeError returns a PrivilegeError for err, with the script
+// applying the migrations of all not yet recorded as applied, or all
+// of them if the applied versions can't be read either.
+func (s Storage) privilegeError(ctx context.Context, all []Migration, opts migrateOptions, err error) error {
+	pending := all
+	if applied, err := appliedVersions(ctx, s.db); err == nil {
+		pending = pendingMigrations(all, applied)
+	}
+	return &PrivilegeError{Err: err, SQL: s.script(pending, opts)}
+}
@@ -0,0 +1,24 @@
+package certmagic_postgres
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestIsInsufficientPrivilege(t *testing.T) {
+	assert.True(t, isInsufficientPrivilege(fmt.Errorf("failed to apply migration: %w", &pgconn.PgError{Code: "42501"})))
+	assert.False(t, isInsufficientPrivilege(&pgconn.PgError{Code: "42P01"}))
+	assert.False(t, isInsufficientPrivilege(errors.New("boom")))
+	assert.False(t, isInsufficientPrivilege(nil))
+}
+
+func TestPrivilegeError(t *testing.T) {
+	cause := &pgconn.PgError{Code: "42501", Message: "permission denied for schema public"}
+	err := error(&PrivilegeError{Err: cause, SQL: "BEGIN;\nCOMMIT;\n"})
+	assert.Contains(t, err.Error(), "permission denied for schema public")
+	assert.Contains(t, err.Error(), "\n\nBEGIN;\nCOMMIT;\n")
+	assert.True(t, errors.Is(err, cause))
+}
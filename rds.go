@@ -0,0 +1,47 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/rds/auth"
+	"net"
+	"strconv"
+	"time"
+)
+
+// rdsTokenTTL is how long an RDS authentication token is reused.
+// Tokens are valid for 15 minutes.
+const rdsTokenTTL = time.Minute * 10
+
+// WithRDSIAMAuth authenticates with AWS IAM database authentication to
+// RDS or Aurora, using tokens generated with the credentials found by
+// the AWS SDK's default configuration, such as an instance role. The
+// region defaults to that of the AWS configuration. Tokens are
+// renewed before they expire, and the user in the connection string
+// must be granted the rds_iam role. RDS requires TLS for IAM
+// authentication.
+func WithRDSIAMAuth(region string) Option {
+	return func(storage Storage) (Storage, error) {
+		cfg, err := config.LoadDefaultConfig(context.Background())
+		if err != nil {
+			return storage, fmt.Errorf("failed to load AWS configuration: %w", err)
+		}
+		if region == "" {
+			region = cfg.Region
+		}
+		if region == "" {
+			return storage, fmt.Errorf("failed to determine AWS region: set one for RDS IAM authentication")
+		}
+
+		storage.passwordFunc = cachedPasswordFunc(func(ctx context.Context, host string, port uint16, user string) (string, error) {
+			endpoint := net.JoinHostPort(host, strconv.Itoa(int(port)))
+			token, err := auth.BuildAuthToken(ctx, endpoint, region, user, cfg.Credentials)
+			if err != nil {
+				return "", fmt.Errorf("failed to build RDS authentication token: %w", err)
+			}
+			return token, nil
+		}, rdsTokenTTL)
+		return storage, nil
+	}
+}
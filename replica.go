@@ -0,0 +1,22 @@
+package certmagic_postgres
+
+import (
+	"fmt"
+)
+
+// WithReadConnectionString sends the listing operations, the audit
+// log and Certificates to the read replica at connectionString, which
+// Connect opens alongside the primary with the same options. Replicas
+// lag behind the primary, so these may briefly miss a key just stored
+// or return a stale value. Reads of given keys, such as Load, Exists
+// and Stat, go to the primary along with writes and locks, as certmagic
+// loads what it has just stored or another instance has just renewed.
+func WithReadConnectionString(connectionString string) Option {
+	return func(storage Storage) (Storage, error) {
+		if connectionString == "" {
+			return storage, fmt.Errorf("invalid read connection string: must not be empty")
+		}
+		storage.readConnectionString = connectionString
+		return storage, nil
+	}
+}
@@ -0,0 +1,185 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"go.uber.org/zap"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// RetryPolicy controls how operations failing with a transient
+// error, such as a dropped connection, a serialization failure or
+// a deadlock, are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts made,
+	// including the first one. Zero or one disables retries.
+	MaxAttempts int
+
+	// InitialBackoff is the wait before the first retry. It is
+	// doubled for every subsequent retry, up to MaxBackoff, and
+	// randomly reduced by up to half to spread out retries from
+	// many instances.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the wait between retries. Zero means no cap.
+	MaxBackoff time.Duration
+}
+
+// WithRetryPolicy retries every operation that fails with a
+// transient error according to policy. By default, operations
+// are attempted once.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(storage Storage) (Storage, error) {
+		if policy.MaxAttempts < 0 || policy.InitialBackoff < 0 || policy.MaxBackoff < 0 {
+			return storage, fmt.Errorf("invalid retry policy: %+v", policy)
+		}
+		storage.retryPolicy = policy
+		return storage, nil
+	}
+}
+
+// run executes fn as a single storage operation of the given class,
+// bounding each attempt by the class's timeout and retrying transient
+// failures according to the retry policy.
+func (s Storage) run(ctx context.Context, class opClass, fn func(ctx context.Context) error) error {
+	return s.runWithPolicy(ctx, class, s.effectiveRetryPolicy(), fn)
+}
+
+// runWithPolicy is run using policy instead of the configured retry policy.
+func (s Storage) runWithPolicy(ctx context.Context, class opClass, policy RetryPolicy, fn func(ctx context.Context) error) (err error) {
+	ctx, span := s.startOperationSpan(ctx, class)
+	defer func() { endSpan(span, err) }()
+
+	if s.operations != nil {
+		if err := s.operations.start(); err != nil {
+			return err
+		}
+		defer s.operations.finish()
+	}
+	if s.breaker != nil {
+		if err := s.breaker.check(); err != nil {
+			return err
+		}
+	}
+	if err := s.ensureInitialized(ctx); err != nil {
+		return err
+	}
+
+	timeout := s.timeout(class)
+	operationStart := time.Now()
+	err = retryTransient(ctx, policy, s.logger, func() error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		start := time.Now()
+		err := s.withReauthentication(func() error {
+			return fn(ctx)
+		})
+		s.logSlowAttempt(class, time.Since(start), timeout)
+		if s.failover != nil {
+			err = s.failover.check(err)
+			var failoverErr *failoverError
+			if errors.As(err, &failoverErr) {
+				s.logger.Warn("database failed over, reconnecting to the new primary", zap.Error(err))
+			}
+		}
+		return err
+	})
+	err = classifyError(err)
+	observeOperation(class, time.Since(operationStart), err)
+	return err
+}
+
+// runWithResult is run for operations returning a result.
+func runWithResult[T any](ctx context.Context, s Storage, class opClass, fn func(ctx context.Context) (T, error)) (T, error) {
+	var result T
+	err := s.run(ctx, class, func(ctx context.Context) error {
+		var err error
+		result, err = fn(ctx)
+		return err
+	})
+	return result, err
+}
+
+// isTransientError reports whether err is likely to succeed if the
+// operation is retried, such as a dropped connection or a server
+// restart, as opposed to an error caused by the operation itself.
+func isTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var failoverErr *failoverError
+	if errors.As(err, &failoverErr) {
+		return true
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	if pgconn.SafeToRetry(err) {
+		return true
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case len(pgErr.Code) == 5 && pgErr.Code[:2] == "08": // connection exception
+			return true
+		case pgErr.Code == "40001", pgErr.Code == "40P01": // serialization failure, deadlock
+			return true
+		case pgErr.Code == "57P01", pgErr.Code == "57P02", pgErr.Code == "57P03": // server shutting down or starting up
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// retryTransient calls fn until it succeeds, returns a non-transient
+// error, or has been attempted as often as policy allows, backing off
+// between attempts and logging each retry to logger. Waiting is cut
+// short if ctx is done.
+func retryTransient(ctx context.Context, policy RetryPolicy, logger *zap.Logger, fn func() error) error {
+	backoff := policy.InitialBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= policy.MaxAttempts || !isTransientError(err) {
+			return err
+		}
+
+		wait := jitter(backoff)
+		logger.Warn("retrying storage operation after transient error",
+			zap.Int("attempt", attempt),
+			zap.Duration("wait", wait),
+			zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+
+		backoff *= 2
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+// jitter returns a random duration between half of d and d.
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,75 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestIsTransientError(t *testing.T) {
+	tt := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{name: "nil", err: nil, transient: false},
+		{name: "bad connection", err: fmt.Errorf("failed exec: %w", driver.ErrBadConn), transient: true},
+		{name: "connection failure", err: &pgconn.PgError{Code: "08006"}, transient: true},
+		{name: "serialization failure", err: &pgconn.PgError{Code: "40001"}, transient: true},
+		{name: "deadlock", err: &pgconn.PgError{Code: "40P01"}, transient: true},
+		{name: "admin shutdown", err: &pgconn.PgError{Code: "57P01"}, transient: true},
+		{name: "unique violation", err: &pgconn.PgError{Code: "23505"}, transient: false},
+		{name: "context canceled", err: context.Canceled, transient: false},
+		{name: "other", err: errors.New("boom"), transient: false},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.transient, isTransientError(tc.err))
+		})
+	}
+}
+
+func TestRetryTransient(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+	calls := 0
+	err := retryTransient(context.Background(), policy, zap.NewNop(), func() error {
+		calls++
+		return driver.ErrBadConn
+	})
+	assert.Equal(t, driver.ErrBadConn, err)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	err = retryTransient(context.Background(), policy, zap.NewNop(), func() error {
+		calls++
+		if calls < 2 {
+			return driver.ErrBadConn
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, calls)
+
+	calls = 0
+	err = retryTransient(context.Background(), policy, zap.NewNop(), func() error {
+		calls++
+		return errors.New("boom")
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		d := jitter(time.Second)
+		assert.GreaterOrEqual(t, int64(d), int64(time.Millisecond*500))
+		assert.LessOrEqual(t, int64(d), int64(time.Second))
+	}
+}
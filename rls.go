@@ -0,0 +1,69 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"fmt"
+)
+
+// tenantSetting is the session variable identifying the tenant
+// whose rows the row level security policies let a connection see.
+const tenantSetting = "certmagic.tenant"
+
+// tenantTables are the tables scoped to a tenant.
+var tenantTables = []string{"certmagic_data", "certmagic_locks", "certmagic_blobs", "certmagic_audit"}
+
+// MigrateRowLevelSecurity makes Migrate enable row level security on
+// the tables used by Storage, with a policy restricting every
+// connection, including the tables' owner, to the rows of the tenant
+// named by the certmagic.tenant session variable. Connections without
+// it see no rows at all. This isolates tenants sharing the tables
+// even if a query fails to filter by tenant, and keeps other
+// applications connecting as the same role from reading every
+// tenant's keys. Row level security is only supported on PostgreSQL
+// itself.
+//
+// Migrations applied afterwards only change the rows of the tenant
+// of the storage running them.
+func MigrateRowLevelSecurity() MigrateOption {
+	return func(options *migrateOptions) {
+		options.rowLevelSecurity = true
+	}
+}
+
+// WithRowLevelSecurity makes every call to Migrate, including the one
+// made by WithAutoMigrate, behave as if passed MigrateRowLevelSecurity.
+// Connect sets certmagic.tenant to the storage's tenant on every
+// connection; databases passed to Open must set it themselves, e.g.
+// with the "options=-c certmagic.tenant=..." connection parameter.
+func WithRowLevelSecurity() Option {
+	return func(storage Storage) (Storage, error) {
+		storage.rowLevelSecurity = true
+		return storage, nil
+	}
+}
+
+// rowLevelSecurityStatements returns the statements enabling row
+// level security on the tenant tables, replacing any policy created
+// by an earlier migration.
+func rowLevelSecurityStatements() []string {
+	var statements []string
+	for _, table := range tenantTables {
+		statements = append(statements,
+			fmt.Sprintf(`ALTER TABLE %s ENABLE ROW LEVEL SECURITY`, table),
+			fmt.Sprintf(`ALTER TABLE %s FORCE ROW LEVEL SECURITY`, table),
+			fmt.Sprintf(`DROP POLICY IF EXISTS certmagic_tenant ON %s`, table),
+			fmt.Sprintf(`CREATE POLICY certmagic_tenant ON %s USING (tenant = current_setting('%[2]s', true)) WITH CHECK (tenant = current_setting('%[2]s', true))`, table, tenantSetting),
+		)
+	}
+	return statements
+}
+
+// applyRowLevelSecurity executes rowLevelSecurityStatements.
+func applyRowLevelSecurity(ctx context.Context, q querier) error {
+	for _, statement := range rowLevelSecurityStatements() {
+		if _, err := q.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("failed to enable row level security: %w", err)
+		}
+	}
+	return nil
+}
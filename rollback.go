@@ -0,0 +1,132 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// Rollback reverts, in reverse version order, every applied migration
+// newer than toVersion by applying its down migration, and returns the
+// down migrations applied. This backs out the schema changes of an
+// upgrade of this module, after which the previous version of the
+// module can be deployed again. toVersion must be the version of an
+// embedded migration, such as "20261017095000_schema_version"; rolling
+// back past that migration drops the version table, leaving no record
+// of the migrations still applied.
+//
+// With MigrateDryRun, the down migrations are returned without
+// changing the database. Down migrations may drop columns and tables,
+// and with them any data stored there.
+func (s Storage) Rollback(ctx context.Context, toVersion string, options ...MigrateOption) ([]Migration, error) {
+	var opts migrateOptions
+	for _, option := range options {
+		option(&opts)
+	}
+
+	all, err := loadDownMigrations(s.dialect)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+	all = s.migrationTables(all)
+	known := false
+	for _, migration := range all {
+		known = known || migration.Version == toVersion
+	}
+	if !known {
+		return nil, fmt.Errorf("unknown migration version: %s", toVersion)
+	}
+
+	if s.isDistributed() {
+		applied, err := appliedVersions(ctx, s.db)
+		if err != nil {
+			return nil, err
+		}
+		reverted := revertedMigrations(all, applied, toVersion)
+		if opts.dryRun {
+			return reverted, nil
+		}
+
+		for _, migration := range reverted {
+			// The version is forgotten first, so a failed rollback is completed by migrating again
+			if err := forgetVersion(ctx, s.db, migration.Version); err != nil {
+				return nil, err
+			}
+			for _, statement := range splitStatements(migration.SQL) {
+				if _, err := s.db.ExecContext(ctx, statement); err != nil {
+					return nil, fmt.Errorf("failed to revert migration %s: %w", migration.Version, err)
+				}
+			}
+		}
+		return reverted, nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := s.liftStatementTimeout(ctx, tx); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+		return nil, fmt.Errorf("failed to lock migrations: %w", err)
+	}
+
+	applied, err := appliedVersions(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	reverted := revertedMigrations(all, applied, toVersion)
+	if opts.dryRun {
+		return reverted, nil
+	}
+
+	for _, migration := range reverted {
+		// Forget the version first, as the version table is itself dropped by a down migration
+		if err := forgetVersion(ctx, tx, migration.Version); err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, migration.SQL); err != nil {
+			return nil, fmt.Errorf("failed to revert migration %s: %w", migration.Version, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit rollback: %w", err)
+	}
+	return reverted, nil
+}
+
+// forgetVersion removes version from the
+// certmagic_schema_version table, if it still exists.
+func forgetVersion(ctx context.Context, q querier, version string) error {
+	var hasVersions bool
+	if err := q.QueryRowContext(ctx, `SELECT to_regclass('certmagic_schema_version') IS NOT NULL`).Scan(&hasVersions); err != nil {
+		return fmt.Errorf("failed scan: %w", err)
+	}
+	if !hasVersions {
+		return nil
+	}
+
+	if _, err := q.ExecContext(ctx, `DELETE FROM certmagic_schema_version WHERE version = $1`, version); err != nil {
+		return fmt.Errorf("failed to forget migration %s: %w", version, err)
+	}
+	return nil
+}
+
+// revertedMigrations returns the down migrations in all that are
+// newer than toVersion and recorded in applied, newest first.
+func revertedMigrations(all []Migration, applied map[string]bool, toVersion string) []Migration {
+	var reverted []Migration
+	for _, migration := range all {
+		if migration.Version > toVersion && applied[migration.Version] {
+			reverted = append(reverted, migration)
+		}
+	}
+	sort.Slice(reverted, func(i, j int) bool {
+		return reverted[i].Version > reverted[j].Version
+	})
+	return reverted
+}
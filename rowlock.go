@@ -0,0 +1,162 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"sync"
+)
+
+const (
+	// LockStrategyLease records locks as rows with an expiry, which
+	// other instances may take over once the lease has expired.
+	LockStrategyLease = "lease"
+
+	// LockStrategyRow holds a transaction with a row lock open for
+	// the whole critical section. The lock is released automatically
+	// if the connection is lost, but every held lock occupies one
+	// connection, and lock TTLs and fencing do not apply. ForceUnlock
+	// cannot release a row lock; it ends with its holder's connection.
+	LockStrategyRow = "row"
+)
+
+// WithLockStrategy selects how locks are implemented: LockStrategyLease
+// (the default) or LockStrategyRow. All instances sharing a database
+// must use the same strategy.
+func WithLockStrategy(strategy string) Option {
+	return func(storage Storage) (Storage, error) {
+		switch strategy {
+		case LockStrategyLease, LockStrategyRow:
+			storage.lockStrategy = strategy
+			return storage, nil
+		default:
+			return storage, fmt.Errorf("invalid lock strategy: %s", strategy)
+		}
+	}
+}
+
+// rowLocks tracks the open transactions holding row locks,
+// so they can be ended when the lock is released.
+type rowLocks struct {
+	mu    sync.Mutex
+	locks map[string]rowLock
+}
+
+// rowLock is a transaction holding a row lock, along with the
+// connection it runs on, which goes back to the pool once it ends.
+type rowLock struct {
+	conn *sql.Conn
+	tx   *sql.Tx
+}
+
+func newRowLocks() *rowLocks {
+	return &rowLocks{locks: make(map[string]rowLock)}
+}
+
+// end commits or rolls back the transaction and releases its connection.
+func (l rowLock) end(commit bool) error {
+	var err error
+	if commit {
+		err = l.tx.Commit()
+	} else {
+		err = l.tx.Rollback()
+	}
+	return errors.Join(err, l.conn.Close())
+}
+
+// acquireRowLock makes a single attempt at taking a row lock on key
+// with SKIP LOCKED, keeping the transaction open on success.
+func (s Storage) acquireRowLock(ctx context.Context, key string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	// Make sure there is a row to lock
+	_, err := s.lockDB.ExecContext(ctx, s.tables(`INSERT INTO certmagic_locks (tenant, key) VALUES ($2, $1) ON CONFLICT (tenant, key) DO NOTHING`), s.lockKey(key), s.tenant)
+	if err != nil {
+		return false, fmt.Errorf("failed to lock key: %s: %w", key, err)
+	}
+
+	// Record the holder before taking the row lock, as anything written
+	// by the held transaction stays invisible until it ends. SKIP LOCKED
+	// leaves the details of a current holder alone.
+	result, err := s.lockDB.ExecContext(ctx, s.tables(`UPDATE certmagic_locks SET holder = $2, acquired = CURRENT_TIMESTAMP WHERE (tenant, key) IN (SELECT tenant, key FROM certmagic_locks WHERE key = $1 AND tenant = $3 FOR UPDATE SKIP LOCKED)`), s.lockKey(key), s.instanceID, s.tenant)
+	if err != nil {
+		return false, fmt.Errorf("failed to lock key: %s: %w", key, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return false, nil
+	}
+
+	// Waiting for a connection honours ctx, but the transaction outlives
+	// this call, so it must not be bound to ctx
+	conn, err := s.lockDB.Conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get connection: %w", err)
+	}
+	tx, err := conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		conn.Close()
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	lock := rowLock{conn: conn, tx: tx}
+
+	var lockedKey string
+	err = tx.QueryRowContext(ctx, s.tables(`SELECT key FROM certmagic_locks WHERE key = $1 AND tenant = $2 FOR UPDATE SKIP LOCKED`), s.lockKey(key), s.tenant).Scan(&lockedKey)
+	if err == sql.ErrNoRows {
+		lock.end(false)
+		return false, nil
+	}
+	if err != nil {
+		lock.end(false)
+		return false, fmt.Errorf("failed to lock key: %s: %w", key, err)
+	}
+
+	s.rowLocks.mu.Lock()
+	defer s.rowLocks.mu.Unlock()
+	if previous, ok := s.rowLocks.locks[key]; ok {
+		// Shouldn't happen, as the previous transaction still holds the row
+		previous.end(false)
+	}
+	s.rowLocks.locks[key] = lock
+	return true, nil
+}
+
+// releaseRowLock ends the transaction holding the row lock on key,
+// then clears the holder unless another one has taken the row since.
+func (s Storage) releaseRowLock(ctx context.Context, key string) error {
+	s.rowLocks.mu.Lock()
+	lock, ok := s.rowLocks.locks[key]
+	delete(s.rowLocks.locks, key)
+	s.rowLocks.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	if err := lock.end(true); err != nil {
+		return err
+	}
+
+	_, err := s.lockDB.ExecContext(ctx, s.tables(`UPDATE certmagic_locks SET holder = '' WHERE (tenant, key) IN (SELECT tenant, key FROM certmagic_locks WHERE key = $1 AND tenant = $2 AND holder = $3 FOR UPDATE SKIP LOCKED)`), s.lockKey(key), s.tenant, s.instanceID)
+	if err != nil {
+		return fmt.Errorf("failed exec: %w", err)
+	}
+	return nil
+}
+
+// releaseAllRowLocks rolls back every transaction holding a row lock.
+func (s Storage) releaseAllRowLocks() {
+	s.rowLocks.mu.Lock()
+	defer s.rowLocks.mu.Unlock()
+	for key, lock := range s.rowLocks.locks {
+		if err := lock.end(false); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			s.logger.Warn("failed to release row lock", zap.String("key", key), zap.Error(err))
+		}
+		delete(s.rowLocks.locks, key)
+	}
+}
@@ -0,0 +1,269 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// WithSchema places the storage's tables in the named schema, rather
+// than the first schema of the role's search_path, by setting
+// search_path on every connection opened by Connect. Migrate creates
+// the schema if it does not exist. Databases passed to Open must set
+// search_path themselves, e.g. with the "search_path" connection
+// parameter.
+func WithSchema(name string) Option {
+	return func(storage Storage) (Storage, error) {
+		if name == "" {
+			return storage, fmt.Errorf("invalid schema: must not be empty")
+		}
+		storage.schema = name
+		return storage, nil
+	}
+}
+
+// Schema returns a single SQL script creating every table, index and
+// setting the storage uses, for its dialect and with the key
+// collation, value storage, row level security and unlogged options
+// it was configured with, as Migrate would create them on an empty
+// database. It lets teams applying DDL through their own tooling
+// fetch it without reading the db directory.
+func (s Storage) Schema() (string, error) {
+	dialect := s.dialect
+	if dialect == "" {
+		dialect = DialectPostgres
+	}
+	all, err := loadMigrations(dialect)
+	if err != nil {
+		return "", fmt.Errorf("failed to load migrations: %w", err)
+	}
+
+	return s.script(s.migrationTables(all), s.migrateDefaults()), nil
+}
+
+// script returns a single SQL script applying migrations, already
+// using the storage's table names, along with the settings in opts, in
+// the storage's schema if it has one.
+func (s Storage) script(migrations []Migration, opts migrateOptions) string {
+	var statements []string
+	if opts.keyCollation != "" && opts.keyCollation != defaultKeyCollation {
+		statements = append(statements, splitStatements(keyCollationSQL(opts.keyCollation))...)
+	}
+	statements = append(statements, valueSettingsStatements(opts)...)
+	if opts.rowLevelSecurity {
+		statements = append(statements, rowLevelSecurityStatements()...)
+	}
+	if opts.unlogged {
+		statements = append(statements, unloggedStatements()...)
+	}
+
+	for i, statement := range statements {
+		statements[i] = s.tables(statement)
+	}
+
+	script := migrationScript(migrations, statements)
+	if s.schema != "" {
+		script = fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %[1]s;\nSET search_path TO %[1]s;\n\n%s", quoteIdentifier(s.schema), script)
+	}
+	return script
+}
+
+// schemaColumns lists the columns the storage needs, by table, with
+// their type as reported by information_schema.
+var schemaColumns = []struct {
+	table, column, dataType string
+}{
+	{"certmagic_data", "tenant", "text"},
+	{"certmagic_data", "key", "text"},
+	{"certmagic_data", "value", "bytea"},
+	{"certmagic_data", "modified", "timestamp with time zone"},
+	{"certmagic_data", "created_at", "timestamp with time zone"},
+	{"certmagic_data", "original_key", "text"},
+	{"certmagic_data", "external", "boolean"},
+	{"certmagic_data", "directory", "text"},
+	{"certmagic_locks", "tenant", "text"},
+	{"certmagic_locks", "key", "text"},
+	{"certmagic_locks", "expires", "timestamp with time zone"},
+	{"certmagic_locks", "holder", "text"},
+	{"certmagic_locks", "acquired", "timestamp with time zone"},
+	{"certmagic_locks", "fence", "bigint"},
+	{"certmagic_blobs", "tenant", "text"},
+	{"certmagic_blobs", "key", "text"},
+	{"certmagic_blobs", "value", "bytea"},
+}
+
+// schemaIndexes lists the indexes the storage relies on.
+var schemaIndexes = []struct {
+	table, index string
+}{
+	{"certmagic_data", "certmagic_data_pkey"},
+	{"certmagic_data", "certmagic_data_original_key_idx"},
+	{"certmagic_data", "certmagic_data_modified_idx"},
+	{"certmagic_data", "certmagic_data_directory_idx"},
+	{"certmagic_locks", "certmagic_locks_pkey"},
+	{"certmagic_blobs", "certmagic_blobs_pkey"},
+}
+
+// schemaPrivileges are the privileges needed on every table.
+var schemaPrivileges = []string{"SELECT", "INSERT", "UPDATE", "DELETE"}
+
+// auditSchemaColumns are the columns of the certmagic_audit table,
+// needed with WithAuditLog.
+var auditSchemaColumns = []struct {
+	table, column, dataType string
+}{
+	{"certmagic_audit", "id", "bigint"},
+	{"certmagic_audit", "tenant", "text"},
+	{"certmagic_audit", "key", "text"},
+	{"certmagic_audit", "operation", "text"},
+	{"certmagic_audit", "instance_id", "text"},
+	{"certmagic_audit", "recorded_at", "timestamp with time zone"},
+}
+
+// auditSchemaPrivileges are the privileges needed on the
+// certmagic_audit table, which entries are never updated in.
+var auditSchemaPrivileges = []string{"SELECT", "INSERT", "DELETE"}
+
+// SchemaError is returned by ValidateSchema when the database schema
+// doesn't match what the storage expects. Each problem names the
+// missing or mismatched object, such as "missing column
+// certmagic_data.modified".
+type SchemaError struct {
+	Problems []string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("invalid schema: %s", strings.Join(e.Problems, "; "))
+}
+
+// WithoutSchemaValidation skips ValidateSchema when connecting and in
+// Status, for roles that can't read the catalogs it queries. A missing
+// migration or grant then only shows when an operation fails.
+func WithoutSchemaValidation() Option {
+	return func(storage Storage) (Storage, error) {
+		storage.skipSchemaValidation = true
+		return storage, nil
+	}
+}
+
+// ValidateSchema checks that the tables, columns, indexes and
+// sequence the storage uses exist with the expected types, and that
+// the current role has the privileges it needs on them. Connect calls
+// it after any automatic migration, so a missing migration or grant
+// fails startup instead of the first renewal.
+func (s Storage) ValidateSchema(ctx context.Context) error {
+	tables := make(map[string]bool)
+	columns := make(map[string]string)
+	rows, err := s.db.QueryContext(ctx, s.tables(`SELECT table_name, column_name, data_type FROM information_schema.columns WHERE table_schema = current_schema() AND table_name IN ('certmagic_data', 'certmagic_locks', 'certmagic_blobs', 'certmagic_audit')`))
+	if err != nil {
+		return fmt.Errorf("failed query: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table, column, dataType string
+		if err := rows.Scan(&table, &column, &dataType); err != nil {
+			return fmt.Errorf("failed scan: %w", err)
+		}
+		tables[table] = true
+		columns[table+"."+column] = dataType
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed iterating rows: %w", err)
+	}
+
+	indexes := make(map[string]bool)
+	rows, err = s.db.QueryContext(ctx, s.tables(`SELECT indexname FROM pg_indexes WHERE schemaname = current_schema() AND tablename IN ('certmagic_data', 'certmagic_locks', 'certmagic_blobs', 'certmagic_audit')`))
+	if err != nil {
+		return fmt.Errorf("failed query: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var index string
+		if err := rows.Scan(&index); err != nil {
+			return fmt.Errorf("failed scan: %w", err)
+		}
+		indexes[index] = true
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed iterating rows: %w", err)
+	}
+
+	required := schemaColumns
+	if s.auditLog {
+		required = append(required[:len(required):len(required)], auditSchemaColumns...)
+	}
+
+	var problems []string
+	reported := make(map[string]bool)
+	for _, c := range required {
+		table := s.tableName(c.table)
+		if !tables[table] {
+			if !reported[table] {
+				problems = append(problems, "missing table "+table)
+				reported[table] = true
+			}
+			continue
+		}
+		dataType, ok := columns[table+"."+c.column]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("missing column %s.%s", table, c.column))
+			continue
+		}
+		if dataType != c.dataType {
+			problems = append(problems, fmt.Sprintf("column %s.%s has type %s, expected %s", table, c.column, dataType, c.dataType))
+		}
+	}
+	for _, i := range schemaIndexes {
+		table, index := s.tableName(i.table), s.tables(i.index)
+		if tables[table] && !indexes[index] {
+			problems = append(problems, fmt.Sprintf("missing index %s on %s", index, table))
+		}
+	}
+
+	var sequence bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM information_schema.sequences WHERE sequence_schema = current_schema() AND sequence_name = 'certmagic_lock_fence_seq')`).Scan(&sequence); err != nil {
+		return fmt.Errorf("failed scan: %w", err)
+	}
+	if !sequence {
+		problems = append(problems, "missing sequence certmagic_lock_fence_seq")
+	} else {
+		var usage bool
+		if err := s.db.QueryRowContext(ctx, `SELECT has_sequence_privilege('certmagic_lock_fence_seq', 'USAGE')`).Scan(&usage); err != nil {
+			return fmt.Errorf("failed scan: %w", err)
+		}
+		if !usage {
+			problems = append(problems, "missing privilege USAGE on certmagic_lock_fence_seq")
+		}
+	}
+
+	for _, table := range []string{s.tableName(defaultDataTable), s.tableName(defaultLocksTable), "certmagic_blobs"} {
+		if !tables[table] {
+			continue
+		}
+		for _, privilege := range schemaPrivileges {
+			var granted bool
+			if err := s.db.QueryRowContext(ctx, `SELECT has_table_privilege($1, $2)`, table, privilege).Scan(&granted); err != nil {
+				return fmt.Errorf("failed scan: %w", err)
+			}
+			if !granted {
+				problems = append(problems, fmt.Sprintf("missing privilege %s on %s", privilege, table))
+			}
+		}
+	}
+	if s.auditLog && tables["certmagic_audit"] {
+		for _, privilege := range auditSchemaPrivileges {
+			var granted bool
+			if err := s.db.QueryRowContext(ctx, `SELECT has_table_privilege('certmagic_audit', $1)`, privilege).Scan(&granted); err != nil {
+				return fmt.Errorf("failed scan: %w", err)
+			}
+			if !granted {
+				problems = append(problems, fmt.Sprintf("missing privilege %s on certmagic_audit", privilege))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &SchemaError{Problems: problems}
+	}
+	return nil
+}
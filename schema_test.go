@@ -0,0 +1,171 @@
+package certmagic_postgres_test
+
+import (
+	"context"
+	"errors"
+	"github.com/fluidgalleries/certmagic-postgres"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestStorage_ValidateSchema(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, storage.ValidateSchema(context.Background()))
+
+	_, err = db.Exec(`DROP INDEX certmagic_data_original_key_idx`)
+	require.Nil(t, err)
+	_, err = db.Exec(`ALTER TABLE certmagic_data DROP COLUMN modified`)
+	require.Nil(t, err)
+
+	err = storage.ValidateSchema(context.Background())
+	var schemaErr *certmagic_postgres.SchemaError
+	require.True(t, errors.As(err, &schemaErr))
+	assert.Equal(t, []string{
+		"missing column certmagic_data.modified",
+		"missing index certmagic_data_original_key_idx on certmagic_data",
+	}, schemaErr.Problems)
+
+	_, err = certmagic_postgres.Connect(getConnectionString(t))
+	assert.True(t, errors.As(err, &schemaErr))
+}
+
+func TestStorage_WithSchema(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+	defer db.Exec(`DROP SCHEMA IF EXISTS certmagic_test CASCADE`)
+
+	storage, err := certmagic_postgres.Connect(getConnectionString(t), certmagic_postgres.WithSchema("certmagic_test"), certmagic_postgres.WithAutoMigrate())
+	require.Nil(t, err)
+	defer storage.Close()
+
+	require.Nil(t, storage.Store(context.Background(), "abc", []byte("value")))
+
+	var count int
+	require.Nil(t, db.QueryRow(`SELECT count(*) FROM certmagic_test.certmagic_data`).Scan(&count))
+	assert.Equal(t, 1, count)
+	require.Nil(t, db.QueryRow(`SELECT count(*) FROM certmagic_data`).Scan(&count))
+	assert.Equal(t, 0, count)
+
+	_, err = certmagic_postgres.Connect(getConnectionString(t), certmagic_postgres.WithSchema(""))
+	assert.NotNil(t, err)
+}
+
+func TestStorage_WithTableNames(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+	defer db.Exec(`DROP SCHEMA IF EXISTS certmagic_tables_test CASCADE`)
+
+	storage, err := certmagic_postgres.Connect(getConnectionString(t), certmagic_postgres.WithSchema("certmagic_tables_test"), certmagic_postgres.WithTableNames("acme_data", "acme_locks"), certmagic_postgres.WithAutoMigrate())
+	require.Nil(t, err)
+	defer storage.Close()
+
+	ctx := context.Background()
+	require.Nil(t, storage.Lock(ctx, "abc"))
+	require.Nil(t, storage.Store(ctx, "abc", []byte("value")))
+	value, err := storage.Load(ctx, "abc")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+	require.Nil(t, storage.Unlock(ctx, "abc"))
+	assert.Nil(t, storage.ValidateSchema(ctx))
+
+	var count int
+	require.Nil(t, db.QueryRow(`SELECT count(*) FROM certmagic_tables_test.acme_data`).Scan(&count))
+	assert.Equal(t, 1, count)
+	var exists bool
+	require.Nil(t, db.QueryRow(`SELECT to_regclass('certmagic_tables_test.certmagic_data') IS NOT NULL`).Scan(&exists))
+	assert.False(t, exists)
+}
+
+func TestStorage_WithTableNames_SharedSchema(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	// Record the migrations of the default table names
+	storage, err := certmagic_postgres.Open(db)
+	require.Nil(t, err)
+	_, err = storage.Migrate(context.Background())
+	require.Nil(t, err)
+
+	storage, err = certmagic_postgres.Open(db, certmagic_postgres.WithTableNames("acme_data", "acme_locks"), certmagic_postgres.WithoutSchemaValidation())
+	require.Nil(t, err)
+	_, err = storage.Migrate(context.Background())
+	assert.ErrorContains(t, err, "acme_data")
+}
+
+func TestStorage_ValidateSchema_MissingTable(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec(`DROP TABLE certmagic_blobs`)
+	require.Nil(t, err)
+
+	err = storage.ValidateSchema(context.Background())
+	var schemaErr *certmagic_postgres.SchemaError
+	require.True(t, errors.As(err, &schemaErr))
+	assert.Equal(t, []string{"missing table certmagic_blobs"}, schemaErr.Problems)
+}
+
+func TestStorage_WithoutSchemaValidation(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	_, err := db.Exec(`DROP TABLE certmagic_blobs`)
+	require.Nil(t, err)
+
+	_, err = certmagic_postgres.Connect(getConnectionString(t))
+	assert.NotNil(t, err)
+
+	storage, err := certmagic_postgres.Connect(getConnectionString(t), certmagic_postgres.WithoutSchemaValidation())
+	require.Nil(t, err)
+	defer storage.Close()
+	assert.Empty(t, storage.Status(context.Background()).SchemaProblems)
+}
+
+func TestStorage_Schema(t *testing.T) {
+	storage, err := certmagic_postgres.Open(nil, certmagic_postgres.WithUnloggedTables(), certmagic_postgres.WithValueCompression("lz4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := storage.Schema()
+	require.Nil(t, err)
+	assert.True(t, strings.HasPrefix(schema, "BEGIN;\n"))
+	assert.Contains(t, schema, "-- 20200721125602_baseline\n")
+	assert.Contains(t, schema, "ALTER TABLE certmagic_data ALTER COLUMN value SET COMPRESSION lz4;\n")
+	assert.Contains(t, schema, "ALTER TABLE certmagic_data SET UNLOGGED;\n")
+	assert.Contains(t, schema, "INSERT INTO certmagic_schema_version (version) VALUES ('20200721125602_baseline');\n")
+	assert.True(t, strings.HasSuffix(schema, "COMMIT;\n"))
+
+	storage, err = certmagic_postgres.Open(nil, certmagic_postgres.WithSchema("certmagic"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err = storage.Schema()
+	require.Nil(t, err)
+	assert.True(t, strings.HasPrefix(schema, "CREATE SCHEMA IF NOT EXISTS \"certmagic\";\nSET search_path TO \"certmagic\";\n\nBEGIN;\n"))
+
+	storage, err = certmagic_postgres.Open(nil, certmagic_postgres.WithTableNames("acme_data", "acme_locks"), certmagic_postgres.WithUnloggedTables())
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err = storage.Schema()
+	require.Nil(t, err)
+	assert.Contains(t, schema, "CREATE TABLE IF NOT EXISTS acme_data (\n")
+	assert.Contains(t, schema, "ALTER TABLE acme_locks SET UNLOGGED;\n")
+	assert.Contains(t, schema, "REFERENCES acme_data (tenant, key)")
+	assert.NotContains(t, schema, "certmagic_data")
+	assert.NotContains(t, schema, "certmagic_locks")
+}
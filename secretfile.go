@@ -0,0 +1,69 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"os"
+	"strings"
+)
+
+// WithPasswordFile authenticates every new connection with the
+// password in the file at path, such as a mounted Docker or
+// Kubernetes secret. The file is read again for every connection, so
+// the secret can be rotated without restarting, and connections
+// failing to authenticate are retried with the current password.
+func WithPasswordFile(path string) Option {
+	return func(storage Storage) (Storage, error) {
+		if _, err := readSecretFile(path); err != nil {
+			return storage, err
+		}
+		storage.passwordFunc = func(context.Context, string, uint16, string) (string, error) {
+			return readSecretFile(path)
+		}
+		return storage, nil
+	}
+}
+
+// WithConnectionStringFile makes Connect read the connection string
+// from the file at path when given an empty connection string. The
+// file is read again for every new connection, which picks up a
+// rotated password.
+func WithConnectionStringFile(path string) Option {
+	return func(storage Storage) (Storage, error) {
+		if _, err := readSecretFile(path); err != nil {
+			return storage, err
+		}
+		storage.connectionStringFunc = func(context.Context) (string, error) {
+			return readSecretFile(path)
+		}
+		storage.passwordFunc = connectionStringPassword(storage.connectionStringFunc)
+		return storage, nil
+	}
+}
+
+// connectionStringPassword returns a PasswordFunc returning the
+// password in the connection string returned by fn.
+func connectionStringPassword(fn func(ctx context.Context) (string, error)) PasswordFunc {
+	return func(ctx context.Context, _ string, _ uint16, _ string) (string, error) {
+		connectionString, err := fn(ctx)
+		if err != nil {
+			return "", err
+		}
+		config, err := pgx.ParseConfig(connectionString)
+		if err != nil {
+			return "", fmt.Errorf("invalid connection string: %w", err)
+		}
+		return config.Password, nil
+	}
+}
+
+// readSecretFile returns the contents of the file at path, without
+// the trailing newline most tools write.
+func readSecretFile(path string) (string, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	return strings.TrimRight(string(contents), "\r\n"), nil
+}
@@ -0,0 +1,89 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// shutdownTimeout bounds how long Caddy waits for operations in flight
+// when the storage is cleaned up on a reload or shutdown.
+const shutdownTimeout = time.Second * 10
+
+// operations tracks the operations in flight, shared between copies
+// of the Storage, so Shutdown can wait for them.
+type operations struct {
+	mu       sync.Mutex
+	active   int
+	shutdown bool
+	drained  chan struct{}
+}
+
+func newOperations() *operations {
+	return &operations{drained: make(chan struct{})}
+}
+
+// start records the start of an operation, failing with an error
+// matching ErrClosed once Shutdown has been called.
+func (o *operations) start() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.shutdown {
+		return fmt.Errorf("failed to start operation: %w", ErrClosed)
+	}
+	o.active++
+	return nil
+}
+
+// finish records the end of an operation.
+func (o *operations) finish() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.active--
+	if o.shutdown && o.active == 0 {
+		close(o.drained)
+	}
+}
+
+// drain rejects new operations, and returns a channel closed once
+// those in flight have finished.
+func (o *operations) drain() <-chan struct{} {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.shutdown {
+		o.shutdown = true
+		if o.active == 0 {
+			close(o.drained)
+		}
+	}
+	return o.drained
+}
+
+// Shutdown stops the background health monitor and cleaning, rejects new
+// operations, and waits for those in flight to finish before closing
+// the database, so that a Caddy reload doesn't abort writes midway.
+// If ctx is done first, the database is closed anyway, aborting the
+// remaining operations, and the context's error is returned.
+func (s Storage) Shutdown(ctx context.Context) error {
+	if s.breaker != nil {
+		s.breaker.close()
+	}
+	if s.janitor != nil {
+		s.janitor.close()
+	}
+
+	var err error
+	if s.operations != nil {
+		select {
+		case <-s.operations.drain():
+		case <-ctx.Done():
+			err = fmt.Errorf("failed to wait for operations in flight: %w", ctx.Err())
+		}
+	}
+
+	if closeErr := s.Close(); closeErr != nil {
+		return closeErr
+	}
+	return err
+}
@@ -0,0 +1,44 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestOperations_Drain(t *testing.T) {
+	o := newOperations()
+	assert.Nil(t, o.start())
+
+	drained := o.drain()
+	select {
+	case <-drained:
+		t.Fatal("drained with an operation in flight")
+	default:
+	}
+	assert.ErrorIs(t, o.start(), ErrClosed)
+
+	o.finish()
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatal("not drained after the last operation finished")
+	}
+	assert.Equal(t, drained, o.drain())
+}
+
+func TestStorage_Shutdown(t *testing.T) {
+	storage, err := Open(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, storage.operations.start())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	assert.ErrorIs(t, storage.Shutdown(ctx), context.DeadlineExceeded)
+
+	_, err = storage.Load(context.Background(), "key")
+	assert.ErrorIs(t, err, ErrClosed)
+}
@@ -0,0 +1,50 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// pingTimeout bounds each attempt at reaching the database in Connect.
+const pingTimeout = time.Second * 5
+
+// WithStartupRetry makes Connect keep trying to reach the database
+// according to policy when it is briefly unavailable, such as while
+// it restarts at the same time as Caddy, instead of failing on the
+// first attempt. A maxWait above zero gives up once that much time
+// has passed, even if attempts remain. By default, Connect tries once.
+func WithStartupRetry(policy RetryPolicy, maxWait time.Duration) Option {
+	return func(storage Storage) (Storage, error) {
+		if policy.MaxAttempts < 0 || policy.InitialBackoff < 0 || policy.MaxBackoff < 0 {
+			return storage, fmt.Errorf("invalid startup retry policy: %+v", policy)
+		}
+		if maxWait < 0 {
+			return storage, fmt.Errorf("invalid startup max wait: %s", maxWait)
+		}
+		storage.startupRetryPolicy = policy
+		storage.startupMaxWait = maxWait
+		return storage, nil
+	}
+}
+
+// pingWithRetry pings db, retrying transient failures according to
+// the startup retry policy.
+func (s Storage) pingWithRetry(db *sql.DB) error {
+	ctx := context.Background()
+	if s.startupMaxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.startupMaxWait)
+		defer cancel()
+	}
+
+	return retryTransient(ctx, s.startupRetryPolicy, s.logger, func() error {
+		ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+		defer cancel()
+
+		return s.withReauthentication(func() error {
+			return db.PingContext(ctx)
+		})
+	})
+}
@@ -0,0 +1,36 @@
+package certmagic_postgres
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestWithStartupRetry(t *testing.T) {
+	_, err := Open(nil, WithStartupRetry(RetryPolicy{MaxAttempts: -1}, 0))
+	assert.NotNil(t, err)
+
+	_, err = Open(nil, WithStartupRetry(RetryPolicy{MaxAttempts: 3}, -time.Second))
+	assert.NotNil(t, err)
+}
+
+func TestStorage_PingWithRetry(t *testing.T) {
+	// Nothing listens on port 1, so every attempt is refused
+	db, err := sql.Open("pgx", "postgres://localhost:1/certmagic?connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	storage, err := Open(nil, WithStartupRetry(RetryPolicy{MaxAttempts: 100, InitialBackoff: time.Millisecond * 50}, time.Millisecond*300))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	err = storage.pingWithRetry(db)
+	assert.NotNil(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*250)
+	assert.Less(t, time.Since(start), time.Second*5)
+}
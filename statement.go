@@ -0,0 +1,49 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// WithStatementTimeout has Connect set statement_timeout on every
+// connection to the longest of the operation timeouts, so the server
+// cancels statements outliving them rather than running them to
+// completion after the client gave up. Migrations are exempt.
+func WithStatementTimeout() Option {
+	return func(storage Storage) (Storage, error) {
+		storage.statementTimeout = true
+		return storage, nil
+	}
+}
+
+// maxTimeout returns the longest timeout of any operation class.
+func (s Storage) maxTimeout() time.Duration {
+	timeout := s.queryTimeout
+	for _, class := range []opClass{opRead, opWrite, opList} {
+		if t := s.timeout(class); t > timeout {
+			timeout = t
+		}
+	}
+	return timeout
+}
+
+// statementTimeoutSetting returns the value of statement_timeout for
+// the operation timeouts, in milliseconds.
+func (s Storage) statementTimeoutSetting() string {
+	return strconv.FormatInt(s.maxTimeout().Milliseconds(), 10)
+}
+
+// liftStatementTimeout disables the statement timeout for the rest
+// of tx, for migrations running longer than any operation.
+func (s Storage) liftStatementTimeout(ctx context.Context, tx *sql.Tx) error {
+	if !s.statementTimeout {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, `SET LOCAL statement_timeout = 0`); err != nil {
+		return fmt.Errorf("failed to lift statement timeout: %w", err)
+	}
+	return nil
+}
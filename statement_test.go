@@ -0,0 +1,20 @@
+package certmagic_postgres
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestStorage_StatementTimeoutSetting(t *testing.T) {
+	storage, err := Open(nil, WithStatementTimeout())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "3000", storage.statementTimeoutSetting())
+
+	storage, err = Open(nil, WithStatementTimeout(), WithReadTimeout("1s"), WithListTimeout("30s"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "30000", storage.statementTimeoutSetting())
+}
@@ -1,16 +1,34 @@
 package certmagic_postgres
 
 import (
+	"cloud.google.com/go/cloudsqlconn"
 	"context"
+	"crypto/cipher"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/caddyserver/certmagic"
-	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
 	"time"
 )
 
 type Option = func(Storage) (Storage, error)
 
+// unlockRetryPolicy is the minimum retry policy applied to Unlock.
+var unlockRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: time.Millisecond * 100,
+	MaxBackoff:     time.Second * 1,
+}
+
 func WithQueryTimeout(timeout string) Option {
 	return func(storage Storage) (Storage, error) {
 		queryTimeout, err := time.ParseDuration(timeout)
@@ -33,47 +51,311 @@ func WithLockTimeout(timeout string) Option {
 	}
 }
 
+// WithMaxLockWait limits how long Lock waits for a lock held by
+// someone else before giving up. This is independent of the lock
+// timeout, which sets how long an acquired lock lasts. By default
+// Lock waits for as long as the caller's context allows.
+func WithMaxLockWait(wait string) Option {
+	return func(storage Storage) (Storage, error) {
+		maxLockWait, err := time.ParseDuration(wait)
+		if err != nil {
+			return storage, fmt.Errorf("invalid max lock wait: %w", err)
+		}
+		storage.maxLockWait = maxLockWait
+		return storage, nil
+	}
+}
+
+// WithLockPollInterval sets how often Lock checks whether a lock held
+// by someone else has been released. Defaults to 1s.
+func WithLockPollInterval(interval string) Option {
+	return func(storage Storage) (Storage, error) {
+		lockPollInterval, err := time.ParseDuration(interval)
+		if err != nil {
+			return storage, fmt.Errorf("invalid lock poll interval: %w", err)
+		}
+		if lockPollInterval <= 0 {
+			return storage, fmt.Errorf("invalid lock poll interval: %s", interval)
+		}
+		storage.lockPollInterval = lockPollInterval
+		return storage, nil
+	}
+}
+
+// WithInstanceID sets the identity recorded as the holder of locks
+// acquired by this instance. Defaults to the hostname and process ID.
+func WithInstanceID(id string) Option {
+	return func(storage Storage) (Storage, error) {
+		if id == "" {
+			return storage, fmt.Errorf("invalid instance id: must not be empty")
+		}
+		storage.instanceID = id
+		return storage, nil
+	}
+}
+
+// WithLockPoolSize reserves a dedicated pool of size connections
+// for lock operations, so that lock acquisition and release don't
+// queue behind heavy data traffic. Only supported by Connect, as
+// the pool is opened from the connection string.
+func WithLockPoolSize(size int) Option {
+	return func(storage Storage) (Storage, error) {
+		if size < 1 {
+			return storage, fmt.Errorf("invalid lock pool size: %d", size)
+		}
+		storage.lockPoolSize = size
+		return storage, nil
+	}
+}
+
+// WithStrictDelete makes Delete return an error wrapping
+// fs.ErrNotExist when the key did not exist, instead of
+// succeeding silently as certmagic expects.
+func WithStrictDelete() Option {
+	return func(storage Storage) (Storage, error) {
+		storage.strictDelete = true
+		return storage, nil
+	}
+}
+
 type Storage struct {
-	db           *sql.DB
-	queryTimeout time.Duration
-	lockTimeout  time.Duration
+	db                   *sql.DB
+	lockDB               *sql.DB
+	readDB               *sql.DB
+	readConnectionString string
+	aead                 cipher.AEAD
+	driver               string
+	queryTimeout         time.Duration
+	readTimeout          time.Duration
+	writeTimeout         time.Duration
+	listTimeout          time.Duration
+	lockTimeout          time.Duration
+	lockPollInterval     time.Duration
+	maxLockWait          time.Duration
+	lockRefresh          time.Duration
+	lockRefreshers       *lockRefreshers
+	lockPoolSize         int
+	lockStrategy         string
+	rowLocks             *rowLocks
+	instanceID           string
+	strictDelete         bool
+	keyValidation        KeyValidation
+	keyCodec             KeyCodec
+	keyPrefix            string
+	tenant               string
+	asyncCommitPrefixes  []string
+	asyncCommitLocks     bool
+	maxValueSize         int
+	retryPolicy          RetryPolicy
+	startupRetryPolicy   RetryPolicy
+	startupMaxWait       time.Duration
+	lazyConnect          bool
+	lazyInit             *lazyInit
+	healthInterval       time.Duration
+	healthThreshold      int
+	cleanInterval        time.Duration
+	cleanOptions         CleanOptions
+	janitor              *janitor
+	auditLog             bool
+	auditRetention       time.Duration
+	breaker              *circuitBreaker
+	failover             *failover
+	dialect              string
+	autoMigrate          bool
+	skipSchemaValidation bool
+	externalThreshold    int
+	unloggedTables       bool
+	keyCollation         string
+	valueStorage         string
+	valueCompression     string
+	rowLevelSecurity     bool
+	sslMode              string
+	sslRootCert          []byte
+	sslCert              []byte
+	sslKey               []byte
+	passwordFunc         PasswordFunc
+	credentialProvider   CredentialProvider
+	statementTimeout     bool
+	applicationName      string
+	schema               string
+	tableNames           *strings.Replacer
+	logger               *zap.Logger
+	tracer               trace.Tracer
+	dialFunc             DialFunc
+	unixSocket           string
+	operations           *operations
+	maxOpenConns         int
+	maxIdleConns         *int
+	connMaxLifetime      time.Duration
+	connMaxIdleTime      time.Duration
+	connectionStringFunc func(ctx context.Context) (string, error)
+	cloudSQLInstance     string
+	cloudSQLIAMAuthN     bool
+	cloudSQLDialer       *cloudsqlconn.Dialer
 }
 
 func Connect(connectionString string, options ...Option) (Storage, error) {
+	storage, err := newStorage(nil, options)
+	if err != nil {
+		return Storage{}, err
+	}
+
+	if connectionString == "" && storage.connectionStringFunc != nil {
+		connectionString, err = storage.connectionStringFunc(context.Background())
+		if err != nil {
+			return Storage{}, err
+		}
+	}
+	if connectionString == "" && storage.unixSocket == "" {
+		connectionString, err = ConnectionStringFromEnv()
+		if err != nil {
+			return Storage{}, err
+		}
+	}
+
+	if storage.cloudSQLInstance != "" && storage.dialFunc != nil {
+		return Storage{}, fmt.Errorf("a dial func cannot be combined with Cloud SQL")
+	}
+	if storage.unixSocket != "" && (storage.cloudSQLInstance != "" || storage.dialFunc != nil) {
+		return Storage{}, fmt.Errorf("a unix socket cannot be combined with Cloud SQL or a dial func")
+	}
+	if storage.cloudSQLInstance != "" {
+		storage.cloudSQLDialer, err = storage.newCloudSQLDialer()
+		if err != nil {
+			return Storage{}, err
+		}
+	}
+
 	// Open database connection
-	db, err := sql.Open("pgx", connectionString)
+	db, err := storage.openDB(connectionString)
 	if err != nil {
+		storage.Close()
 		return Storage{}, fmt.Errorf("failed to open database connection: %w", err)
 	}
+	storage.configurePool(db)
+	storage.db = db
+	storage.lockDB = db
+	storage.readDB = db
+
+	if storage.lazyConnect {
+		// The first operation reaches the database instead
+		if storage.dialect == "" {
+			storage.dialect = DialectPostgres
+		}
+	} else {
+		// Ping database
+		if err = storage.pingWithRetry(db); err != nil {
+			storage.Close()
+			return Storage{}, fmt.Errorf("failed to ping database: %w", err)
+		}
 
-	// Ping database
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-	if err = db.PingContext(ctx); err != nil {
-		return Storage{}, fmt.Errorf("failed to ping database: %w", err)
+		if storage.dialect == "" {
+			ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+			defer cancel()
+			storage.dialect, err = detectDialect(ctx, db)
+			if err != nil {
+				storage.Close()
+				return Storage{}, err
+			}
+		}
+	}
+	if err := storage.checkDialect(); err != nil {
+		storage.Close()
+		return Storage{}, err
 	}
 
-	storage := Storage{
-		db:           db,
-		queryTimeout: time.Second * 3,
-		lockTimeout:  time.Minute * 1,
+	if storage.lazyConnect {
+		storage.lazyInit = &lazyInit{}
+	} else if err := storage.prepare(); err != nil {
+		storage.Close()
+		return Storage{}, err
 	}
 
-	for _, option := range options {
-		storage, err = option(storage)
+	if storage.lockPoolSize > 0 {
+		lockDB, err := storage.openDB(connectionString)
 		if err != nil {
-			return Storage{}, err
+			storage.Close()
+			return Storage{}, fmt.Errorf("failed to open lock database connection: %w", err)
+		}
+		storage.configurePool(lockDB)
+		lockDB.SetMaxOpenConns(storage.lockPoolSize)
+		lockDB.SetMaxIdleConns(storage.lockPoolSize)
+		storage.lockDB = lockDB
+	}
+
+	if storage.readConnectionString != "" {
+		// Failover would reject the replica for not accepting writes
+		replica := storage
+		replica.failover = nil
+		readDB, err := replica.openDB(storage.readConnectionString)
+		if err != nil {
+			storage.Close()
+			return Storage{}, fmt.Errorf("failed to open read database connection: %w", err)
 		}
+		storage.configurePool(readDB)
+		storage.readDB = readDB
 	}
 
+	storage.startHealthMonitor()
+	storage.startJanitor()
 	return storage, nil
 }
 
 func Open(db *sql.DB, options ...Option) (Storage, error) {
+	storage, err := newStorage(db, options)
+	if err != nil {
+		return Storage{}, err
+	}
+
+	if storage.lockPoolSize > 0 {
+		return Storage{}, fmt.Errorf("a dedicated lock pool requires Connect")
+	}
+	if storage.failover != nil {
+		return Storage{}, fmt.Errorf("failover requires Connect")
+	}
+	if storage.readConnectionString != "" {
+		return Storage{}, fmt.Errorf("a read replica requires Connect")
+	}
+	if storage.driver != DriverStdlib {
+		return Storage{}, fmt.Errorf("the %s driver requires Connect", storage.driver)
+	}
+	if storage.dialect == "" {
+		storage.dialect = DialectPostgres
+	}
+	if err := storage.checkDialect(); err != nil {
+		return Storage{}, err
+	}
+
+	if storage.autoMigrate {
+		ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+		defer cancel()
+		if _, err := storage.Migrate(ctx); err != nil {
+			return Storage{}, err
+		}
+	}
+
+	storage.startHealthMonitor()
+	storage.startJanitor()
+	return storage, nil
+}
+
+// newStorage creates a Storage using db with defaults, then applies options.
+func newStorage(db *sql.DB, options []Option) (Storage, error) {
 	storage := Storage{
-		db:           db,
-		queryTimeout: time.Second * 3,
-		lockTimeout:  time.Minute * 1,
+		db:               db,
+		lockDB:           db,
+		readDB:           db,
+		queryTimeout:     time.Second * 3,
+		lockTimeout:      time.Minute * 1,
+		lockPollInterval: time.Second * 1,
+		lockStrategy:     LockStrategyLease,
+		driver:           DriverStdlib,
+		rowLocks:         newRowLocks(),
+		lockRefreshers:   newLockRefreshers(),
+		operations:       newOperations(),
+		instanceID:       defaultInstanceID(),
+		applicationName:  defaultApplicationName,
+		logger:           zap.NewNop(),
 	}
 
 	for _, option := range options {
@@ -87,6 +369,117 @@ func Open(db *sql.DB, options ...Option) (Storage, error) {
 	return storage, nil
 }
 
+// openDB opens a database for connectionString with the configured
+// driver, applying the connection settings and the credential
+// provider or password func.
+func (s Storage) openDB(connectionString string) (*sql.DB, error) {
+	if s.driver == DriverPgxpool {
+		return s.openPoolDB(connectionString)
+	}
+
+	config, err := pgx.ParseConfig(connectionString)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.configureConn(config); err != nil {
+		return nil, err
+	}
+
+	var options []stdlib.OptionOpenDB
+	if s.failover != nil {
+		afterConnect, resetSession := s.failover.configure(config)
+		options = append(options, stdlib.OptionAfterConnect(afterConnect), stdlib.OptionResetSession(resetSession))
+	}
+	if beforeConnect := s.beforeConnect(); beforeConnect != nil {
+		options = append(options, stdlib.OptionBeforeConnect(beforeConnect))
+	}
+	return stdlib.OpenDB(*config, options...), nil
+}
+
+// configureConn applies the application name, the schema, the TLS
+// options, the statement timeout and the way of dialing to config.
+// With row level security, every connection sets certmagic.tenant to
+// the tenant.
+func (s Storage) configureConn(config *pgx.ConnConfig) error {
+	s.configureApplicationName(config)
+	if s.tracer != nil {
+		config.Logger = queryTracer{tracer: s.tracer}
+		config.LogLevel = pgx.LogLevelInfo
+	}
+	if s.rowLevelSecurity {
+		config.RuntimeParams[tenantSetting] = s.tenant
+	}
+	if s.statementTimeout {
+		config.RuntimeParams["statement_timeout"] = s.statementTimeoutSetting()
+	}
+	if s.schema != "" {
+		config.RuntimeParams["search_path"] = quoteIdentifier(s.schema)
+	}
+	if s.hasTLSConfig() {
+		if err := s.configureTLS(config); err != nil {
+			return err
+		}
+	}
+	if s.unixSocket != "" {
+		s.configureUnixSocket(config)
+	}
+	if s.dialFunc != nil {
+		// Host names are left to the other end of the tunnel to resolve
+		config.DialFunc = pgconn.DialFunc(s.dialFunc)
+		config.LookupFunc = func(_ context.Context, host string) ([]string, error) {
+			return []string{host}, nil
+		}
+	}
+	if s.cloudSQLDialer != nil {
+		// The connector secures connections itself
+		config.TLSConfig = nil
+		config.Fallbacks = nil
+		config.DialFunc = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return s.cloudSQLDialer.Dial(ctx, s.cloudSQLInstance)
+		}
+	}
+	return nil
+}
+
+// beforeConnect returns the func setting the credentials of each new
+// connection from the credential provider or password func, or nil
+// if there is neither.
+func (s Storage) beforeConnect() func(context.Context, *pgx.ConnConfig) error {
+	if s.credentialProvider != nil {
+		return func(ctx context.Context, config *pgx.ConnConfig) error {
+			user, password, err := s.credentialProvider.Credentials(ctx)
+			if err != nil {
+				return err
+			}
+			if user != "" {
+				config.User = user
+			}
+			config.Password = password
+			return nil
+		}
+	}
+	if s.passwordFunc != nil {
+		return func(ctx context.Context, config *pgx.ConnConfig) error {
+			password, err := s.passwordFunc(ctx, config.Host, config.Port, config.User)
+			if err != nil {
+				return err
+			}
+			config.Password = password
+			return nil
+		}
+	}
+	return nil
+}
+
+// defaultInstanceID identifies this process by hostname and PID.
+func defaultInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s:%d", hostname, os.Getpid())
+}
+
 // Implement CertMagic.Storage Interface
 //
 // Lock acquires the lock for key, blocking until the lock
@@ -110,100 +503,359 @@ func Open(db *sql.DB, options ...Option) (Storage, error) {
 // caller wishes to give up and free resources before the lock
 // can be obtained).
 func (s Storage) Lock(ctx context.Context, key string) error {
-	ctx, cancel := context.WithTimeout(ctx, s.queryTimeout)
-	defer cancel()
+	_, err := s.waitForLock(ctx, key, s.lockTimeout)
+	return err
+}
 
-	tx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
-		return err
+// LockWithTTL acquires the lock for key like Lock, but expires
+// the lock after ttl instead of the configured lock timeout. Use
+// it for critical sections known to run longer (or shorter) than
+// usual, such as issuing a certificate with many SANs. The ttl
+// has no effect with LockStrategyRow.
+func (s Storage) LockWithTTL(ctx context.Context, key string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("invalid lock ttl: %s", ttl)
 	}
-	defer tx.Rollback()
+	_, err := s.waitForLock(ctx, key, ttl)
+	return err
+}
 
-	// Check if a lock on the key exists
-	row := tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM certmagic_locks WHERE key = $1 AND expires > CURRENT_TIMESTAMP)`, key)
-	var isLocked bool
-	if err = row.Scan(&isLocked); err != nil {
-		return fmt.Errorf("failed scan: %w", err)
+// waitForLock polls until the lock for key is acquired or ctx
+// is done, returning the fence token of the acquired lock.
+func (s Storage) waitForLock(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	if s.maxLockWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.maxLockWait)
+		defer cancel()
 	}
 
-	if isLocked {
-		return fmt.Errorf("key %s is already locked", key)
+	start := time.Now()
+	contended := false
+	for {
+		fence, locked, err := s.acquireLock(ctx, key, ttl)
+		if err != nil {
+			return 0, err
+		}
+		if locked {
+			waited := time.Since(start)
+			metrics.lockWaitDuration.Observe(waited.Seconds())
+			if contended {
+				s.logger.Info("acquired contended lock", zap.String("key", key), zap.Duration("waited", waited))
+			}
+			if err := s.recordAudit(ctx, AuditLock, key); err != nil {
+				// The caller won't unlock after an error
+				s.Unlock(context.Background(), key)
+				return 0, err
+			}
+			s.startLockRefresh(key, ttl, fence)
+			return fence, nil
+		}
+		metrics.lockContentions.Inc()
+		if !contended {
+			contended = true
+			s.logger.Debug("lock held by someone else, waiting", zap.String("key", key))
+		}
+
+		// Wait for the current holder to unlock or for the lock to expire
+		select {
+		case <-ctx.Done():
+			s.logger.Warn("gave up waiting for lock", zap.String("key", key), zap.Duration("waited", time.Since(start)), zap.Error(ctx.Err()))
+			return 0, fmt.Errorf("failed to lock key: %s: %w: %w", key, ErrLockTimeout, ctx.Err())
+		case <-time.After(s.lockPollInterval):
+		}
 	}
+}
 
-	expires := time.Now().Add(s.lockTimeout)
-	if _, err := tx.ExecContext(ctx, `INSERT INTO certmagic_locks (key, expires) VALUES ($1, $2) ON CONFLICT (key) DO UPDATE SET expires = $2`, key, expires); err != nil {
-		return fmt.Errorf("failed to lock key: %s: %w", key, err)
+// TryLock makes a single attempt at acquiring the lock for key
+// without waiting, bounding the attempt by queryTimeout. It
+// reports false if the key is currently locked by someone else.
+// A successful TryLock must be followed by a call to Unlock.
+func (s Storage) TryLock(ctx context.Context, key string) (bool, error) {
+	fence, locked, err := s.acquireLock(ctx, key, s.lockTimeout)
+	if locked {
+		if err := s.recordAudit(ctx, AuditLock, key); err != nil {
+			// The caller won't unlock after an error
+			s.Unlock(context.Background(), key)
+			return false, err
+		}
+		s.startLockRefresh(key, s.lockTimeout, fence)
+	}
+	return locked, err
+}
+
+// acquireLock makes a single attempt at acquiring the lock for
+// key, expiring after ttl, and returns the fence token assigned
+// to the lock on success.
+func (s Storage) acquireLock(ctx context.Context, key string, ttl time.Duration) (int64, bool, error) {
+	if s.lockStrategy == LockStrategyRow {
+		locked, err := s.acquireRowLock(ctx, key)
+		return 0, locked, classifyError(err)
 	}
 
-	return tx.Commit()
+	var fence int64
+	var locked bool
+	err := s.run(ctx, opDefault, func(ctx context.Context) error {
+		return s.withCommitMode(ctx, s.lockDB, s.asyncCommitLocks, false, func(q querier) error {
+			// Insert the lock, or take over an expired one, in a single atomic statement.
+			// No row is returned when the key is held by an unexpired lock.
+			expires := time.Now().Add(ttl)
+			row := q.QueryRowContext(ctx, s.tables(`INSERT INTO certmagic_locks (tenant, key, expires, holder, fence) VALUES ($4, $1, $2, $3, nextval('certmagic_lock_fence_seq')) ON CONFLICT (tenant, key) DO UPDATE SET expires = $2, holder = $3, acquired = CURRENT_TIMESTAMP, fence = EXCLUDED.fence WHERE certmagic_locks.expires <= CURRENT_TIMESTAMP RETURNING fence`), s.lockKey(key), expires, s.instanceID, s.tenant)
+			err := row.Scan(&fence)
+			if err == sql.ErrNoRows {
+				locked = false
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to lock key: %s: %w", key, err)
+			}
+			locked = true
+			return nil
+		})
+	})
+	return fence, locked, err
 }
 
 // Unlock releases the lock for key. This method must ONLY be
 // called after a successful call to Lock, and only after the
 // critical section is finished, even if it errored or timed
 // out. Unlock cleans up any resources allocated during Lock.
-func (s Storage) Unlock(key string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), s.queryTimeout)
-	defer cancel()
+//
+// Only a lock held by this instance is released, so a lock
+// that expired and was taken over by another instance is left
+// untouched. Transient failures, such as a dropped connection,
+// are retried a few times so that a network blip doesn't leave
+// the lock held until it expires.
+func (s Storage) Unlock(ctx context.Context, key string) error {
+	if s.lockStrategy == LockStrategyRow {
+		if err := s.releaseRowLock(ctx, key); err != nil {
+			return err
+		}
+		return s.recordAudit(ctx, AuditUnlock, key)
+	}
+	s.stopLockRefresh(key)
 
-	_, err := s.db.ExecContext(ctx, `DELETE FROM certmagic_locks WHERE key = $1`, key)
-	return err
+	// Always retry, even if no retry policy was configured
+	policy := s.effectiveRetryPolicy()
+	if policy.MaxAttempts < unlockRetryPolicy.MaxAttempts {
+		policy = unlockRetryPolicy
+	}
+
+	err := s.runWithPolicy(ctx, opDefault, policy, func(ctx context.Context) error {
+		return s.withCommitMode(ctx, s.lockDB, s.asyncCommitLocks, false, func(q querier) error {
+			_, err := q.ExecContext(ctx, s.tables(`DELETE FROM certmagic_locks WHERE key = $1 AND holder = $2 AND tenant = $3`), s.lockKey(key), s.instanceID, s.tenant)
+			return err
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return s.recordAudit(ctx, AuditUnlock, key)
+}
+
+// ForceUnlock deletes the lock for key regardless of which
+// process holds it or when it expires. It is intended for
+// operational recovery, e.g. after a crashed node left a lock
+// with a long TTL behind; regular callers should use Unlock.
+//
+// ForceUnlock is not available with LockStrategyRow, where a lock
+// is released as soon as its holder's connection is lost.
+func (s Storage) ForceUnlock(ctx context.Context, key string) error {
+	if s.lockStrategy == LockStrategyRow {
+		return fmt.Errorf("force unlock is not supported by the %s lock strategy", s.lockStrategy)
+	}
+	err := s.run(ctx, opDefault, func(ctx context.Context) error {
+		result, err := s.lockDB.ExecContext(ctx, s.tables(`DELETE FROM certmagic_locks WHERE key = $1 AND tenant = $2`), s.lockKey(key), s.tenant)
+		if err != nil {
+			return fmt.Errorf("failed exec: %w", err)
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get affected rows: %w", err)
+		}
+		if affected == 0 {
+			return fmt.Errorf("lock not found: %s: %w", key, fs.ErrNotExist)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.recordAudit(ctx, AuditForceUnlock, key)
+}
+
+// LockInfo describes a lock row held in the certmagic_locks table.
+type LockInfo struct {
+	Key      string
+	Holder   string
+	Acquired time.Time
+	Expires  time.Time
+}
+
+// ListLocks returns every lock currently recorded, including
+// expired locks that have not yet been taken over or released.
+// With WithKeyPrefix, only the locks under the prefix are returned.
+func (s Storage) ListLocks(ctx context.Context) ([]LockInfo, error) {
+	return runWithResult(ctx, s, opDefault, func(ctx context.Context) ([]LockInfo, error) {
+		rows, err := s.lockDB.QueryContext(ctx, s.tables(`SELECT key, holder, acquired, expires FROM certmagic_locks WHERE tenant = $1 AND key LIKE $2 ESCAPE '\' ORDER BY key`), s.tenant, escapeLike(s.keyPrefix)+"%")
+		if err != nil {
+			return nil, fmt.Errorf("failed query: %w", err)
+		}
+		defer rows.Close()
+
+		var locks []LockInfo
+		for rows.Next() {
+			var lock LockInfo
+			if err := rows.Scan(&lock.Key, &lock.Holder, &lock.Acquired, &lock.Expires); err != nil {
+				return nil, fmt.Errorf("failed scan: %w", err)
+			}
+			lock.Key = s.trimKeyPrefix(lock.Key)
+			locks = append(locks, lock)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed iterating rows: %w", err)
+		}
+		return locks, nil
+	})
 }
 
 // Store puts value at key.
-func (s Storage) Store(key string, value []byte) error {
-	ctx, cancel := context.WithTimeout(context.Background(), s.queryTimeout)
-	defer cancel()
+func (s Storage) Store(ctx context.Context, key string, value []byte) error {
+	if err := s.validateKey(key); err != nil {
+		return err
+	}
 
-	_, err := s.db.ExecContext(ctx, `INSERT INTO certmagic_data (key, value) VALUES ($1, $2) ON CONFLICT (key) DO UPDATE SET VALUE = $2, modified = CURRENT_TIMESTAMP`, key, value)
+	if err := s.validateValue(key, value); err != nil {
+		return err
+	}
+	value, err := s.encryptValue(key, value)
 	if err != nil {
-		return fmt.Errorf("failed exec: %w", err)
+		return err
 	}
 
-	return nil
+	err = s.run(ctx, opWrite, func(ctx context.Context) error {
+		inline, external := s.inlineValue(value)
+		return s.withCommitMode(ctx, s.db, s.isAsyncKey(key), external, func(q querier) error {
+			_, err := q.ExecContext(ctx, s.tables(`INSERT INTO certmagic_data (tenant, key, value, original_key, external) VALUES ($4, $1, $2, $3, $5) ON CONFLICT (tenant, key) DO UPDATE SET VALUE = $2, external = $5, modified = CURRENT_TIMESTAMP`), s.encodeKey(key), inline, s.originalKey(key), s.tenant, external)
+			if err != nil {
+				return fmt.Errorf("failed exec: %w", err)
+			}
+
+			return s.storeBlob(ctx, q, s.encodeKey(key), value, external)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return s.recordAudit(ctx, AuditStore, key)
 }
 
-// Load retrieves the value at key.
-func (s Storage) Load(key string) ([]byte, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), s.queryTimeout)
-	defer cancel()
+// StoreWithModTime puts value at key like Store, but records modTime
+// as its modified time instead of the current time, so that tools
+// importing existing data preserve the times certmagic relies on to
+// decide whether assets are stale.
+func (s Storage) StoreWithModTime(ctx context.Context, key string, value []byte, modTime time.Time) error {
+	if err := s.validateKey(key); err != nil {
+		return err
+	}
 
-	var value []byte
-	err := s.db.QueryRowContext(ctx, `SELECT value FROM certmagic_data WHERE key = $1`, key).Scan(&value)
-	if err == sql.ErrNoRows {
-		return nil, certmagic.ErrNotExist(fmt.Errorf("key not found: %s", key))
+	if err := s.validateValue(key, value); err != nil {
+		return err
+	}
+	value, err := s.encryptValue(key, value)
+	if err != nil {
+		return err
 	}
+
+	err = s.run(ctx, opWrite, func(ctx context.Context) error {
+		inline, external := s.inlineValue(value)
+		return s.withCommitMode(ctx, s.db, false, external, func(q querier) error {
+			_, err := q.ExecContext(ctx, s.tables(`INSERT INTO certmagic_data (tenant, key, value, original_key, modified, external) VALUES ($4, $1, $2, $3, $5, $6) ON CONFLICT (tenant, key) DO UPDATE SET VALUE = $2, external = $6, modified = $5`), s.encodeKey(key), inline, s.originalKey(key), s.tenant, modTime, external)
+			if err != nil {
+				return fmt.Errorf("failed exec: %w", err)
+			}
+
+			return s.storeBlob(ctx, q, s.encodeKey(key), value, external)
+		})
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to query row: %w", err)
+		return err
 	}
+	return s.recordAudit(ctx, AuditStore, key)
+}
+
+// Load retrieves the value at key. An error wrapping
+// fs.ErrNotExist is returned if the key does not exist.
+func (s Storage) Load(ctx context.Context, key string) ([]byte, error) {
+	if err := s.validateKey(key); err != nil {
+		return nil, err
+	}
+
+	return runWithResult(ctx, s, opRead, func(ctx context.Context) ([]byte, error) {
+		var value []byte
+		query := s.tables(fmt.Sprintf(`SELECT %s FROM certmagic_data WHERE key = $1 AND tenant = $2`, valueColumn))
+		err := s.db.QueryRowContext(ctx, query, s.encodeKey(key), s.tenant).Scan(&value)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("key not found: %s: %w", key, fs.ErrNotExist)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to query row: %w", err)
+		}
 
-	return value, nil
+		return s.decryptValue(key, value)
+	})
 }
 
 // Delete deletes key. An error should be
 // returned only if the key still exists
 // when the method returns.
-func (s Storage) Delete(key string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), s.queryTimeout)
-	defer cancel()
+//
+// With WithStrictDelete, an error wrapping
+// fs.ErrNotExist is returned if the key did
+// not exist.
+func (s Storage) Delete(ctx context.Context, key string) error {
+	if err := s.validateKey(key); err != nil {
+		return err
+	}
+
+	err := s.run(ctx, opWrite, func(ctx context.Context) error {
+		result, err := s.db.ExecContext(ctx, s.tables("DELETE FROM certmagic_data WHERE key = $1 AND tenant = $2"), s.encodeKey(key), s.tenant)
+		if err != nil {
+			return fmt.Errorf("failed exec: %w", err)
+		}
+
+		if s.strictDelete {
+			affected, err := result.RowsAffected()
+			if err != nil {
+				return fmt.Errorf("failed to get affected rows: %w", err)
+			}
+			if affected == 0 {
+				return fmt.Errorf("key not found: %s: %w", key, fs.ErrNotExist)
+			}
+		}
 
-	_, err := s.db.ExecContext(ctx, "DELETE FROM certmagic_data WHERE key = $1", key)
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed exec: %w", err)
+		return err
 	}
-
-	return nil
+	return s.recordAudit(ctx, AuditDelete, key)
 }
 
 // Exists returns true if the key exists
 // and there was no error checking.
-func (s Storage) Exists(key string) bool {
-	ctx, cancel := context.WithTimeout(context.Background(), s.queryTimeout)
-	defer cancel()
+func (s Storage) Exists(ctx context.Context, key string) bool {
+	if s.validateKey(key) != nil {
+		return false
+	}
 
-	row := s.db.QueryRowContext(ctx, "select exists(select 1 from certmagic_data where key = $1)", key)
-	var exists bool
-	err := row.Scan(&exists)
+	exists, err := runWithResult(ctx, s, opRead, func(ctx context.Context) (bool, error) {
+		row := s.db.QueryRowContext(ctx, s.tables("select exists(select 1 from certmagic_data where key = $1 and tenant = $2)"), s.encodeKey(key), s.tenant)
+		var exists bool
+		err := row.Scan(&exists)
+		return exists, err
+	})
 	return err == nil && exists
 }
 
@@ -212,58 +864,144 @@ func (s Storage) Exists(key string) bool {
 // will be enumerated (i.e. "directories"
 // should be walked); otherwise, only keys
 // prefixed exactly by prefix will be listed.
-func (s Storage) List(prefix string, recursive bool) ([]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), s.queryTimeout)
-	defer cancel()
-
+//
+// Keys are treated as paths separated by "/",
+// so without recursion only the entries directly
+// within the prefix "directory" are returned.
+func (s Storage) List(ctx context.Context, prefix string, recursive bool) ([]string, error) {
 	if recursive {
-		return nil, fmt.Errorf("recursive not supported")
+		return s.listRecursive(ctx, prefix)
 	}
 
-	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`SELECT key FROM certmagic_data WHERE key LIKE '%s%%'`, prefix))
-	if err != nil {
-		return nil, fmt.Errorf("failed query: %w", err)
+	return runWithResult(ctx, s, opList, func(ctx context.Context) ([]string, error) {
+		// Only the immediate children and the directories below them are read
+		query := s.tables(`SELECT %[1]s FROM certmagic_data WHERE directory = $1 AND %[1]s LIKE $3 ESCAPE '\' AND tenant = $4 UNION SELECT DISTINCT directory || '/' FROM certmagic_data WHERE directory LIKE $2 ESCAPE '\' AND tenant = $4`)
+		directory, below := s.directoryArgs(prefix)
+		rows, err := s.readDB.QueryContext(ctx, fmt.Sprintf(query, s.keyColumn()), directory, below, escapeLike(s.keyPrefix+directoryPrefix(prefix))+"%", s.tenant)
+		if err != nil {
+			return nil, fmt.Errorf("failed query: %w", err)
+		}
+		defer rows.Close()
+
+		var keys []string
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				return nil, fmt.Errorf("failed scan: %w", err)
+			}
+			keys = append(keys, s.trimKeyPrefix(key))
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed iterating rows: %w", err)
+		}
+		return listDirectory(prefix, keys, false), nil
+	})
+}
+
+// Stat returns information about key, which may also be a
+// directory as listed by List. An error wrapping
+// fs.ErrNotExist is returned if the key does not exist.
+func (s Storage) Stat(ctx context.Context, key string) (certmagic.KeyInfo, error) {
+	if err := s.validateKey(key); err != nil {
+		return certmagic.KeyInfo{}, err
 	}
-	defer rows.Close()
 
-	var keys []string
-	for rows.Next() {
-		var key string
-		if err := rows.Scan(&key); err != nil {
-			return nil, fmt.Errorf("failed scan: %w", err)
+	return runWithResult(ctx, s, opRead, func(ctx context.Context) (certmagic.KeyInfo, error) {
+		var modified time.Time
+		var size int64
+		query := s.tables(fmt.Sprintf(`SELECT LENGTH (%s), modified FROM certmagic_data WHERE key = $1 AND tenant = $2`, valueColumn))
+		row := s.db.QueryRowContext(ctx, query, s.encodeKey(key), s.tenant)
+		err := row.Scan(&size, &modified)
+		if err == sql.ErrNoRows {
+			return s.statDirectory(ctx, key)
 		}
-		keys = append(keys, key)
-	}
-	return keys, nil
+		if err != nil {
+			return certmagic.KeyInfo{}, fmt.Errorf("failed scan: %w", err)
+		}
+
+		keyInfo := certmagic.KeyInfo{
+			Key:        key,
+			Modified:   modified,
+			Size:       size,
+			IsTerminal: true,
+		}
+		return keyInfo, nil
+	})
 }
 
-// Stat returns information about key.
-func (s Storage) Stat(key string) (certmagic.KeyInfo, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), s.queryTimeout)
-	defer cancel()
+// ExtendedKeyInfo is certmagic.KeyInfo along with
+// details certmagic has no field for.
+type ExtendedKeyInfo struct {
+	certmagic.KeyInfo
 
-	var modified time.Time
-	var size int64
-	row := s.db.QueryRowContext(ctx, `SELECT LENGTH (value), modified FROM certmagic_data WHERE key = $1`, key)
-	err := row.Scan(&size, &modified)
-	if err != nil {
-		return certmagic.KeyInfo{}, fmt.Errorf("failed scan: %w", err)
-	}
+	// Created is when the key was first stored. It is
+	// not changed when the value is overwritten.
+	Created time.Time
+}
 
-	keyInfo := certmagic.KeyInfo{
-		Key:        key,
-		Modified:   modified,
-		Size:       size,
-		IsTerminal: true,
+// StatExtended returns information about key like Stat,
+// including when the key was first stored.
+func (s Storage) StatExtended(ctx context.Context, key string) (ExtendedKeyInfo, error) {
+	if err := s.validateKey(key); err != nil {
+		return ExtendedKeyInfo{}, err
 	}
-	return keyInfo, nil
+
+	return runWithResult(ctx, s, opRead, func(ctx context.Context) (ExtendedKeyInfo, error) {
+		var modified, created time.Time
+		var size int64
+		query := s.tables(fmt.Sprintf(`SELECT LENGTH (%s), modified, created_at FROM certmagic_data WHERE key = $1 AND tenant = $2`, valueColumn))
+		row := s.db.QueryRowContext(ctx, query, s.encodeKey(key), s.tenant)
+		err := row.Scan(&size, &modified, &created)
+		if err == sql.ErrNoRows {
+			return ExtendedKeyInfo{}, fmt.Errorf("key not found: %s: %w", key, fs.ErrNotExist)
+		}
+		if err != nil {
+			return ExtendedKeyInfo{}, fmt.Errorf("failed scan: %w", err)
+		}
+
+		keyInfo := ExtendedKeyInfo{
+			KeyInfo: certmagic.KeyInfo{
+				Key:        key,
+				Modified:   modified,
+				Size:       size,
+				IsTerminal: true,
+			},
+			Created: created,
+		}
+		return keyInfo, nil
+	})
 }
 
+// Close stops the background work and closes every database handle,
+// even if closing one of them fails, returning the errors joined.
 func (s Storage) Close() error {
+	if s.breaker != nil {
+		s.breaker.close()
+	}
+	if s.janitor != nil {
+		s.janitor.close()
+	}
+	if s.lockRefreshers != nil {
+		s.stopAllLockRefreshes()
+	}
+	if s.rowLocks != nil {
+		s.releaseAllRowLocks()
+	}
+	var errs []error
+	if s.lockDB != nil && s.lockDB != s.db {
+		errs = append(errs, s.lockDB.Close())
+	}
+	if s.readDB != nil && s.readDB != s.db {
+		errs = append(errs, s.readDB.Close())
+	}
 	if s.db != nil {
-		return s.db.Close()
+		errs = append(errs, s.db.Close())
 	}
-	return nil
+	// The dialer is closed last, once no connection needs it
+	if s.cloudSQLDialer != nil {
+		errs = append(errs, s.cloudSQLDialer.Close())
+	}
+	return errors.Join(errs...)
 }
 
 // Interface guards
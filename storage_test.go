@@ -2,23 +2,352 @@ package certmagic_postgres_test
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
 	"database/sql"
-	"github.com/caddyserver/certmagic"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
 	"github.com/fluidgalleries/certmagic-postgres"
+	"github.com/jackc/pgx/v4/pgxpool"
 	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"io/fs"
 	"io/ioutil"
+	"math/big"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
 func TestStorage_Connect(t *testing.T) {
+	_, teardown := setupDB(t)
+	defer teardown()
+
 	_, err := certmagic_postgres.Connect(getConnectionString(t))
 	assert.Nil(t, err)
 }
 
+func TestStorage_Connect_Pgxpool(t *testing.T) {
+	_, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Connect(getConnectionString(t), certmagic_postgres.WithDriver(certmagic_postgres.DriverPgxpool))
+	require.Nil(t, err)
+	defer storage.Close()
+
+	require.Nil(t, storage.Store(context.Background(), "abc", []byte("value")))
+	value, err := storage.Load(context.Background(), "abc")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+
+	require.Nil(t, storage.Lock(context.Background(), "abc"))
+	assert.Nil(t, storage.Unlock(context.Background(), "abc"))
+
+	// Numeric columns must be scanned like with the stdlib driver
+	status := storage.Status(context.Background())
+	assert.True(t, status.Healthy(), status.LastError)
+}
+
+func TestStorage_Connect_ReadConnectionString(t *testing.T) {
+	_, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Connect(getConnectionString(t), certmagic_postgres.WithReadConnectionString(getConnectionString(t)))
+	require.Nil(t, err)
+	defer storage.Close()
+
+	require.Nil(t, storage.Store(context.Background(), "abc", []byte("value")))
+	value, err := storage.Load(context.Background(), "abc")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+
+	status := storage.Status(context.Background())
+	assert.True(t, status.Healthy())
+	assert.True(t, status.ReadReplica)
+	assert.True(t, status.ReadReplicaConnected)
+
+	_, err = certmagic_postgres.Open(nil, certmagic_postgres.WithReadConnectionString(getConnectionString(t)))
+	assert.NotNil(t, err)
+}
+
+func TestStorage_Connect_LockPoolSize(t *testing.T) {
+	_, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Connect(getConnectionString(t), certmagic_postgres.WithLockPoolSize(2))
+	require.Nil(t, err)
+	defer storage.Close()
+
+	err = storage.Lock(context.Background(), "abc")
+	assert.Nil(t, err)
+	assert.Nil(t, storage.Unlock(context.Background(), "abc"))
+}
+
+func TestStorage_OpenPool(t *testing.T) {
+	_, teardown := setupDB(t)
+	defer teardown()
+
+	pool, err := pgxpool.Connect(context.Background(), getConnectionString(t))
+	require.Nil(t, err)
+	defer pool.Close()
+
+	storage, err := certmagic_postgres.OpenPool(pool)
+	require.Nil(t, err)
+
+	ctx := context.Background()
+	assert.Nil(t, storage.Store(ctx, "dir/key", []byte("value")))
+	value, err := storage.Load(ctx, "dir/key")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+
+	keys, err := storage.List(ctx, "dir", true)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"dir/key"}, keys)
+
+	info, err := storage.Stat(ctx, "dir/key")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), info.Size)
+
+	assert.Nil(t, storage.StoreBatch(ctx, map[string][]byte{"dir/a": []byte("a"), "dir/b": []byte("b")}))
+	assert.Nil(t, storage.Lock(ctx, "lock"))
+	assert.Nil(t, storage.Unlock(ctx, "lock"))
+
+	// Closing the storage leaves the pool to its owner
+	assert.Nil(t, storage.Close())
+	assert.Nil(t, pool.Ping(ctx))
+}
+
+func TestStorage_OpenPool_Nil(t *testing.T) {
+	_, err := certmagic_postgres.OpenPool(nil)
+	assert.NotNil(t, err)
+}
+
+func TestStorage_Open_LockPoolSize(t *testing.T) {
+	_, err := certmagic_postgres.Open(nil, certmagic_postgres.WithLockPoolSize(2))
+	assert.NotNil(t, err)
+}
+
+func TestStorage_AutoMigrate(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+	migrateDown(t, db)
+
+	storage, err := certmagic_postgres.Open(db, certmagic_postgres.WithAutoMigrate())
+	require.Nil(t, err)
+
+	err = storage.Store(context.Background(), "abc", []byte("value"))
+	require.Nil(t, err)
+
+	// Migrating again leaves existing data alone
+	storage, err = certmagic_postgres.Open(db, certmagic_postgres.WithAutoMigrate())
+	require.Nil(t, err)
+	value, err := storage.Load(context.Background(), "abc")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+}
+
+func TestStorage_UnloggedTables(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+	migrateDown(t, db)
+
+	storage, err := certmagic_postgres.Open(db, certmagic_postgres.WithAutoMigrate(), certmagic_postgres.WithUnloggedTables())
+	require.Nil(t, err)
+
+	var persistence string
+	err = db.QueryRow(`SELECT relpersistence FROM pg_class WHERE oid = 'certmagic_data'::regclass`).Scan(&persistence)
+	require.Nil(t, err)
+	assert.Equal(t, "u", persistence)
+
+	err = storage.Store(context.Background(), "abc", []byte("value"))
+	assert.Nil(t, err)
+}
+
+func TestStorage_UnloggedTables_Distributed(t *testing.T) {
+	storage, err := certmagic_postgres.Open(nil, certmagic_postgres.WithDialect(certmagic_postgres.DialectCockroachDB))
+	require.Nil(t, err)
+
+	_, err = storage.Migrate(context.Background(), certmagic_postgres.MigrateUnlogged())
+	assert.NotNil(t, err)
+}
+
+func TestStorage_AdoptTables(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+	migrateDown(t, db)
+	defer db.Exec(`DROP TABLE IF EXISTS legacy_data, legacy_locks`)
+
+	_, err := db.Exec(`CREATE TABLE legacy_data (key text PRIMARY KEY, value bytea, modified timestamptz DEFAULT current_timestamp)`)
+	require.Nil(t, err)
+	_, err = db.Exec(`CREATE TABLE legacy_locks (key text PRIMARY KEY, expires timestamptz DEFAULT current_timestamp)`)
+	require.Nil(t, err)
+	_, err = db.Exec(`INSERT INTO legacy_data (key, value, modified) VALUES ('abc', 'value', NULL)`)
+	require.Nil(t, err)
+
+	storage, err := certmagic_postgres.Open(db)
+	require.Nil(t, err)
+	applied, err := storage.AdoptTables(context.Background(), "legacy_data", "legacy_locks")
+	require.Nil(t, err)
+	assert.NotEmpty(t, applied)
+
+	value, err := storage.Load(context.Background(), "abc")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+	info, err := storage.StatExtended(context.Background(), "abc")
+	require.Nil(t, err)
+	assert.False(t, info.Created.IsZero())
+	assert.Nil(t, storage.ValidateSchema(context.Background()))
+
+	// Adopting again changes nothing
+	applied, err = storage.AdoptTables(context.Background(), "legacy_data", "legacy_locks")
+	require.Nil(t, err)
+	assert.Empty(t, applied)
+}
+
+func TestStorage_Rollback(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+	migrateDown(t, db)
+
+	storage, err := certmagic_postgres.Open(db, certmagic_postgres.WithAutoMigrate())
+	require.Nil(t, err)
+	err = storage.Store(context.Background(), "abc", []byte("value"))
+	require.Nil(t, err)
+
+	planned, err := storage.Rollback(context.Background(), "20261017095000_schema_version", certmagic_postgres.MigrateDryRun())
+	require.Nil(t, err)
+	require.NotEmpty(t, planned)
+
+	reverted, err := storage.Rollback(context.Background(), "20261017095000_schema_version")
+	require.Nil(t, err)
+	assert.Equal(t, planned, reverted)
+
+	var hasBlobs bool
+	err = db.QueryRow(`SELECT to_regclass('certmagic_blobs') IS NOT NULL`).Scan(&hasBlobs)
+	require.Nil(t, err)
+	assert.False(t, hasBlobs)
+
+	// Migrating again reapplies the reverted migrations
+	applied, err := storage.Migrate(context.Background())
+	require.Nil(t, err)
+	assert.Len(t, applied, len(reverted))
+	value, err := storage.Load(context.Background(), "abc")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+
+	_, err = storage.Rollback(context.Background(), "unknown")
+	assert.NotNil(t, err)
+}
+
+func TestStorage_ValueStorage(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	require.Nil(t, err)
+
+	_, err = storage.Migrate(context.Background(), certmagic_postgres.MigrateValueStorage("external"))
+	require.Nil(t, err)
+
+	var strategy string
+	err = db.QueryRow(`SELECT attstorage FROM pg_attribute WHERE attrelid = 'certmagic_data'::regclass AND attname = 'value'`).Scan(&strategy)
+	require.Nil(t, err)
+	assert.Equal(t, "e", strategy)
+
+	_, err = storage.Migrate(context.Background(), certmagic_postgres.MigrateValueStorage("compressed"))
+	assert.NotNil(t, err)
+}
+
+func TestStorage_KeyCollation(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	require.Nil(t, err)
+
+	for _, key := range []string{"certificates/a", "certificates/B", "certificates/_"} {
+		err = storage.Store(context.Background(), key, []byte("value"))
+		require.Nil(t, err)
+	}
+
+	keys, err := storage.ListPage(context.Background(), "certificates", "", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"certificates/B", "certificates/_", "certificates/a"}, keys)
+
+	_, err = storage.Migrate(context.Background(), certmagic_postgres.MigrateKeyCollation("POSIX"))
+	require.Nil(t, err)
+	var collation string
+	err = db.QueryRow(`SELECT collation_name FROM information_schema.columns WHERE table_name = 'certmagic_data' AND column_name = 'key'`).Scan(&collation)
+	require.Nil(t, err)
+	assert.Equal(t, "POSIX", collation)
+}
+
+func TestStorage_Migrate(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+	migrateDown(t, db)
+
+	storage, err := certmagic_postgres.Open(db)
+	require.Nil(t, err)
+
+	planned, err := storage.Migrate(context.Background(), certmagic_postgres.MigrateDryRun())
+	require.Nil(t, err)
+	assert.NotEmpty(t, planned)
+	var created bool
+	err = db.QueryRow(`SELECT to_regclass('certmagic_data') IS NOT NULL`).Scan(&created)
+	require.Nil(t, err)
+	assert.False(t, created)
+
+	applied, err := storage.Migrate(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, planned, applied)
+	assert.Equal(t, "20200721125602_baseline", applied[0].Version)
+
+	applied, err = storage.Migrate(context.Background())
+	require.Nil(t, err)
+	assert.Empty(t, applied)
+}
+
+func TestStorage_PartitionData(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	require.Nil(t, err)
+
+	err = storage.Store(context.Background(), "certificates/a.crt", []byte("a"))
+	require.Nil(t, err)
+
+	err = storage.PartitionData(context.Background(), 4)
+	require.Nil(t, err)
+	err = storage.PartitionData(context.Background(), 4)
+	assert.NotNil(t, err)
+
+	value, err := storage.Load(context.Background(), "certificates/a.crt")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("a"), value)
+
+	err = storage.Store(context.Background(), "certificates/b.crt", []byte("b"))
+	require.Nil(t, err)
+	keys, err := storage.List(context.Background(), "certificates", false)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"certificates/a.crt", "certificates/b.crt"}, keys)
+}
+
 func TestStorage_Lock(t *testing.T) {
 	tt := []struct {
 		name              string
@@ -70,14 +399,17 @@ func TestStorage_Lock(t *testing.T) {
 
 			time.Sleep(tc.sleepDuration)
 
-			err = storage.Lock(context.Background(), tc.key)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+			defer cancel()
+
+			err = storage.Lock(ctx, tc.key)
 			isLockedError := err != nil
 			assert.Equal(t, tc.isLockedErr, isLockedError)
 		})
 	}
 }
 
-func TestStorage_Unlock(t *testing.T) {
+func TestStorage_Lock_WaitsForUnlock(t *testing.T) {
 	db, teardown := setupDB(t)
 	defer teardown()
 
@@ -91,91 +423,118 @@ func TestStorage_Unlock(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = storage.Unlock("abc")
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		_ = storage.Unlock(context.Background(), "abc")
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	err = storage.Lock(ctx, "abc")
 	assert.Nil(t, err)
 }
 
-func TestStorage_Store(t *testing.T) {
+func TestStorage_Lock_MaxLockWait(t *testing.T) {
 	db, teardown := setupDB(t)
 	defer teardown()
 
-	storage, err := certmagic_postgres.Open(db)
+	storage, err := certmagic_postgres.Open(db, certmagic_postgres.WithMaxLockWait("200ms"))
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	value := []byte("value")
-	err = storage.Store("abc", value)
-	if err != nil {
-		t.Fatal(err)
-	}
+	err = storage.Lock(context.Background(), "abc")
+	require.Nil(t, err)
 
-	valueGot, err := storage.Load("abc")
-	assert.Equal(t, value, valueGot)
-	assert.Nil(t, err)
+	start := time.Now()
+	err = storage.Lock(context.Background(), "abc")
+	assert.True(t, errors.Is(err, certmagic_postgres.ErrLockTimeout))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Less(t, int64(time.Since(start)), int64(time.Second*5))
 }
 
-func TestStorage_Load(t *testing.T) {
+func TestStorage_Lock_Refresh(t *testing.T) {
 	db, teardown := setupDB(t)
 	defer teardown()
 
-	storage, err := certmagic_postgres.Open(db)
+	node1, err := certmagic_postgres.Open(db, certmagic_postgres.WithInstanceID("node-1"), certmagic_postgres.WithLockTimeout("1s"), certmagic_postgres.WithLockRefresh("200ms"))
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	value := []byte("value")
-	err = storage.Store("abc", value)
+	node2, err := certmagic_postgres.Open(db, certmagic_postgres.WithInstanceID("node-2"), certmagic_postgres.WithLockPollInterval("100ms"))
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	valueGot, err := storage.Load("abc")
+	err = node1.Lock(context.Background(), "abc")
 	require.Nil(t, err)
-	require.Equal(t, value, valueGot)
 
-	_, err = storage.Load("bad-key")
-	_, isErrNotExist := err.(certmagic.ErrNotExist)
-	assert.True(t, isErrNotExist)
+	// The lock outlives its timeout while refreshed
+	time.Sleep(time.Second * 2)
+	locked, err := node2.TryLock(context.Background(), "abc")
+	require.Nil(t, err)
+	assert.False(t, locked)
+
+	assert.Nil(t, node1.Unlock(context.Background(), "abc"))
+	locked, err = node2.TryLock(context.Background(), "abc")
+	require.Nil(t, err)
+	assert.True(t, locked)
 }
 
-func TestStorage_Delete(t *testing.T) {
-	db, teardown := setupDB(t)
+func TestStorage_Close_StopsLockRefresh(t *testing.T) {
+	_, teardown := setupDB(t)
 	defer teardown()
 
-	storage, err := certmagic_postgres.Open(db)
+	// Close closes the database, so don't share the one setupDB returned
+	db, err := sql.Open("pgx", getConnectionString(t))
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	err = storage.Store("abc", []byte("value"))
+	core, logs := observer.New(zapcore.WarnLevel)
+	storage, err := certmagic_postgres.Open(db, certmagic_postgres.WithLockRefresh("50ms"), certmagic_postgres.WithLogger(zap.New(core)))
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = storage.Delete("abc")
-	assert.Nil(t, err)
+	err = storage.Lock(context.Background(), "abc")
+	require.Nil(t, err)
+	require.Nil(t, storage.Close())
+
+	// Nothing keeps refreshing, or failing to refresh, the lock
+	time.Sleep(time.Millisecond * 200)
+	assert.Equal(t, 0, logs.Len())
 }
 
-func TestStorage_Exists(t *testing.T) {
+func TestStorage_WithLockRefresh_Invalid(t *testing.T) {
+	_, err := certmagic_postgres.Open(nil, certmagic_postgres.WithLockRefresh("0s"))
+	assert.NotNil(t, err)
+	_, err = certmagic_postgres.Open(nil, certmagic_postgres.WithLockPollInterval("soon"))
+	assert.NotNil(t, err)
+}
+
+func TestStorage_LockWithTTL(t *testing.T) {
 	db, teardown := setupDB(t)
 	defer teardown()
 
-	storage, err := certmagic_postgres.Open(db)
+	storage, err := certmagic_postgres.Open(db, certmagic_postgres.WithLockTimeout("1m"))
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = storage.Store("abc", []byte("value"))
-	if err != nil {
-		t.Fatal(err)
-	}
+	err = storage.LockWithTTL(context.Background(), "abc", time.Hour)
+	require.Nil(t, err)
+
+	locks, err := storage.ListLocks(context.Background())
+	require.Nil(t, err)
+	require.Len(t, locks, 1)
+	assert.True(t, locks[0].Expires.After(time.Now().Add(time.Minute*30)))
 
-	assert.Equal(t, true, storage.Exists("abc"))
-	assert.Equal(t, false, storage.Exists("xyz"))
+	err = storage.LockWithTTL(context.Background(), "xyz", 0)
+	assert.NotNil(t, err)
 }
 
-func TestStorage_List(t *testing.T) {
+func TestStorage_TryLock(t *testing.T) {
 	db, teardown := setupDB(t)
 	defer teardown()
 
@@ -184,78 +543,921 @@ func TestStorage_List(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	_ = storage.Store("abc", []byte("value"))
-	_ = storage.Store("abcde", []byte("value"))
-	_ = storage.Store("abcdefg", []byte("value"))
-	_ = storage.Store("xyz", []byte("value"))
-	_ = storage.Store("xyz123", []byte("value"))
+	locked, err := storage.TryLock(context.Background(), "abc")
+	require.Nil(t, err)
+	require.True(t, locked)
 
-	keys, err := storage.List("abc", false)
-	assert.Nil(t, err)
-	assert.Len(t, keys, 3)
-	assert.Equal(t, []string{"abc", "abcde", "abcdefg"}, keys)
+	locked, err = storage.TryLock(context.Background(), "abc")
+	require.Nil(t, err)
+	assert.False(t, locked)
+
+	err = storage.Unlock(context.Background(), "abc")
+	require.Nil(t, err)
+
+	locked, err = storage.TryLock(context.Background(), "abc")
+	require.Nil(t, err)
+	assert.True(t, locked)
 }
 
-func TestStorage_Stat(t *testing.T) {
+func TestStorage_Lock_RowStrategy(t *testing.T) {
 	db, teardown := setupDB(t)
 	defer teardown()
 
-	storage, err := certmagic_postgres.Open(db)
+	node1, err := certmagic_postgres.Open(db, certmagic_postgres.WithLockStrategy(certmagic_postgres.LockStrategyRow))
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	err = storage.Store("abc", []byte("value"))
+	node2, err := certmagic_postgres.Open(db, certmagic_postgres.WithLockStrategy(certmagic_postgres.LockStrategyRow))
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	keyInfo, err := storage.Stat("abc")
-	assert.Nil(t, err)
-	assert.Equal(t, "abc", keyInfo.Key)
-	assert.Equal(t, int64(5), keyInfo.Size)
-	assert.NotZero(t, keyInfo.Modified)
-	assert.True(t, keyInfo.IsTerminal)
-}
+	err = node1.Lock(context.Background(), "abc")
+	require.Nil(t, err)
 
-// Set an env var TEST_CONNECTION_STRING to run these tests - e.g. TEST_CONNECTION_STRING=postgres://localhost/norris_sites_test?sslmode=disable
+	locked, err := node2.TryLock(context.Background(), "abc")
+	require.Nil(t, err)
+	assert.False(t, locked)
 
-func getConnectionString(t *testing.T) string {
-	connectionString := os.Getenv("TEST_CONNECTION_STRING")
-	if connectionString == "" {
-		t.Skip("set TEST_CONNECTION_STRING to run this test")
-	}
-	return connectionString
+	err = node1.Unlock(context.Background(), "abc")
+	require.Nil(t, err)
+
+	locked, err = node2.TryLock(context.Background(), "abc")
+	require.Nil(t, err)
+	assert.True(t, locked)
+	assert.Nil(t, node2.Unlock(context.Background(), "abc"))
 }
 
-func setupDB(t *testing.T) (*sql.DB, func()) {
-	connectionString := getConnectionString(t)
+func TestStorage_WithLockStrategy_Invalid(t *testing.T) {
+	_, err := certmagic_postgres.Open(nil, certmagic_postgres.WithLockStrategy("optimistic"))
+	assert.NotNil(t, err)
+}
 
-	db, err := sql.Open("pgx", connectionString)
+func TestStorage_Unlock(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	executeSQL(t, db, "./db/20200721125602_baseline.down.sql")
-	executeSQL(t, db, "./db/20200721125602_baseline.up.sql")
-
-	teardown := func() {
-		executeSQL(t, db, "./db/20200721125602_baseline.down.sql")
+	err = storage.Lock(context.Background(), "abc")
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	return db, teardown
+	err = storage.Unlock(context.Background(), "abc")
+	assert.Nil(t, err)
 }
 
-func executeSQL(t *testing.T, db *sql.DB, path string) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+func TestStorage_Unlock_OtherHolder(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
 
-	query, err := ioutil.ReadFile(path)
+	node1, err := certmagic_postgres.Open(db, certmagic_postgres.WithInstanceID("node-1"))
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = db.ExecContext(ctx, string(query))
+	node2, err := certmagic_postgres.Open(db, certmagic_postgres.WithInstanceID("node-2"))
 	if err != nil {
 		t.Fatal(err)
 	}
+
+	err = node1.Lock(context.Background(), "abc")
+	require.Nil(t, err)
+
+	err = node2.Unlock(context.Background(), "abc")
+	require.Nil(t, err)
+
+	locks, err := node1.ListLocks(context.Background())
+	require.Nil(t, err)
+	require.Len(t, locks, 1)
+	assert.Equal(t, "node-1", locks[0].Holder)
+}
+
+func TestStorage_ForceUnlock(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db, certmagic_postgres.WithLockTimeout("24h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.Lock(context.Background(), "abc")
+	require.Nil(t, err)
+
+	err = storage.ForceUnlock(context.Background(), "abc")
+	require.Nil(t, err)
+
+	locked, err := storage.TryLock(context.Background(), "abc")
+	require.Nil(t, err)
+	assert.True(t, locked)
+
+	err = storage.ForceUnlock(context.Background(), "xyz")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+}
+
+func TestStorage_ListLocks(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.Lock(context.Background(), "abc")
+	require.Nil(t, err)
+	err = storage.Lock(context.Background(), "xyz")
+	require.Nil(t, err)
+
+	locks, err := storage.ListLocks(context.Background())
+	require.Nil(t, err)
+	require.Len(t, locks, 2)
+	assert.Equal(t, "abc", locks[0].Key)
+	assert.NotEmpty(t, locks[0].Holder)
+	assert.Equal(t, "xyz", locks[1].Key)
+	assert.NotZero(t, locks[0].Acquired)
+	assert.True(t, locks[0].Expires.After(locks[0].Acquired))
+}
+
+func TestStorage_Store(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value := []byte("value")
+	err = storage.Store(context.Background(), "abc", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valueGot, err := storage.Load(context.Background(), "abc")
+	assert.Equal(t, value, valueGot)
+	assert.Nil(t, err)
+}
+
+func TestStorage_Store_AsynchronousCommit(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db, certmagic_postgres.WithAsynchronousCommit("ocsp"), certmagic_postgres.WithAsynchronousLockCommit())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.Store(context.Background(), "ocsp/example.com-abc", []byte("staple"))
+	require.Nil(t, err)
+	value, err := storage.Load(context.Background(), "ocsp/example.com-abc")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("staple"), value)
+
+	err = storage.Lock(context.Background(), "abc")
+	require.Nil(t, err)
+	err = storage.Unlock(context.Background(), "abc")
+	require.Nil(t, err)
+}
+
+func TestStorage_StoreWithModTime(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modTime := time.Date(2020, 7, 21, 12, 56, 2, 0, time.UTC)
+	err = storage.StoreWithModTime(context.Background(), "abc", []byte("value"), modTime)
+	require.Nil(t, err)
+
+	info, err := storage.Stat(context.Background(), "abc")
+	require.Nil(t, err)
+	assert.True(t, info.Modified.Equal(modTime))
+
+	modTime = modTime.Add(time.Hour)
+	err = storage.StoreWithModTime(context.Background(), "abc", []byte("updated"), modTime)
+	require.Nil(t, err)
+
+	info, err = storage.Stat(context.Background(), "abc")
+	require.Nil(t, err)
+	assert.True(t, info.Modified.Equal(modTime))
+	assert.Equal(t, int64(7), info.Size)
+}
+
+func TestStorage_Load(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value := []byte("value")
+	err = storage.Store(context.Background(), "abc", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valueGot, err := storage.Load(context.Background(), "abc")
+	require.Nil(t, err)
+	require.Equal(t, value, valueGot)
+
+	_, err = storage.Load(context.Background(), "bad-key")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+}
+
+func TestStorage_Delete(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.Store(context.Background(), "abc", []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.Delete(context.Background(), "abc")
+	assert.Nil(t, err)
+	var created bool
+	err = db.QueryRow(`SELECT to_regclass('certmagic_data') IS NOT NULL`).Scan(&created)
+	require.Nil(t, err)
+	assert.False(t, created)
+
+	err = storage.Delete(context.Background(), "abc")
+	assert.Nil(t, err)
+}
+
+func TestStorage_Delete_Strict(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db, certmagic_postgres.WithStrictDelete())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.Store(context.Background(), "abc", []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.Delete(context.Background(), "abc")
+	assert.Nil(t, err)
+
+	err = storage.Delete(context.Background(), "abc")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+}
+
+func TestStorage_Exists(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.Store(context.Background(), "abc", []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, true, storage.Exists(context.Background(), "abc"))
+	assert.Equal(t, false, storage.Exists(context.Background(), "xyz"))
+}
+
+func TestStorage_List(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = storage.Store(context.Background(), "abc/1", []byte("value"))
+	_ = storage.Store(context.Background(), "abc/2/3", []byte("value"))
+	_ = storage.Store(context.Background(), "abc/2/4", []byte("value"))
+	_ = storage.Store(context.Background(), "abcdefg", []byte("value"))
+	_ = storage.Store(context.Background(), "xyz/123", []byte("value"))
+
+	keys, err := storage.List(context.Background(), "abc", false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"abc/1", "abc/2"}, keys)
+
+	keys, err = storage.List(context.Background(), "abc", true)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"abc/1", "abc/2", "abc/2/3", "abc/2/4"}, keys)
+}
+
+func TestStorage_List_ManyKeys(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make(map[string][]byte)
+	for i := 0; i < 2500; i++ {
+		values[fmt.Sprintf("abc/%d/%04d", i%3, i)] = []byte("value")
+	}
+	require.NoError(t, storage.StoreBatch(context.Background(), values))
+
+	keys, err := storage.List(context.Background(), "abc", true)
+	assert.Nil(t, err)
+	assert.Len(t, keys, 2503)
+	assert.Equal(t, []string{"abc/0", "abc/0/0000", "abc/0/0003"}, keys[:3])
+}
+
+func TestStorage_ExportImport(t *testing.T) {
+	_, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Connect(getConnectionString(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make(map[string][]byte)
+	for i := 0; i < 2500; i++ {
+		values[fmt.Sprintf("certificates/acme/example%d.com/example%d.com.crt", i, i)] = []byte(fmt.Sprintf("value %d", i))
+	}
+	require.NoError(t, storage.StoreBatch(context.Background(), values))
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, "caddy.json")
+	archive := filepath.Join(dir, "export.tar")
+	b, err := json.Marshal(map[string]interface{}{
+		"storage": map[string]interface{}{"module": "postgres", "connection_string": getConnectionString(t)},
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(config, b, 0o600))
+
+	runStorageCommand(t, "export", "--config", config, "--output", archive)
+
+	_, err = storage.DeleteAll(context.Background(), "certificates")
+	require.NoError(t, err)
+
+	runStorageCommand(t, "import", "--config", config, "--input", archive)
+
+	keys, err := storage.List(context.Background(), "certificates", true)
+	require.NoError(t, err)
+	assert.Len(t, keys, 1+2*len(values))
+	loaded, err := storage.LoadMany(context.Background(), mapKeys(values))
+	require.NoError(t, err)
+	assert.Equal(t, values, loaded)
+}
+
+func TestStorage_ListPage(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = storage.Store(context.Background(), "abc/1", []byte("value"))
+	_ = storage.Store(context.Background(), "abc/2/3", []byte("value"))
+	_ = storage.Store(context.Background(), "abc/4", []byte("value"))
+	_ = storage.Store(context.Background(), "abc/5", []byte("value"))
+	_ = storage.Store(context.Background(), "xyz/6", []byte("value"))
+
+	var pages [][]string
+	after := ""
+	for {
+		keys, err := storage.ListPage(context.Background(), "abc", after, 2)
+		require.Nil(t, err)
+		pages = append(pages, keys)
+		if len(keys) < 2 {
+			break
+		}
+		after = keys[len(keys)-1]
+	}
+
+	assert.Equal(t, [][]string{{"abc/1", "abc/2/3"}, {"abc/4", "abc/5"}, {}}, pages)
+}
+
+func TestStorage_ListWithInfo(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = storage.Store(context.Background(), "abc/1", []byte("value"))
+	_ = storage.Store(context.Background(), "abc/2/3", []byte("longer value"))
+
+	infos, err := storage.ListWithInfo(context.Background(), "abc", false)
+	require.Nil(t, err)
+	require.Len(t, infos, 2)
+	assert.Equal(t, "abc/1", infos[0].Key)
+	assert.Equal(t, int64(5), infos[0].Size)
+	assert.True(t, infos[0].IsTerminal)
+	assert.NotZero(t, infos[0].Modified)
+	assert.Equal(t, "abc/2", infos[1].Key)
+	assert.False(t, infos[1].IsTerminal)
+
+	infos, err = storage.ListWithInfo(context.Background(), "abc", true)
+	require.Nil(t, err)
+	require.Len(t, infos, 3)
+	assert.Equal(t, "abc/2/3", infos[2].Key)
+	assert.Equal(t, int64(12), infos[2].Size)
+}
+
+func TestStorage_ListMatch(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = storage.Store(context.Background(), "certificates/acme/example.com/example.com.crt", []byte("value"))
+	_ = storage.Store(context.Background(), "certificates/zerossl/example.com/example.com.crt", []byte("value"))
+	_ = storage.Store(context.Background(), "certificates/acme/exampleXcom/exampleXcom.crt", []byte("value"))
+	_ = storage.Store(context.Background(), "certificates/acme/example.org/example.org.crt", []byte("value"))
+
+	keys, err := storage.ListMatch(context.Background(), "certificates/*/example.com/*")
+	require.Nil(t, err)
+	assert.Equal(t, []string{
+		"certificates/acme/example.com/example.com.crt",
+		"certificates/zerossl/example.com/example.com.crt",
+	}, keys)
+}
+
+func TestStorage_Certificates(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	soon := time.Now().Add(time.Hour * 24).Truncate(time.Second).UTC()
+	later := time.Now().Add(time.Hour * 24 * 60).Truncate(time.Second)
+	_ = storage.Store(context.Background(), "certificates/acme/example.com/example.com.crt", testCertificate(t, "example.com", later))
+	_ = storage.Store(context.Background(), "certificates/acme/example.com/example.com.key", []byte("private key"))
+	_ = storage.Store(context.Background(), "certificates/zerossl/example.org/example.org.crt", testCertificate(t, "example.org", soon))
+	_ = storage.Store(context.Background(), "certificates/acme/broken/broken.crt", []byte("not a certificate"))
+
+	certificates, err := storage.Certificates(context.Background())
+	require.Nil(t, err)
+	require.Len(t, certificates, 2)
+	assert.Equal(t, certmagic_postgres.CertificateInfo{Key: "certificates/zerossl/example.org/example.org.crt", Issuer: "zerossl", Domain: "example.org", Names: []string{"example.org"}, NotBefore: certificates[0].NotBefore, NotAfter: soon}, certificates[0])
+	assert.Equal(t, "example.com", certificates[1].Domain)
+	assert.True(t, certificates[1].NotAfter.Equal(later))
+}
+
+func TestStorage_CleanStorage(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	past := time.Now().Add(-time.Hour * 24 * 30)
+	_ = storage.StoreWithModTime(ctx, "certificates/acme/expired.com/expired.com.crt", testCertificate(t, "expired.com", time.Now().Add(-time.Hour*24*20)), past)
+	_ = storage.StoreWithModTime(ctx, "certificates/acme/expired.com/expired.com.key", []byte("private key"), past)
+	_ = storage.Store(ctx, "certificates/acme/valid.com/valid.com.crt", testCertificate(t, "valid.com", time.Now().Add(time.Hour*24*60)))
+	_ = storage.StoreWithModTime(ctx, "certificates/acme/valid.com/valid.com.key", []byte("private key"), past)
+	_ = storage.StoreWithModTime(ctx, "certificates/acme/orphan.com/orphan.com.key", []byte("private key"), past)
+	_ = storage.Store(ctx, "certificates/acme/issuing.com/issuing.com.key", []byte("private key"))
+	_ = storage.StoreWithModTime(ctx, "ocsp/valid.com-1234", []byte("staple"), past)
+	_ = storage.Store(ctx, "ocsp/valid.com-5678", []byte("staple"))
+
+	result, err := storage.CleanStorage(ctx, certmagic_postgres.DefaultCleanOptions)
+	require.Nil(t, err)
+	assert.Equal(t, certmagic_postgres.CleanResult{ExpiredCerts: 2, OCSPStaples: 1, Orphans: 1}, result)
+
+	keys, err := storage.List(ctx, "", true)
+	require.Nil(t, err)
+	sort.Strings(keys)
+	assert.Equal(t, []string{
+		"certificates/acme/issuing.com/issuing.com.key",
+		"certificates/acme/valid.com/valid.com.crt",
+		"certificates/acme/valid.com/valid.com.key",
+		"ocsp/valid.com-5678",
+	}, keys)
+}
+
+func TestStorage_AuditLog(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db, certmagic_postgres.WithAuditLog(0), certmagic_postgres.WithInstanceID("node-1"), certmagic_postgres.WithKeyPrefix("prod/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	start := time.Now().Add(-time.Minute)
+	require.Nil(t, storage.Lock(ctx, "issue_cert_example.com"))
+	require.Nil(t, storage.Store(ctx, "certificates/acme/example.com/example.com.key", []byte("private key")))
+	require.Nil(t, storage.Unlock(ctx, "issue_cert_example.com"))
+	require.Nil(t, storage.Delete(ctx, "certificates/acme/example.com/example.com.key"))
+	_, err = storage.DeleteAll(ctx, "certificates")
+	require.Nil(t, err)
+
+	entries, err := storage.AuditLog(ctx, start)
+	require.Nil(t, err)
+	operations := make([]string, len(entries))
+	for i, entry := range entries {
+		operations[i] = entry.Operation + " " + entry.Key
+		assert.Equal(t, "node-1", entry.InstanceID)
+	}
+	assert.Equal(t, []string{
+		"lock prod/issue_cert_example.com",
+		"store prod/certificates/acme/example.com/example.com.key",
+		"unlock prod/issue_cert_example.com",
+		"delete prod/certificates/acme/example.com/example.com.key",
+	}, operations)
+
+	deleted, err := storage.PruneAuditLog(ctx, time.Now().Add(time.Minute))
+	require.Nil(t, err)
+	assert.Equal(t, int64(4), deleted)
+	entries, err = storage.AuditLog(ctx, start)
+	require.Nil(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestStorage_List_HostilePrefix(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = storage.Store(context.Background(), "a%c/1", []byte("value"))
+	_ = storage.Store(context.Background(), "a_c/2", []byte("value"))
+	_ = storage.Store(context.Background(), "abc/3", []byte("value"))
+	_ = storage.Store(context.Background(), `a\c/4`, []byte("value"))
+
+	tt := []struct {
+		prefix   string
+		expected []string
+	}{
+		{prefix: "a%c", expected: []string{"a%c/1"}},
+		{prefix: "a_c", expected: []string{"a_c/2"}},
+		{prefix: `a\c`, expected: []string{`a\c/4`}},
+		{prefix: "%", expected: nil},
+		{prefix: "abc' OR '1'='1", expected: nil},
+		{prefix: "'; DROP TABLE certmagic_data; --", expected: nil},
+	}
+	for _, tc := range tt {
+		keys, err := storage.List(context.Background(), tc.prefix, false)
+		assert.Nil(t, err, tc.prefix)
+		assert.Equal(t, tc.expected, keys, tc.prefix)
+	}
+
+	assert.True(t, storage.Exists(context.Background(), "abc/3"))
+}
+
+func TestStorage_Stat(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.Store(context.Background(), "abc", []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyInfo, err := storage.Stat(context.Background(), "abc")
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", keyInfo.Key)
+	assert.Equal(t, int64(5), keyInfo.Size)
+	assert.NotZero(t, keyInfo.Modified)
+	assert.True(t, keyInfo.IsTerminal)
+
+	_, err = storage.Stat(context.Background(), "xyz")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+	err = storage.Store(context.Background(), "dir/sub/key", []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dir := range []string{"dir", "dir/sub"} {
+		keyInfo, err = storage.Stat(context.Background(), dir)
+		assert.Nil(t, err)
+		assert.Equal(t, dir, keyInfo.Key)
+		assert.NotZero(t, keyInfo.Modified)
+		assert.False(t, keyInfo.IsTerminal)
+	}
+
+	_, err = storage.Stat(context.Background(), "di")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+}
+
+func TestStorage_Legacy(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := storage.Legacy()
+
+	err = legacy.Store("abc", []byte("value"))
+	require.Nil(t, err)
+
+	value, err := legacy.Load("abc")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+	assert.True(t, legacy.Exists("abc"))
+
+	err = legacy.Delete("abc")
+	require.Nil(t, err)
+	assert.False(t, legacy.Exists("abc"))
+}
+
+func TestStorage_StatExtended(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.Store(context.Background(), "abc", []byte("value"))
+	require.Nil(t, err)
+	first, err := storage.StatExtended(context.Background(), "abc")
+	require.Nil(t, err)
+
+	time.Sleep(time.Millisecond * 10)
+	err = storage.Store(context.Background(), "abc", []byte("updated"))
+	require.Nil(t, err)
+	second, err := storage.StatExtended(context.Background(), "abc")
+	require.Nil(t, err)
+
+	assert.Equal(t, "abc", second.Key)
+	assert.Equal(t, int64(7), second.Size)
+	assert.True(t, first.Created.Equal(second.Created))
+	assert.True(t, second.Modified.After(second.Created))
+
+	_, err = storage.StatExtended(context.Background(), "xyz")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+}
+
+func TestStorage_ListCreatedBefore(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.Store(context.Background(), "certificates/old.crt", []byte("value"))
+	require.Nil(t, err)
+	time.Sleep(time.Millisecond * 10)
+	cutoff := time.Now()
+	time.Sleep(time.Millisecond * 10)
+	err = storage.Store(context.Background(), "certificates/new.crt", []byte("value"))
+	require.Nil(t, err)
+	err = storage.Store(context.Background(), "certificates/old.crt", []byte("renewed"))
+	require.Nil(t, err)
+
+	keys, err := storage.ListCreatedBefore(context.Background(), "certificates", cutoff)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"certificates/old.crt"}, keys)
+}
+
+// Set an env var TEST_CONNECTION_STRING to run these tests - e.g. TEST_CONNECTION_STRING=postgres://localhost/norris_sites_test?sslmode=disable
+
+func TestStorage_KeyCodec(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db, certmagic_postgres.WithKeyCodec(certmagic_postgres.HashLongKeys(16)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	long := "certificates/acme/" + strings.Repeat("a", 100) + ".example.com"
+	err = storage.Store(context.Background(), long, []byte("long"))
+	require.Nil(t, err)
+	err = storage.Store(context.Background(), "short", []byte("short"))
+	require.Nil(t, err)
+
+	value, err := storage.Load(context.Background(), long)
+	require.Nil(t, err)
+	assert.Equal(t, []byte("long"), value)
+	assert.True(t, storage.Exists(context.Background(), long))
+
+	keys, err := storage.List(context.Background(), "certificates", true)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"certificates/acme", long}, keys)
+
+	values, err := storage.LoadMany(context.Background(), []string{long, "short"})
+	require.Nil(t, err)
+	assert.Equal(t, map[string][]byte{long: []byte("long"), "short": []byte("short")}, values)
+
+	err = storage.Delete(context.Background(), long)
+	require.Nil(t, err)
+	assert.False(t, storage.Exists(context.Background(), long))
+}
+
+func TestStorage_KeyPrefix(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	prod, err := certmagic_postgres.Open(db, certmagic_postgres.WithKeyPrefix("prod/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	staging, err := certmagic_postgres.Open(db, certmagic_postgres.WithKeyPrefix("staging/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = prod.Store(context.Background(), "certificates/a.crt", []byte("prod"))
+	require.Nil(t, err)
+	err = staging.Store(context.Background(), "certificates/a.crt", []byte("staging"))
+	require.Nil(t, err)
+
+	value, err := prod.Load(context.Background(), "certificates/a.crt")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("prod"), value)
+
+	keys, err := prod.List(context.Background(), "", true)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"certificates", "certificates/a.crt"}, keys)
+
+	keys, err = staging.ListMatch(context.Background(), "certificates/*")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"certificates/a.crt"}, keys)
+
+	deleted, err := staging.DeleteAll(context.Background(), "certificates")
+	require.Nil(t, err)
+	assert.Equal(t, int64(1), deleted)
+	assert.True(t, prod.Exists(context.Background(), "certificates/a.crt"))
+}
+
+func TestStorage_KeyPrefix_Locks(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	prod, err := certmagic_postgres.Open(db, certmagic_postgres.WithKeyPrefix("prod/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	staging, err := certmagic_postgres.Open(db, certmagic_postgres.WithKeyPrefix("staging/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both prefixes hold the lock of the same name at once
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i, storage := range []certmagic_postgres.Storage{prod, staging} {
+		wg.Add(1)
+		go func(i int, storage certmagic_postgres.Storage) {
+			defer wg.Done()
+			errs[i] = storage.Lock(ctx, "issue_cert_example.com")
+		}(i, storage)
+	}
+	wg.Wait()
+	require.Nil(t, errs[0])
+	require.Nil(t, errs[1])
+
+	// Another instance of the same prefix must still wait
+	other, err := certmagic_postgres.Open(db, certmagic_postgres.WithKeyPrefix("prod/"), certmagic_postgres.WithInstanceID("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked, err := other.TryLock(context.Background(), "issue_cert_example.com")
+	require.Nil(t, err)
+	assert.False(t, locked)
+
+	locks, err := prod.ListLocks(context.Background())
+	require.Nil(t, err)
+	require.Len(t, locks, 1)
+	assert.Equal(t, "issue_cert_example.com", locks[0].Key)
+
+	require.Nil(t, prod.Unlock(context.Background(), "issue_cert_example.com"))
+	locks, err = staging.ListLocks(context.Background())
+	require.Nil(t, err)
+	assert.Len(t, locks, 1)
+	require.Nil(t, staging.Unlock(context.Background(), "issue_cert_example.com"))
+}
+
+func getConnectionString(t *testing.T) string {
+	connectionString := os.Getenv("TEST_CONNECTION_STRING")
+	if connectionString == "" {
+		t.Skip("set TEST_CONNECTION_STRING to run this test")
+	}
+	return connectionString
+}
+
+// runStorageCommand runs `caddy storage` with args.
+func runStorageCommand(t *testing.T, args ...string) {
+	cmd := &cobra.Command{Use: "storage"}
+	caddycmd.Commands()["storage"].CobraFunc(cmd)
+	cmd.SetArgs(args)
+	require.NoError(t, cmd.Execute())
+}
+
+func mapKeys(values map[string][]byte) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func setupDB(t *testing.T) (*sql.DB, func()) {
+	connectionString := getConnectionString(t)
+
+	db, err := sql.Open("pgx", connectionString)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrateDown(t, db)
+	migrateUp(t, db)
+
+	teardown := func() {
+		migrateDown(t, db)
+	}
+
+	return db, teardown
+}
+
+func migrateUp(t *testing.T, db *sql.DB) {
+	for _, path := range migrationFiles(t, ".up.sql") {
+		executeSQL(t, db, path)
+	}
+}
+
+func migrateDown(t *testing.T, db *sql.DB) {
+	paths := migrationFiles(t, ".down.sql")
+	for i := len(paths) - 1; i >= 0; i-- {
+		executeSQL(t, db, paths[i])
+	}
+}
+
+func migrationFiles(t *testing.T, suffix string) []string {
+	paths, err := filepath.Glob("./db/*" + suffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func executeSQL(t *testing.T, db *sql.DB, path string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.ExecContext(ctx, string(query))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testCertificate returns a PEM encoded self-signed certificate for name.
+func testCertificate(t *testing.T, name string, notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), DNSNames: []string{name}, NotBefore: time.Now().Add(-time.Hour * 24 * 90), NotAfter: notAfter}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
 }
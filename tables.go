@@ -0,0 +1,139 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Default names of the tables holding values and locks, which queries
+// and migrations are written against.
+const (
+	defaultDataTable  = "certmagic_data"
+	defaultLocksTable = "certmagic_locks"
+)
+
+// tableNamePattern matches the table names accepted by WithTableNames.
+// They are short enough for the indexes and partitions named after
+// them to fit PostgreSQL's 63 byte identifiers.
+var tableNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,31}$`)
+
+// reservedTableNames are the names of the other tables of the storage.
+var reservedTableNames = map[string]bool{
+	"certmagic_blobs":          true,
+	"certmagic_audit":          true,
+	"certmagic_schema_version": true,
+}
+
+// WithTableNames names the tables holding values and locks, instead of
+// certmagic_data and certmagic_locks, to comply with naming policies.
+// Their primary keys and indexes are named after them, e.g.
+// <data>_pkey. Names must be lowercase identifiers of up to 32
+// characters. The other tables keep their names, including
+// certmagic_schema_version and certmagic_blobs, so storages with
+// different table names need a schema each (see WithSchema). Migrate
+// fails when the schema records migrations applied to other tables.
+func WithTableNames(data, locks string) Option {
+	return func(storage Storage) (Storage, error) {
+		for _, name := range []string{data, locks} {
+			if !tableNamePattern.MatchString(name) {
+				return storage, fmt.Errorf("invalid table name: %q, must be a lowercase identifier of up to 32 characters", name)
+			}
+			if reservedTableNames[name] {
+				return storage, fmt.Errorf("invalid table name: %s is used by the storage", name)
+			}
+		}
+		if data == locks {
+			return storage, fmt.Errorf("invalid table names: data and locks tables must differ")
+		}
+		// Names containing a default name would be replaced again
+		for _, table := range []struct{ name, defaultName string }{{data, defaultDataTable}, {locks, defaultLocksTable}} {
+			if table.name != table.defaultName && (strings.Contains(table.name, defaultDataTable) || strings.Contains(table.name, defaultLocksTable)) {
+				return storage, fmt.Errorf("invalid table name: %s must not contain %s or %s", table.name, defaultDataTable, defaultLocksTable)
+			}
+		}
+		if data == defaultDataTable && locks == defaultLocksTable {
+			storage.tableNames = nil
+		} else {
+			storage.tableNames = strings.NewReplacer(defaultDataTable, data, defaultLocksTable, locks)
+		}
+		return storage, nil
+	}
+}
+
+// tables returns query, written against the default table names, with
+// the storage's table names. The names of constraints and indexes
+// starting with those of the tables are replaced as well.
+func (s Storage) tables(query string) string {
+	if s.tableNames == nil {
+		return query
+	}
+	return s.tableNames.Replace(query)
+}
+
+// tableName returns the storage's name for the table named table by
+// default.
+func (s Storage) tableName(table string) string {
+	return s.tables(table)
+}
+
+// checkTableNames fails if migrations are recorded as applied, but
+// not to the storage's tables, which are then missing. As the version
+// and blob tables keep their names, storages with different table
+// names can't share a schema.
+func (s Storage) checkTableNames(ctx context.Context, q querier, applied map[string]bool) error {
+	if len(applied) == 0 {
+		return nil
+	}
+	for _, table := range []string{defaultDataTable, defaultLocksTable} {
+		var exists bool
+		if err := q.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, s.tableName(table)).Scan(&exists); err != nil {
+			return fmt.Errorf("failed scan: %w", err)
+		}
+		if !exists {
+			return fmt.Errorf("invalid table names: certmagic_schema_version records migrations applied to tables other than %s, use a separate schema for each set of table names", s.tableName(table))
+		}
+	}
+	return nil
+}
+
+// tableQuerier runs queries written against the default table names
+// with the storage's table names, for the migration helpers.
+type tableQuerier struct {
+	querier
+	s Storage
+}
+
+// withTables returns q running queries with the storage's table names.
+func (s Storage) withTables(q querier) querier {
+	if s.tableNames == nil {
+		return q
+	}
+	return tableQuerier{querier: q, s: s}
+}
+
+func (q tableQuerier) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return q.querier.ExecContext(ctx, q.s.tables(query), args...)
+}
+
+func (q tableQuerier) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return q.querier.QueryContext(ctx, q.s.tables(query), args...)
+}
+
+func (q tableQuerier) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return q.querier.QueryRowContext(ctx, q.s.tables(query), args...)
+}
+
+// migrationTables returns migrations with the storage's table names.
+func (s Storage) migrationTables(migrations []Migration) []Migration {
+	if s.tableNames == nil {
+		return migrations
+	}
+	renamed := make([]Migration, len(migrations))
+	for i, migration := range migrations {
+		renamed[i] = Migration{Version: migration.Version, SQL: s.tables(migration.SQL)}
+	}
+	return renamed
+}
@@ -0,0 +1,49 @@
+package certmagic_postgres
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestWithTableNames(t *testing.T) {
+	tt := []struct {
+		data, locks string
+		valid       bool
+	}{
+		{data: "acme_data", locks: "acme_locks", valid: true},
+		{data: "certmagic_data", locks: "acme_locks", valid: true},
+		{data: "Acme_Data", locks: "acme_locks"},
+		{data: "acme-data", locks: "acme_locks"},
+		{data: "", locks: "acme_locks"},
+		{data: "acme_data_with_a_very_long_table_name", locks: "acme_locks"},
+		{data: "acme", locks: "acme"},
+		{data: "certmagic_blobs", locks: "acme_locks"},
+		{data: "certmagic_locks", locks: "certmagic_data"},
+		{data: "certmagic_data_v2", locks: "acme_locks"},
+	}
+	for _, tc := range tt {
+		_, err := Open(nil, WithTableNames(tc.data, tc.locks))
+		assert.Equal(t, tc.valid, err == nil, "%s, %s", tc.data, tc.locks)
+	}
+}
+
+func TestStorage_Tables(t *testing.T) {
+	storage, err := Open(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := `SELECT 1 FROM certmagic_data d JOIN certmagic_blobs b ON b.key = d.key`
+	assert.Equal(t, query, storage.tables(query))
+
+	storage, err = Open(nil, WithTableNames("acme_data", "acme_locks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, `SELECT 1 FROM acme_data d JOIN certmagic_blobs b ON b.key = d.key`, storage.tables(query))
+	assert.Equal(t, `ALTER TABLE acme_locks DROP CONSTRAINT acme_locks_pkey`, storage.tables(`ALTER TABLE certmagic_locks DROP CONSTRAINT certmagic_locks_pkey`))
+	assert.Equal(t, `SELECT nextval('certmagic_lock_fence_seq')`, storage.tables(`SELECT nextval('certmagic_lock_fence_seq')`))
+	assert.Equal(t, "acme_data", storage.tableName(defaultDataTable))
+
+	migrations := storage.migrationTables([]Migration{{Version: "1_a", SQL: "CREATE TABLE certmagic_data (key text)"}})
+	assert.Equal(t, []Migration{{Version: "1_a", SQL: "CREATE TABLE acme_data (key text)"}}, migrations)
+}
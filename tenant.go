@@ -0,0 +1,51 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"fmt"
+)
+
+// WithTenant scopes every data key and lock to the tenant id, which
+// is recorded in the tenant column of each row. Storages with
+// different tenants can share the same tables without seeing each
+// other's keys or contending for each other's locks. Without it,
+// rows belong to the default tenant "".
+func WithTenant(id string) Option {
+	return func(storage Storage) (Storage, error) {
+		storage.tenant = id
+		return storage, nil
+	}
+}
+
+// DeleteTenant deletes every key and lock of the storage's tenant,
+// returning the number of keys deleted. Other tenants are not
+// affected. The default tenant "" is refused, as it would delete
+// everything stored without WithTenant.
+func (s Storage) DeleteTenant(ctx context.Context) (int64, error) {
+	if s.tenant == "" {
+		return 0, fmt.Errorf("refusing to delete the default tenant")
+	}
+
+	return runWithResult(ctx, s, opWrite, func(ctx context.Context) (int64, error) {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return 0, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		result, err := tx.ExecContext(ctx, s.tables(`DELETE FROM certmagic_data WHERE tenant = $1`), s.tenant)
+		if err != nil {
+			return 0, fmt.Errorf("failed exec: %w", err)
+		}
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to get affected rows: %w", err)
+		}
+
+		if _, err := tx.ExecContext(ctx, s.tables(`DELETE FROM certmagic_locks WHERE tenant = $1`), s.tenant); err != nil {
+			return 0, fmt.Errorf("failed exec: %w", err)
+		}
+
+		return deleted, tx.Commit()
+	})
+}
@@ -0,0 +1,87 @@
+package certmagic_postgres_test
+
+import (
+	"context"
+	"github.com/fluidgalleries/certmagic-postgres"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestStorage_Tenant(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	a, err := certmagic_postgres.Open(db, certmagic_postgres.WithTenant("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := certmagic_postgres.Open(db, certmagic_postgres.WithTenant("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = a.Store(context.Background(), "certificates/a.crt", []byte("a"))
+	require.Nil(t, err)
+	err = b.Store(context.Background(), "certificates/a.crt", []byte("b"))
+	require.Nil(t, err)
+
+	value, err := a.Load(context.Background(), "certificates/a.crt")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("a"), value)
+
+	err = a.Lock(context.Background(), "issue")
+	require.Nil(t, err)
+	locked, err := b.TryLock(context.Background(), "issue")
+	require.Nil(t, err)
+	assert.True(t, locked)
+
+	deleted, err := b.DeleteTenant(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, int64(1), deleted)
+	assert.False(t, b.Exists(context.Background(), "certificates/a.crt"))
+	assert.True(t, a.Exists(context.Background(), "certificates/a.crt"))
+
+	locks, err := b.ListLocks(context.Background())
+	require.Nil(t, err)
+	assert.Empty(t, locks)
+}
+
+func TestStorage_DeleteTenant_Default(t *testing.T) {
+	storage, err := certmagic_postgres.Open(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = storage.DeleteTenant(context.Background())
+	assert.NotNil(t, err)
+}
+
+func TestStorage_RowLevelSecurity(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Connect(getConnectionString(t), certmagic_postgres.WithTenant("a"), certmagic_postgres.WithRowLevelSecurity(), certmagic_postgres.WithAutoMigrate())
+	require.Nil(t, err)
+	defer storage.Close()
+
+	var enabled, forced bool
+	err = db.QueryRow(`SELECT relrowsecurity, relforcerowsecurity FROM pg_class WHERE oid = 'certmagic_data'::regclass`).Scan(&enabled, &forced)
+	require.Nil(t, err)
+	assert.True(t, enabled)
+	assert.True(t, forced)
+
+	err = storage.Store(context.Background(), "abc", []byte("value"))
+	require.Nil(t, err)
+	value, err := storage.Load(context.Background(), "abc")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+}
+
+func TestStorage_RowLevelSecurity_Distributed(t *testing.T) {
+	storage, err := certmagic_postgres.Open(nil, certmagic_postgres.WithDialect(certmagic_postgres.DialectCockroachDB), certmagic_postgres.WithRowLevelSecurity())
+	require.Nil(t, err)
+
+	_, err = storage.Migrate(context.Background())
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,89 @@
+package certmagic_postgres
+
+import (
+	"fmt"
+	"time"
+)
+
+// opClass classifies storage operations so that each class can be
+// given its own timeout.
+type opClass int
+
+const (
+	// opDefault operations, such as locking, use the query timeout.
+	opDefault opClass = iota
+	opRead
+	opWrite
+	opList
+)
+
+// String returns the name of the class, as logged.
+func (c opClass) String() string {
+	switch c {
+	case opRead:
+		return "read"
+	case opWrite:
+		return "write"
+	case opList:
+		return "list"
+	}
+	return "default"
+}
+
+// WithReadTimeout bounds each attempt of Load, Exists, Stat and the
+// other single-key read operations, instead of the query timeout.
+func WithReadTimeout(timeout string) Option {
+	return func(storage Storage) (Storage, error) {
+		readTimeout, err := time.ParseDuration(timeout)
+		if err != nil {
+			return storage, fmt.Errorf("invalid read timeout: %w", err)
+		}
+		storage.readTimeout = readTimeout
+		return storage, nil
+	}
+}
+
+// WithWriteTimeout bounds each attempt of Store, Delete and the
+// other write operations, instead of the query timeout.
+func WithWriteTimeout(timeout string) Option {
+	return func(storage Storage) (Storage, error) {
+		writeTimeout, err := time.ParseDuration(timeout)
+		if err != nil {
+			return storage, fmt.Errorf("invalid write timeout: %w", err)
+		}
+		storage.writeTimeout = writeTimeout
+		return storage, nil
+	}
+}
+
+// WithListTimeout bounds each attempt of List and the other listing
+// operations, instead of the query timeout. Listing a large store
+// can take much longer than reading a single key.
+func WithListTimeout(timeout string) Option {
+	return func(storage Storage) (Storage, error) {
+		listTimeout, err := time.ParseDuration(timeout)
+		if err != nil {
+			return storage, fmt.Errorf("invalid list timeout: %w", err)
+		}
+		storage.listTimeout = listTimeout
+		return storage, nil
+	}
+}
+
+// timeout returns the timeout for an attempt of an operation of the
+// given class, falling back to the query timeout when unset.
+func (s Storage) timeout(class opClass) time.Duration {
+	var timeout time.Duration
+	switch class {
+	case opRead:
+		timeout = s.readTimeout
+	case opWrite:
+		timeout = s.writeTimeout
+	case opList:
+		timeout = s.listTimeout
+	}
+	if timeout == 0 {
+		return s.queryTimeout
+	}
+	return timeout
+}
@@ -0,0 +1,21 @@
+package certmagic_postgres
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestStorage_Timeout(t *testing.T) {
+	storage, err := Open(nil, WithQueryTimeout("3s"), WithListTimeout("30s"), WithWriteTimeout("5s"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, time.Second*3, storage.timeout(opDefault))
+	assert.Equal(t, time.Second*3, storage.timeout(opRead))
+	assert.Equal(t, time.Second*5, storage.timeout(opWrite))
+	assert.Equal(t, time.Second*30, storage.timeout(opList))
+
+	_, err = Open(nil, WithReadTimeout("soon"))
+	assert.NotNil(t, err)
+}
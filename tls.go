@@ -0,0 +1,159 @@
+package certmagic_postgres
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"os"
+	"strings"
+)
+
+// WithSSLMode sets how the connection to the database is secured, in
+// place of the sslmode connection parameter: "disable", "require",
+// "verify-ca" or "verify-full". When certificates are configured with
+// the other TLS options, it defaults to "verify-full" if a root
+// certificate is set and to "require" otherwise.
+func WithSSLMode(mode string) Option {
+	return func(storage Storage) (Storage, error) {
+		switch mode {
+		case "disable", "require", "verify-ca", "verify-full":
+			storage.sslMode = mode
+			return storage, nil
+		default:
+			return storage, fmt.Errorf("invalid sslmode: %s (must be disable, require, verify-ca or verify-full)", mode)
+		}
+	}
+}
+
+// WithSSLRootCert sets the certificate authorities the database's
+// certificate is verified against, given either as the path of a PEM
+// file or as the PEM encoded certificates themselves.
+func WithSSLRootCert(cert string) Option {
+	return func(storage Storage) (Storage, error) {
+		data, err := loadPEM("sslrootcert", cert)
+		if err != nil {
+			return storage, err
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(data) {
+			return storage, fmt.Errorf("no certificates found in sslrootcert")
+		}
+		storage.sslRootCert = data
+		return storage, nil
+	}
+}
+
+// WithSSLCert sets the client certificate presented to the database,
+// given either as the path of a PEM file or as PEM. It requires
+// WithSSLKey.
+func WithSSLCert(cert string) Option {
+	return func(storage Storage) (Storage, error) {
+		data, err := loadPEM("sslcert", cert)
+		if err != nil {
+			return storage, err
+		}
+		storage.sslCert = data
+		return storage, nil
+	}
+}
+
+// WithSSLKey sets the private key of the client certificate, given
+// either as the path of a PEM file or as PEM. It requires WithSSLCert.
+func WithSSLKey(key string) Option {
+	return func(storage Storage) (Storage, error) {
+		data, err := loadPEM("sslkey", key)
+		if err != nil {
+			return storage, err
+		}
+		storage.sslKey = data
+		return storage, nil
+	}
+}
+
+// loadPEM returns value if it is PEM, or else the contents of the
+// file it names. name is the option reported in errors.
+func loadPEM(name, value string) ([]byte, error) {
+	if strings.Contains(value, "-----BEGIN ") {
+		return []byte(value), nil
+	}
+	data, err := os.ReadFile(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return data, nil
+}
+
+// hasTLSConfig reports whether any TLS option was set.
+func (s Storage) hasTLSConfig() bool {
+	return s.sslMode != "" || s.sslRootCert != nil || s.sslCert != nil || s.sslKey != nil
+}
+
+// configureTLS replaces the TLS settings of config, parsed from the
+// connection string, with those set by the TLS options.
+func (s Storage) configureTLS(config *pgx.ConnConfig) error {
+	if (s.sslCert == nil) != (s.sslKey == nil) {
+		return fmt.Errorf("sslcert and sslkey must be set together")
+	}
+
+	mode := s.sslMode
+	if mode == "" {
+		mode = "require"
+		if s.sslRootCert != nil {
+			mode = "verify-full"
+		}
+	}
+
+	// The connection string may allow falling back to another mode
+	config.Fallbacks = nil
+	if mode == "disable" {
+		config.TLSConfig = nil
+		return nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if s.sslCert != nil {
+		cert, err := tls.X509KeyPair(s.sslCert, s.sslKey)
+		if err != nil {
+			return fmt.Errorf("invalid sslcert or sslkey: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	switch mode {
+	case "require":
+		tlsConfig.InsecureSkipVerify = true
+	case "verify-ca", "verify-full":
+		if s.sslRootCert == nil {
+			return fmt.Errorf("sslmode %s requires sslrootcert", mode)
+		}
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM(s.sslRootCert)
+		tlsConfig.RootCAs = roots
+		tlsConfig.ServerName = config.Host
+		if mode == "verify-ca" {
+			// Verify the chain but not the host name, as the standard library can't do so on its own
+			tlsConfig.InsecureSkipVerify = true
+			tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+				certs := make([]*x509.Certificate, len(rawCerts))
+				for i, raw := range rawCerts {
+					cert, err := x509.ParseCertificate(raw)
+					if err != nil {
+						return err
+					}
+					certs[i] = cert
+				}
+				if len(certs) == 0 {
+					return fmt.Errorf("database presented no certificate")
+				}
+				intermediates := x509.NewCertPool()
+				for _, cert := range certs[1:] {
+					intermediates.AddCert(cert)
+				}
+				_, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+				return err
+			}
+		}
+	}
+	config.TLSConfig = tlsConfig
+	return nil
+}
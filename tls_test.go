@@ -0,0 +1,69 @@
+package certmagic_postgres
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTLSOptions(t *testing.T) {
+	certPEM, keyPEM := selfSignedCert(t)
+	certFile := filepath.Join(t.TempDir(), "client.pem")
+	require.Nil(t, os.WriteFile(certFile, certPEM, 0600))
+
+	storage, err := Open(nil, WithSSLRootCert(string(certPEM)), WithSSLCert(certFile), WithSSLKey(string(keyPEM)))
+	require.Nil(t, err)
+	assert.Equal(t, certPEM, storage.sslRootCert)
+	assert.Equal(t, certPEM, storage.sslCert)
+
+	config, err := pgx.ParseConfig("postgres://db.example.com/certmagic?sslmode=prefer")
+	require.Nil(t, err)
+	require.Nil(t, storage.configureTLS(config))
+	assert.Empty(t, config.Fallbacks)
+	assert.Equal(t, "db.example.com", config.TLSConfig.ServerName)
+	assert.False(t, config.TLSConfig.InsecureSkipVerify)
+	assert.Len(t, config.TLSConfig.Certificates, 1)
+
+	_, err = Open(nil, WithSSLRootCert(filepath.Join(t.TempDir(), "missing.pem")))
+	assert.ErrorContains(t, err, "failed to read sslrootcert")
+	_, err = Open(nil, WithSSLMode("prefer"))
+	assert.NotNil(t, err)
+
+	storage, err = Open(nil, WithSSLCert(certFile))
+	require.Nil(t, err)
+	assert.NotNil(t, storage.configureTLS(config))
+
+	storage, err = Open(nil, WithSSLMode("verify-ca"))
+	require.Nil(t, err)
+	assert.ErrorContains(t, storage.configureTLS(config), "requires sslrootcert")
+}
+
+// selfSignedCert returns a PEM encoded self-signed certificate and its key.
+func selfSignedCert(t *testing.T) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "db.example.com"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.Nil(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
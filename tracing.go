@@ -0,0 +1,127 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"io/fs"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// tracerName names the tracer, and is the import path of the package.
+const tracerName = "github.com/fluidgalleries/certmagic-postgres"
+
+// WithTracerProvider traces every storage operation, with a span named
+// after the method called, such as Storage.Load, and every query made
+// on connections opened by Connect, with a child span carrying the
+// statement and the number of rows returned or affected. Spans are
+// children of the span in the context passed to the storage, and are
+// recorded by that span's provider if it is recording, so renewals can
+// be followed from Caddy into the database.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(storage Storage) (Storage, error) {
+		if provider == nil {
+			return storage, errors.New("invalid tracer provider: nil")
+		}
+		storage.tracer = provider.Tracer(tracerName)
+		return storage, nil
+	}
+}
+
+// startOperationSpan starts the span of an operation of the given
+// class, or returns a span recording nothing if tracing is disabled.
+func (s Storage) startOperationSpan(ctx context.Context, class opClass) (context.Context, trace.Span) {
+	if s.tracer == nil {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+	attributes := []attribute.KeyValue{
+		attribute.String("db.system", "postgresql"),
+		attribute.String("certmagic.operation_class", class.String()),
+	}
+	if s.keyPrefix != "" {
+		attributes = append(attributes, attribute.String("certmagic.key_prefix", s.keyPrefix))
+	}
+	return tracerFor(ctx, s.tracer).Start(ctx, operationName(), trace.WithAttributes(attributes...))
+}
+
+// tracerFor returns the tracer of the provider of the span in ctx if
+// it is recording, and fallback otherwise.
+func tracerFor(ctx context.Context, fallback trace.Tracer) trace.Tracer {
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		return span.TracerProvider().Tracer(tracerName)
+	}
+	return fallback
+}
+
+// endSpan ends span, recording err unless it reports a missing key.
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// operationName returns the name of the exported Storage method that
+// started the operation, such as Storage.Load, by walking up the call
+// stack past the unexported helpers running it.
+func operationName() string {
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		name := strings.TrimPrefix(frame.Function, tracerName+".")
+		if method, ok := strings.CutPrefix(name, "Storage."); ok && method != "" && method[0] >= 'A' && method[0] <= 'Z' {
+			if i := strings.Index(name, ".func"); i >= 0 {
+				name = name[:i]
+			}
+			return name
+		}
+		if !more {
+			return "Storage.operation"
+		}
+	}
+}
+
+// queryTracer records a span for every query logged by pgx.
+type queryTracer struct {
+	tracer trace.Tracer
+}
+
+// Log records a span for the query or exec logged, backdated to when
+// it started.
+func (t queryTracer) Log(ctx context.Context, level pgx.LogLevel, msg string, data map[string]interface{}) {
+	sql, ok := data["sql"].(string)
+	if !ok || (msg != "Query" && msg != "Exec") {
+		return
+	}
+	end := time.Now()
+	elapsed, _ := data["time"].(time.Duration)
+
+	name := msg
+	if fields := strings.Fields(sql); len(fields) > 0 {
+		name = strings.ToUpper(fields[0])
+	}
+	attributes := []attribute.KeyValue{
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.statement", sql),
+	}
+	if rowCount, ok := data["rowCount"].(int); ok {
+		attributes = append(attributes, attribute.Int("db.rows_returned", rowCount))
+	}
+	if commandTag, ok := data["commandTag"].(pgconn.CommandTag); ok {
+		attributes = append(attributes, attribute.Int64("db.rows_affected", commandTag.RowsAffected()))
+	}
+	_, span := tracerFor(ctx, t.tracer).Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithTimestamp(end.Add(-elapsed)), trace.WithAttributes(attributes...))
+	if err, ok := data["err"].(error); ok {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End(trace.WithTimestamp(end))
+}
@@ -0,0 +1,80 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"database/sql"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+	"time"
+)
+
+func TestStorage_OperationSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	// Nothing listens on port 1, so the query fails
+	db, err := sql.Open("pgx", "postgres://localhost:1/certmagic?connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	storage, err := Open(db, WithTracerProvider(provider), WithKeyPrefix("prod/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = storage.Load(context.Background(), "certificates/example.com.crt")
+	assert.NotNil(t, err)
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "Storage.Load", spans[0].Name())
+		assert.Equal(t, codes.Error, spans[0].Status().Code)
+		assert.Contains(t, spans[0].Attributes(), attribute.String("certmagic.operation_class", "read"))
+		assert.Contains(t, spans[0].Attributes(), attribute.String("certmagic.key_prefix", "prod/"))
+	}
+
+	_, err = Open(nil, WithTracerProvider(nil))
+	assert.NotNil(t, err)
+}
+
+func TestTracerFor(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	fallback := sdktrace.NewTracerProvider().Tracer(tracerName)
+
+	assert.Equal(t, fallback, tracerFor(context.Background(), fallback))
+
+	ctx, span := provider.Tracer("caddy").Start(context.Background(), "handshake")
+	_, child := tracerFor(ctx, fallback).Start(ctx, "Storage.Load")
+	child.End()
+	span.End()
+	if assert.Len(t, recorder.Ended(), 2) {
+		assert.Equal(t, span.SpanContext().SpanID(), recorder.Ended()[0].Parent().SpanID())
+	}
+}
+
+func TestQueryTracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := queryTracer{tracer: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer(tracerName)}
+
+	tracer.Log(context.Background(), pgx.LogLevelInfo, "Dialing PostgreSQL server", map[string]interface{}{"host": "localhost"})
+	tracer.Log(context.Background(), pgx.LogLevelInfo, "Query", map[string]interface{}{"sql": "SELECT value FROM certmagic_data", "time": time.Millisecond * 5, "rowCount": 3})
+	tracer.Log(context.Background(), pgx.LogLevelInfo, "Exec", map[string]interface{}{"sql": "DELETE FROM certmagic_data", "time": time.Millisecond, "commandTag": pgconn.CommandTag("DELETE 2")})
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 2) {
+		assert.Equal(t, "SELECT", spans[0].Name())
+		assert.Contains(t, spans[0].Attributes(), attribute.String("db.statement", "SELECT value FROM certmagic_data"))
+		assert.Contains(t, spans[0].Attributes(), attribute.Int("db.rows_returned", 3))
+		assert.Equal(t, time.Millisecond*5, spans[0].EndTime().Sub(spans[0].StartTime()))
+		assert.Equal(t, "DELETE", spans[1].Name())
+		assert.Contains(t, spans[1].Attributes(), attribute.Int64("db.rows_affected", 2))
+	}
+}
@@ -0,0 +1,51 @@
+package certmagic_postgres
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// WithUnixSocket has Connect connect over the Unix domain socket in
+// dir, such as /var/run/postgresql, in place of the host in the
+// connection string, allowing peer authentication as the user
+// running the process. The connection string may then be empty, or
+// only name the database and user.
+func WithUnixSocket(dir string) Option {
+	return func(storage Storage) (Storage, error) {
+		if !filepath.IsAbs(dir) {
+			return storage, fmt.Errorf("invalid unix socket directory: %s: must be an absolute path", dir)
+		}
+		info, err := os.Stat(dir)
+		if err != nil {
+			return storage, fmt.Errorf("invalid unix socket directory: %w", err)
+		}
+		if !info.IsDir() {
+			return storage, fmt.Errorf("invalid unix socket directory: %s: not a directory", dir)
+		}
+		storage.unixSocket = dir
+		return storage, nil
+	}
+}
+
+// configureUnixSocket points config at the socket directory. Sockets
+// carry no TLS, and a missing socket is reported clearly instead of
+// as a failed dial.
+func (s Storage) configureUnixSocket(config *pgx.ConnConfig) {
+	config.Host = s.unixSocket
+	config.TLSConfig = nil
+	config.Fallbacks = nil
+
+	path := filepath.Join(s.unixSocket, ".s.PGSQL."+strconv.Itoa(int(config.Port)))
+	dial := config.DialFunc
+	config.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("no PostgreSQL socket at %s: is the server running, listening on port %d, with unix_socket_directories including %s?", path, config.Port, s.unixSocket)
+		}
+		return dial(ctx, network, addr)
+	}
+}
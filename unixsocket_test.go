@@ -0,0 +1,49 @@
+package certmagic_postgres
+
+import (
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWithUnixSocket(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := Open(nil, WithUnixSocket(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, dir, storage.unixSocket)
+
+	_, err = Open(nil, WithUnixSocket("run/postgresql"))
+	assert.NotNil(t, err)
+
+	_, err = Open(nil, WithUnixSocket(filepath.Join(dir, "missing")))
+	assert.NotNil(t, err)
+
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Open(nil, WithUnixSocket(file))
+	assert.NotNil(t, err)
+}
+
+func TestConnect_UnixSocket(t *testing.T) {
+	dir := t.TempDir()
+	_, err := Connect("dbname=certmagic", WithUnixSocket(dir))
+	assert.ErrorContains(t, err, "no PostgreSQL socket at "+filepath.Join(dir, ".s.PGSQL.5432"))
+
+	storage, err := Open(nil, WithUnixSocket(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := pgx.ParseConfig("postgres://db.example.com:6432/certmagic?sslmode=require")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.configureUnixSocket(config)
+	assert.Equal(t, dir, config.Host)
+	assert.Nil(t, config.TLSConfig)
+}
@@ -0,0 +1,135 @@
+package certmagic_postgres
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultKeyCharacters are the characters found in keys written by
+// certmagic, suitable for KeyValidation.AllowedCharacters.
+const DefaultKeyCharacters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.@+~/"
+
+// KeyValidation describes the keys accepted by a Storage. Zero
+// values disable the corresponding check.
+type KeyValidation struct {
+	// MaxLength is the maximum length of a key in bytes.
+	MaxLength int
+
+	// AllowedCharacters is the set of characters keys may consist of.
+	AllowedCharacters string
+
+	// MinSegments is the minimum number of non-empty, "/"-separated
+	// segments a key must have, e.g. 2 to reject keys at the root.
+	MinSegments int
+}
+
+// InvalidKeyError is returned when a key is rejected by key validation.
+type InvalidKeyError struct {
+	Key    string
+	Reason string
+}
+
+func (e *InvalidKeyError) Error() string {
+	return fmt.Sprintf("invalid key %q: %s", e.Key, e.Reason)
+}
+
+// WithKeyValidation rejects keys not satisfying validation with an
+// *InvalidKeyError before they reach the database. It applies to every
+// operation taking a data key; lock names and list prefixes aren't
+// validated.
+func WithKeyValidation(validation KeyValidation) Option {
+	return func(storage Storage) (Storage, error) {
+		if validation.MaxLength < 0 || validation.MinSegments < 0 {
+			return storage, fmt.Errorf("invalid key validation: %+v", validation)
+		}
+		storage.keyValidation = validation
+		return storage, nil
+	}
+}
+
+// validateKey checks key against the configured key validation.
+func (s Storage) validateKey(key string) error {
+	v := s.keyValidation
+	if v == (KeyValidation{}) {
+		return nil
+	}
+
+	if key == "" {
+		return &InvalidKeyError{Key: key, Reason: "must not be empty"}
+	}
+	if v.MaxLength > 0 && len(key) > v.MaxLength {
+		return &InvalidKeyError{Key: key, Reason: fmt.Sprintf("longer than %d bytes", v.MaxLength)}
+	}
+	if !utf8.ValidString(key) {
+		return &InvalidKeyError{Key: key, Reason: "not valid UTF-8"}
+	}
+	if v.AllowedCharacters != "" {
+		for _, r := range key {
+			if !strings.ContainsRune(v.AllowedCharacters, r) {
+				return &InvalidKeyError{Key: key, Reason: fmt.Sprintf("character %q not allowed", r)}
+			}
+		}
+	}
+	if v.MinSegments > 0 {
+		segments := strings.Split(key, "/")
+		for _, segment := range segments {
+			if segment == "" {
+				return &InvalidKeyError{Key: key, Reason: "empty path segment"}
+			}
+		}
+		if len(segments) < v.MinSegments {
+			return &InvalidKeyError{Key: key, Reason: fmt.Sprintf("fewer than %d path segments", v.MinSegments)}
+		}
+	}
+
+	return nil
+}
+
+// validateKeys checks every one of keys against the configured key validation.
+func (s Storage) validateKeys(keys ...string) error {
+	for _, key := range keys {
+		if err := s.validateKey(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValueTooLargeError is returned when a value exceeds the
+// size configured with WithMaxValueSize.
+type ValueTooLargeError struct {
+	Key     string
+	Size    int
+	MaxSize int
+}
+
+func (e *ValueTooLargeError) Error() string {
+	return fmt.Sprintf("value for key %q is %d bytes, exceeding the maximum of %d bytes", e.Key, e.Size, e.MaxSize)
+}
+
+// Is reports whether target is ErrTooLarge.
+func (e *ValueTooLargeError) Is(target error) bool {
+	return target == ErrTooLarge
+}
+
+// WithMaxValueSize rejects writes of values larger than size bytes
+// with a *ValueTooLargeError, protecting shared databases from
+// runaway blobs. By default, value size is not limited.
+func WithMaxValueSize(size int) Option {
+	return func(storage Storage) (Storage, error) {
+		if size < 1 {
+			return storage, fmt.Errorf("invalid max value size: %d", size)
+		}
+		storage.maxValueSize = size
+		return storage, nil
+	}
+}
+
+// validateValue checks the size of value to be written at key.
+func (s Storage) validateValue(key string, value []byte) error {
+	if s.maxValueSize > 0 && len(value) > s.maxValueSize {
+		return &ValueTooLargeError{Key: key, Size: len(value), MaxSize: s.maxValueSize}
+	}
+	return nil
+}
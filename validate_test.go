@@ -0,0 +1,82 @@
+package certmagic_postgres_test
+
+import (
+	"context"
+	"errors"
+	"github.com/fluidgalleries/certmagic-postgres"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestStorage_WithKeyValidation(t *testing.T) {
+	storage, err := certmagic_postgres.Open(nil, certmagic_postgres.WithKeyValidation(certmagic_postgres.KeyValidation{
+		MaxLength:         64,
+		AllowedCharacters: certmagic_postgres.DefaultKeyCharacters,
+		MinSegments:       2,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name string
+		key  string
+	}{
+		{name: "empty", key: ""},
+		{name: "too long", key: "certificates/" + strings.Repeat("a", 64)},
+		{name: "disallowed character", key: "certificates/exa mple.com"},
+		{name: "root key", key: "certificates"},
+		{name: "empty segment", key: "certificates//example.com"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := storage.Store(context.Background(), tc.key, []byte("value"))
+			var invalidKey *certmagic_postgres.InvalidKeyError
+			assert.True(t, errors.As(err, &invalidKey))
+			assert.Equal(t, tc.key, invalidKey.Key)
+			assert.NotEmpty(t, invalidKey.Reason)
+
+			_, err = storage.Load(context.Background(), tc.key)
+			assert.True(t, errors.As(err, &invalidKey))
+			assert.False(t, storage.Exists(context.Background(), tc.key))
+		})
+	}
+}
+
+func TestStorage_WithKeyValidation_Valid(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	storage, err := certmagic_postgres.Open(db, certmagic_postgres.WithKeyValidation(certmagic_postgres.KeyValidation{
+		MaxLength:         255,
+		AllowedCharacters: certmagic_postgres.DefaultKeyCharacters,
+		MinSegments:       2,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.Store(context.Background(), "certificates/acme/wildcard_.example.com/wildcard_.example.com.crt", []byte("value"))
+	assert.Nil(t, err)
+}
+
+func TestStorage_WithMaxValueSize(t *testing.T) {
+	storage, err := certmagic_postgres.Open(nil, certmagic_postgres.WithMaxValueSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.Store(context.Background(), "abc", []byte("value"))
+	var tooLarge *certmagic_postgres.ValueTooLargeError
+	assert.True(t, errors.As(err, &tooLarge))
+	assert.Equal(t, 5, tooLarge.Size)
+	assert.Equal(t, 4, tooLarge.MaxSize)
+	assert.True(t, errors.Is(err, certmagic_postgres.ErrTooLarge))
+
+	err = storage.StoreBatch(context.Background(), map[string][]byte{"abc": []byte("value")})
+	assert.True(t, errors.As(err, &tooLarge))
+
+	_, err = certmagic_postgres.Open(nil, certmagic_postgres.WithMaxValueSize(0))
+	assert.NotNil(t, err)
+}
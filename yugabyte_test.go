@@ -0,0 +1,52 @@
+package certmagic_postgres_test
+
+import (
+	"context"
+	"database/sql"
+	"github.com/fluidgalleries/certmagic-postgres"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+// TestStorage_YugabyteDB runs against a YugabyteDB container, e.g.
+//
+//	docker run -d -p 5433:5433 yugabytedb/yugabyte bin/yugabyted start --background=false
+//	TEST_YUGABYTE_CONNECTION_STRING=postgres://yugabyte@localhost:5433/yugabyte go test -run YugabyteDB
+func TestStorage_YugabyteDB(t *testing.T) {
+	connectionString := os.Getenv("TEST_YUGABYTE_CONNECTION_STRING")
+	if connectionString == "" {
+		t.Skip("set TEST_YUGABYTE_CONNECTION_STRING to run this test")
+	}
+
+	db, err := sql.Open("pgx", connectionString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrateDown(t, db)
+	defer migrateDown(t, db)
+	db.Close()
+
+	storage, err := certmagic_postgres.Connect(connectionString, certmagic_postgres.WithAutoMigrate())
+	require.Nil(t, err)
+	defer storage.Close()
+
+	err = storage.Store(context.Background(), "abc", []byte("value"))
+	require.Nil(t, err)
+	value, err := storage.Load(context.Background(), "abc")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+
+	err = storage.Lock(context.Background(), "abc")
+	require.Nil(t, err)
+	locked, err := storage.TryLock(context.Background(), "abc")
+	require.Nil(t, err)
+	assert.False(t, locked)
+	err = storage.Unlock(context.Background(), "abc")
+	require.Nil(t, err)
+
+	applied, err := storage.Migrate(context.Background())
+	require.Nil(t, err)
+	assert.Empty(t, applied)
+}